@@ -2,9 +2,15 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sync"
+	"time"
+
+	"github.com/harshvasudeva/synctabs-companion/schema"
 )
 
 const AppName = "SyncTabs Companion"
@@ -13,16 +19,323 @@ const AppName = "SyncTabs Companion"
 var AppVersion = "1.0.0"
 
 const DefaultPort = 9234
-const StaleDays = 30
+
+// Encryption key sources (see package crypt)
+const (
+	KeySourceFile       = "keyfile"
+	KeySourcePassphrase = "passphrase"
+)
+
+// Incognito tab policies
+const (
+	IncognitoDrop       = "drop"        // never accepted
+	IncognitoOnlineOnly = "online-only" // shown to peers while the browser is online, never saved
+	IncognitoSync       = "sync"        // treated like normal tabs
+)
+
+// DefaultRetentionDays is how long an offline browser's state and
+// undelivered pending tabs are kept before they expire.
+const DefaultRetentionDays = 30
+
+// Pending queue overflow policies: what happens when a tab is sent to an
+// offline browser whose queue is already at its limit
+const (
+	OverflowReject     = "reject"      // refuse the new tab
+	OverflowDropOldest = "drop-oldest" // make room by dropping the oldest queued tab
+	OverflowCollapse   = "collapse"    // fold overflow into one "N tabs waiting" entry
+)
+
+// DefaultPendingLimit is how many tabs may wait for one offline browser.
+const DefaultPendingLimit = 50
+
+// MaxPendingLimit caps every configured pending limit.
+const MaxPendingLimit = 1000
+
+// PendingQueue limits the queues of tabs waiting for offline browsers.
+type PendingQueue struct {
+	Limit    int    `json:"limit"`
+	Overflow string `json:"overflow"` // "reject", "drop-oldest" or "collapse"
+	// Targets overrides Limit and/or Overflow per target browser ID.
+	Targets map[string]PendingTarget `json:"targets,omitempty"`
+}
+
+// PendingTarget overrides the queue settings for one browser; zero values
+// inherit the defaults.
+type PendingTarget struct {
+	Limit    int    `json:"limit,omitempty"`
+	Overflow string `json:"overflow,omitempty"`
+}
+
+// For returns the limit and overflow policy for a target browser.
+func (q PendingQueue) For(browserId string) (int, string) {
+	limit, overflow := q.Limit, q.Overflow
+	if t, ok := q.Targets[browserId]; ok {
+		if t.Limit > 0 {
+			limit = t.Limit
+		}
+		if t.Overflow != "" {
+			overflow = t.Overflow
+		}
+	}
+	return limit, overflow
+}
+
+// normalise replaces out-of-range values with defaults.
+func (q *PendingQueue) normalise() {
+	if q.Limit < 1 || q.Limit > MaxPendingLimit {
+		q.Limit = DefaultPendingLimit
+	}
+	if !validOverflow(q.Overflow) {
+		q.Overflow = OverflowReject
+	}
+	for id, t := range q.Targets {
+		if t.Limit < 0 || t.Limit > MaxPendingLimit {
+			t.Limit = 0
+		}
+		if !validOverflow(t.Overflow) {
+			t.Overflow = ""
+		}
+		q.Targets[id] = t
+	}
+}
+
+func validOverflow(p string) bool {
+	return p == OverflowReject || p == OverflowDropOldest || p == OverflowCollapse
+}
+
+// PrivacyRules control what leaves a browser. They are applied before
+// tabs are saved, broadcast, queued as pending or logged.
+type PrivacyRules struct {
+	// BlockDomains drops tabs on these domains (and their subdomains).
+	BlockDomains []string `json:"blockDomains"`
+	// BlockPatterns drops tabs whose full URL matches any regex.
+	BlockPatterns []string `json:"blockPatterns"`
+	// StripParams removes these query parameters (case-insensitive).
+	StripParams []string `json:"stripParams"`
+	// MaskParams keeps these query parameters but replaces their values.
+	MaskParams []string `json:"maskParams"`
+	// TitleRules replace the title of matching tabs.
+	TitleRules []TitleRule `json:"titleRules"`
+}
+
+// TitleRule replaces the title of tabs on Domain (and subdomains) or whose
+// URL matches Pattern. At least one of Domain or Pattern must be set.
+type TitleRule struct {
+	Domain  string `json:"domain,omitempty"`
+	Pattern string `json:"pattern,omitempty"`
+	Title   string `json:"title"`
+}
+
+// Validate checks that every regex compiles and every title rule has a matcher.
+func (p PrivacyRules) Validate() error {
+	for _, pat := range p.BlockPatterns {
+		if _, err := regexp.Compile(pat); err != nil {
+			return fmt.Errorf("blockPatterns: %w", err)
+		}
+	}
+	for _, r := range p.TitleRules {
+		if r.Domain == "" && r.Pattern == "" {
+			return errors.New("titleRules: each rule needs a domain or pattern")
+		}
+		if r.Pattern != "" {
+			if _, err := regexp.Compile(r.Pattern); err != nil {
+				return fmt.Errorf("titleRules: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// RouteRule sends tabs on Domain (and subdomains) and/or whose URL matches
+// Pattern to Target — a browser ID, alias or browser name — when a tab is
+// sent with target "auto". Rules are tried in order; the first match wins.
+type RouteRule struct {
+	Domain  string `json:"domain,omitempty"`
+	Pattern string `json:"pattern,omitempty"`
+	Target  string `json:"target"`
+}
+
+// ValidateRoutingRules checks that every rule has a matcher and a target
+// and that every regex compiles.
+func ValidateRoutingRules(rules []RouteRule) error {
+	for i, r := range rules {
+		if r.Domain == "" && r.Pattern == "" {
+			return fmt.Errorf("routingRules[%d]: needs a domain or pattern", i)
+		}
+		if r.Target == "" {
+			return fmt.Errorf("routingRules[%d]: needs a target", i)
+		}
+		if r.Pattern != "" {
+			if _, err := regexp.Compile(r.Pattern); err != nil {
+				return fmt.Errorf("routingRules[%d]: %w", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// MirrorRule keeps a set of tabs open in several browsers: opening or
+// closing a matching tab in one is repeated in the others. A tab matches
+// when it is pinned (Pinned), in the window named Window and/or its URL
+// matches Pattern; set selectors must all hold. Browsers lists the browser
+// IDs taking part (all when empty).
+type MirrorRule struct {
+	Name     string   `json:"name"`
+	Pinned   bool     `json:"pinned,omitempty"`
+	Window   string   `json:"window,omitempty"`
+	Pattern  string   `json:"pattern,omitempty"`
+	Browsers []string `json:"browsers,omitempty"`
+}
+
+// ValidateMirrorRules checks that every rule has a unique name and a
+// selector and that every regex compiles.
+func ValidateMirrorRules(rules []MirrorRule) error {
+	names := make(map[string]bool, len(rules))
+	for i, r := range rules {
+		if r.Name == "" {
+			return fmt.Errorf("mirrorRules[%d]: needs a name", i)
+		}
+		if names[r.Name] {
+			return fmt.Errorf("mirrorRules[%d]: duplicate name %q", i, r.Name)
+		}
+		names[r.Name] = true
+		if !r.Pinned && r.Window == "" && r.Pattern == "" {
+			return fmt.Errorf("mirrorRules[%d]: needs pinned, window or pattern", i)
+		}
+		if r.Pattern != "" {
+			if _, err := regexp.Compile(r.Pattern); err != nil {
+				return fmt.Errorf("mirrorRules[%d]: %w", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// IncomingRules filter tabs sent to one browser. Senders in AllowSenders
+// (browser IDs) are accepted; others are held for the target to confirm
+// if ConfirmUnknown is set, and rejected otherwise. An empty AllowSenders
+// accepts every sender unless ConfirmUnknown is set. URLs on BlockDomains
+// (and subdomains) or matching BlockPatterns are always rejected.
+type IncomingRules struct {
+	AllowSenders   []string `json:"allowSenders,omitempty"`
+	ConfirmUnknown bool     `json:"confirmUnknown,omitempty"`
+	BlockDomains   []string `json:"blockDomains,omitempty"`
+	BlockPatterns  []string `json:"blockPatterns,omitempty"`
+}
+
+// ValidateIncomingRules checks that every regex compiles.
+func ValidateIncomingRules(rules map[string]IncomingRules) error {
+	for target, r := range rules {
+		for _, pat := range r.BlockPatterns {
+			if _, err := regexp.Compile(pat); err != nil {
+				return fmt.Errorf("incomingRules[%s]: %w", target, err)
+			}
+		}
+	}
+	return nil
+}
+
+// QuietWindow is a daily do-not-disturb window in local time, e.g.
+// {"start": "22:00", "end": "07:00"}. A window ending before it starts runs
+// past midnight; equal times cover the whole day. Days, if set, limits it
+// to the weekdays it starts on ("mon" … "sun").
+type QuietWindow struct {
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+	Days  []string `json:"days,omitempty"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseClock returns the minutes since midnight of an "HH:MM" time.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q (want HH:MM)", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Active reports whether t falls inside the window and, if so, when that
+// occurrence of the window ends. Windows are assumed valid.
+func (w QuietWindow) Active(t time.Time) (time.Time, bool) {
+	start, err1 := parseClock(w.Start)
+	end, err2 := parseClock(w.End)
+	if err1 != nil || err2 != nil {
+		return time.Time{}, false
+	}
+	wrap := 0
+	if end <= start {
+		wrap = 1
+	}
+	// The occurrence containing t started today or, past midnight, yesterday.
+	// Both ends are wall-clock times, so a DST change inside the window
+	// shortens or lengthens it rather than moving its end
+	for back := 0; back <= 1; back++ {
+		from := time.Date(t.Year(), t.Month(), t.Day()-back, start/60, start%60, 0, 0, t.Location())
+		until := time.Date(t.Year(), t.Month(), t.Day()-back+wrap, end/60, end%60, 0, 0, t.Location())
+		if t.Before(from) || !t.Before(until) || !w.onDay(from.Weekday()) {
+			continue
+		}
+		return until, true
+	}
+	return time.Time{}, false
+}
+
+func (w QuietWindow) onDay(d time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, name := range w.Days {
+		if wd, ok := weekdays[name]; ok && wd == d {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateQuietHours checks every window's times and day names.
+func ValidateQuietHours(hours map[string][]QuietWindow) error {
+	for target, windows := range hours {
+		for i, w := range windows {
+			if _, err := parseClock(w.Start); err != nil {
+				return fmt.Errorf("quietHours[%s][%d]: %w", target, i, err)
+			}
+			if _, err := parseClock(w.End); err != nil {
+				return fmt.Errorf("quietHours[%s][%d]: %w", target, i, err)
+			}
+			for _, d := range w.Days {
+				if _, ok := weekdays[d]; !ok {
+					return fmt.Errorf("quietHours[%s][%d]: unknown day %q", target, i, d)
+				}
+			}
+		}
+	}
+	return nil
+}
 
 // Config holds all companion configuration.
 type Config struct {
-	Port              int    `json:"port"`
-	DataFolder        string `json:"dataFolder"`
-	LogLevel          string `json:"logLevel"`
-	MaxTabsPerBrowser int    `json:"maxTabsPerBrowser"`
-	AutoStart         bool   `json:"autoStart"`
-	Version           string `json:"version"`
+	Port                int                      `json:"port"`
+	DataFolder          string                   `json:"dataFolder"`
+	LogLevel            string                   `json:"logLevel"`
+	MaxTabsPerBrowser   int                      `json:"maxTabsPerBrowser"`
+	AutoStart           bool                     `json:"autoStart"`
+	RetentionDays       int                      `json:"retentionDays"`
+	EncryptData         bool                     `json:"encryptData"`
+	EncryptionKeySource string                   `json:"encryptionKeySource"` // "keyfile" or "passphrase"
+	IncognitoPolicy     string                   `json:"incognitoPolicy"`     // "drop", "online-only" or "sync"
+	PrivacyRules        PrivacyRules             `json:"privacyRules"`
+	PendingQueue        PendingQueue             `json:"pendingQueue"`
+	RoutingRules        []RouteRule              `json:"routingRules"`
+	IncomingRules       map[string]IncomingRules `json:"incomingRules,omitempty"` // by target browser ID
+	QuietHours          map[string][]QuietWindow `json:"quietHours,omitempty"`    // by target browser ID
+	MirrorRules         []MirrorRule             `json:"mirrorRules,omitempty"`
+	Version             string                   `json:"version"`
+	SchemaVersion       int                      `json:"schemaVersion"`
 }
 
 var (
@@ -49,12 +362,18 @@ func ConfigPath() string {
 func defaults() Config {
 	appData := AppDataDir()
 	return Config{
-		Port:              DefaultPort,
-		DataFolder:        filepath.Join(appData, "data"),
-		LogLevel:          "info",
-		MaxTabsPerBrowser: 500,
-		AutoStart:         false,
-		Version:           AppVersion,
+		Port:                DefaultPort,
+		DataFolder:          filepath.Join(appData, "data"),
+		LogLevel:            "info",
+		MaxTabsPerBrowser:   500,
+		AutoStart:           false,
+		RetentionDays:       DefaultRetentionDays,
+		EncryptData:         false,
+		EncryptionKeySource: KeySourceFile,
+		IncognitoPolicy:     IncognitoOnlineOnly,
+		PendingQueue:        PendingQueue{Limit: DefaultPendingLimit, Overflow: OverflowReject},
+		Version:             AppVersion,
+		SchemaVersion:       schema.Current(schema.Config),
 	}
 }
 
@@ -83,6 +402,19 @@ func Load() error {
 		return err
 	}
 
+	// Bring older files up to date; refuse files from a newer companion
+	// rather than resetting them to defaults
+	raw := data
+	data, from, err := schema.DecodeFlat(schema.Config, raw)
+	if err != nil {
+		return err
+	}
+	if from < def.SchemaVersion {
+		if _, err := schema.Backup(cfgPath, raw, from); err != nil {
+			return err
+		}
+	}
+
 	// Start with defaults, then overlay with file values
 	loaded := def
 	if err := json.Unmarshal(data, &loaded); err != nil {
@@ -95,6 +427,7 @@ func Load() error {
 
 	// Ensure version is always current
 	loaded.Version = AppVersion
+	loaded.SchemaVersion = def.SchemaVersion
 
 	// Ensure required fields have valid values
 	if loaded.Port == 0 {
@@ -109,6 +442,32 @@ func Load() error {
 	if loaded.MaxTabsPerBrowser == 0 {
 		loaded.MaxTabsPerBrowser = 500
 	}
+	if loaded.RetentionDays < 1 {
+		loaded.RetentionDays = DefaultRetentionDays
+	}
+	if loaded.EncryptionKeySource != KeySourcePassphrase {
+		loaded.EncryptionKeySource = KeySourceFile
+	}
+	if !validIncognitoPolicy(loaded.IncognitoPolicy) {
+		loaded.IncognitoPolicy = IncognitoOnlineOnly
+	}
+	loaded.PendingQueue.normalise()
+	if err := loaded.PrivacyRules.Validate(); err != nil {
+		// Never run with half-valid privacy rules; fail loudly instead
+		return fmt.Errorf("config.json privacyRules: %w", err)
+	}
+	if err := ValidateRoutingRules(loaded.RoutingRules); err != nil {
+		return fmt.Errorf("config.json %w", err)
+	}
+	if err := ValidateIncomingRules(loaded.IncomingRules); err != nil {
+		return fmt.Errorf("config.json %w", err)
+	}
+	if err := ValidateQuietHours(loaded.QuietHours); err != nil {
+		return fmt.Errorf("config.json %w", err)
+	}
+	if err := ValidateMirrorRules(loaded.MirrorRules); err != nil {
+		return fmt.Errorf("config.json %w", err)
+	}
 
 	mu.Lock()
 	current = loaded
@@ -123,6 +482,7 @@ func Save() error {
 	mu.RUnlock()
 
 	c.Version = AppVersion
+	c.SchemaVersion = schema.Current(schema.Config)
 
 	data, err := json.MarshalIndent(c, "", "  ")
 	if err != nil {
@@ -141,6 +501,22 @@ func Save() error {
 	return os.Rename(tmp, cfgPath)
 }
 
+func validIncognitoPolicy(p string) bool {
+	return p == IncognitoDrop || p == IncognitoOnlineOnly || p == IncognitoSync
+}
+
+// RetentionCutoff returns the time before which offline browsers and
+// pending tabs are considered expired.
+func RetentionCutoff() time.Time {
+	mu.RLock()
+	days := current.RetentionDays
+	mu.RUnlock()
+	if days < 1 {
+		days = DefaultRetentionDays
+	}
+	return time.Now().AddDate(0, 0, -days)
+}
+
 // Get returns a copy of the current config (thread-safe).
 func Get() Config {
 	mu.RLock()
@@ -202,6 +578,31 @@ func Update(partial map[string]interface{}) (restartNeeded bool, dataFolderChang
 	if newCfg.MaxTabsPerBrowser < 1 || newCfg.MaxTabsPerBrowser > 10000 {
 		newCfg.MaxTabsPerBrowser = 500
 	}
+	if newCfg.RetentionDays < 1 || newCfg.RetentionDays > 3650 {
+		newCfg.RetentionDays = DefaultRetentionDays
+	}
+	if newCfg.EncryptionKeySource != KeySourcePassphrase {
+		newCfg.EncryptionKeySource = KeySourceFile
+	}
+	if !validIncognitoPolicy(newCfg.IncognitoPolicy) {
+		newCfg.IncognitoPolicy = IncognitoOnlineOnly
+	}
+	newCfg.PendingQueue.normalise()
+	if err := newCfg.PrivacyRules.Validate(); err != nil {
+		return false, false, err
+	}
+	if err := ValidateRoutingRules(newCfg.RoutingRules); err != nil {
+		return false, false, err
+	}
+	if err := ValidateIncomingRules(newCfg.IncomingRules); err != nil {
+		return false, false, err
+	}
+	if err := ValidateQuietHours(newCfg.QuietHours); err != nil {
+		return false, false, err
+	}
+	if err := ValidateMirrorRules(newCfg.MirrorRules); err != nil {
+		return false, false, err
+	}
 	validLogLevels := map[string]bool{"debug": true, "info": true, "warn": true, "error": true}
 	if !validLogLevels[newCfg.LogLevel] {
 		newCfg.LogLevel = "info"
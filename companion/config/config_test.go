@@ -0,0 +1,59 @@
+package config
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestQuietWindowActive(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(loc *time.Location, y int, m time.Month, d, h, min int) time.Time {
+		return time.Date(y, m, d, h, min, 0, 0, loc)
+	}
+	utc := time.UTC
+	night := QuietWindow{Start: "22:00", End: "07:00"}
+	office := QuietWindow{Start: "09:00", End: "17:00"}
+
+	tests := []struct {
+		name   string
+		w      QuietWindow
+		t      time.Time
+		active bool
+		until  time.Time
+	}{
+		{"before same-day window", office, at(utc, 2026, 10, 16, 8, 59), false, time.Time{}},
+		{"start is inside", office, at(utc, 2026, 10, 16, 9, 0), true, at(utc, 2026, 10, 16, 17, 0)},
+		{"end is outside", office, at(utc, 2026, 10, 16, 17, 0), false, time.Time{}},
+		{"wrap before midnight", night, at(utc, 2026, 10, 17, 23, 0), true, at(utc, 2026, 10, 18, 7, 0)},
+		{"wrap after midnight", night, at(utc, 2026, 10, 18, 6, 59), true, at(utc, 2026, 10, 18, 7, 0)},
+		{"wrap ended", night, at(utc, 2026, 10, 18, 7, 0), false, time.Time{}},
+		{"wrap not started", night, at(utc, 2026, 10, 18, 21, 59), false, time.Time{}},
+		{"wrap across month end", night, at(utc, 2026, 11, 1, 1, 0), true, at(utc, 2026, 11, 1, 7, 0)},
+		{"equal times cover the day", QuietWindow{Start: "08:00", End: "08:00"}, at(utc, 2026, 10, 18, 7, 0), true, at(utc, 2026, 10, 18, 8, 0)},
+		{"day matches", QuietWindow{Start: "22:00", End: "07:00", Days: []string{"fri"}}, at(utc, 2026, 10, 16, 23, 0), true, at(utc, 2026, 10, 17, 7, 0)},
+		{"day is the start day", QuietWindow{Start: "22:00", End: "07:00", Days: []string{"fri"}}, at(utc, 2026, 10, 17, 6, 0), true, at(utc, 2026, 10, 17, 7, 0)},
+		{"other day", QuietWindow{Start: "22:00", End: "07:00", Days: []string{"fri"}}, at(utc, 2026, 10, 17, 23, 0), false, time.Time{}},
+		{"weekend only", QuietWindow{Start: "09:00", End: "17:00", Days: []string{"sat", "sun"}}, at(utc, 2026, 10, 16, 12, 0), false, time.Time{}},
+		{"spring forward keeps wall-clock end", night, at(ny, 2026, 3, 8, 5, 0), true, at(ny, 2026, 3, 8, 7, 0)},
+		{"spring forward before the jump", night, at(ny, 2026, 3, 8, 1, 30), true, at(ny, 2026, 3, 8, 7, 0)},
+		{"spring forward after the end", night, at(ny, 2026, 3, 8, 7, 30), false, time.Time{}},
+		{"fall back keeps wall-clock end", night, at(ny, 2026, 11, 1, 6, 30), true, at(ny, 2026, 11, 1, 7, 0)},
+		{"fall back start", QuietWindow{Start: "00:30", End: "06:00"}, at(ny, 2026, 11, 1, 0, 45), true, at(ny, 2026, 11, 1, 6, 0)},
+		{"invalid time", QuietWindow{Start: "25:00", End: "07:00"}, at(utc, 2026, 10, 18, 1, 0), false, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			until, active := tt.w.Active(tt.t)
+			if active != tt.active {
+				t.Fatalf("Active(%v) = %v, want %v", tt.t, active, tt.active)
+			}
+			if !until.Equal(tt.until) {
+				t.Errorf("Active(%v) until = %v, want %v", tt.t, until, tt.until)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const tokenFileName = "api-token"
+
+// APITokenPath returns the path of the local API token, next to config.json.
+func APITokenPath() string {
+	return filepath.Join(AppDataDir(), tokenFileName)
+}
+
+// APIToken returns the per-install token that local clients without an
+// extension origin (e.g. the send command) present to the companion. It
+// is created (0600) on first use, and replaced if it is too short.
+func APIToken() (string, error) {
+	path := APITokenPath()
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if token := strings.TrimSpace(string(data)); len(token) >= 32 {
+			return token, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+	if err := os.WriteFile(path, []byte(token), 0600); err != nil {
+		return "", err
+	}
+	return token, nil
+}
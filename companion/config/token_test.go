@@ -0,0 +1,46 @@
+package config
+
+import (
+	"os"
+	"testing"
+)
+
+func TestAPIToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string // "" for no file
+		keep     bool
+	}{
+		{"created on first use", "", false},
+		{"kept", "0123456789abcdef0123456789abcdef\n", true},
+		{"too short, replaced", "short", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("APPDATA", t.TempDir())
+			if tt.existing != "" {
+				_ = os.MkdirAll(AppDataDir(), 0755)
+				if err := os.WriteFile(APITokenPath(), []byte(tt.existing), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			token, err := APIToken()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(token) < 32 {
+				t.Errorf("token %q too short", token)
+			}
+			if kept := tt.existing != "" && token+"\n" == tt.existing; kept != tt.keep {
+				t.Errorf("existing token kept = %v, want %v", kept, tt.keep)
+			}
+			again, err := APIToken()
+			if err != nil || again != token {
+				t.Errorf("second APIToken = %q, %v; want the same token", again, err)
+			}
+			if fi, err := os.Stat(APITokenPath()); err == nil && fi.Mode().Perm() != 0600 {
+				t.Errorf("token file mode = %v, want 0600", fi.Mode().Perm())
+			}
+		})
+	}
+}
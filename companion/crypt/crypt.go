@@ -0,0 +1,340 @@
+// Package crypt provides optional authenticated encryption (AES-256-GCM)
+// for companion data files. Encrypted files start with a short magic header;
+// files without it are read as plaintext, so stores can switch encryption
+// on or off without special handling.
+package crypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// PassphraseEnv is the environment variable read in passphrase mode.
+const PassphraseEnv = "SYNCTABS_PASSPHRASE"
+
+const (
+	keyFileName      = "data.key"
+	saltFileName     = "data.salt"
+	pbkdf2Iterations = 200000
+	keyLen           = 32
+)
+
+var magic = []byte("SYNCTABS-ENC1\n")
+
+var (
+	ErrNoKey     = errors.New("file is encrypted but no encryption key is available")
+	ErrDecrypt   = errors.New("decryption failed (wrong key or tampered file)")
+	ErrNoPassEnv = fmt.Errorf("passphrase mode requires %s to be set", PassphraseEnv)
+)
+
+var (
+	mu      sync.RWMutex
+	enabled bool
+	key     []byte
+	aead    cipher.AEAD
+	prev    cipher.AEAD // key replaced by Init, still accepted when reading
+)
+
+// Init configures encryption. keyDir holds the key (or salt) file and should
+// be outside the data folder. The key comes from keyDir/data.key, or is
+// derived from $SYNCTABS_PASSPHRASE when usePassphrase is set. When enabled,
+// a missing key file or salt is created; when disabled, an existing key is
+// still loaded (if possible) so previously encrypted files remain readable
+// and are rewritten as plaintext.
+//
+// When the key changes (e.g. a different key source), the previous key is
+// kept for reading until the process exits, so files it encrypted stay
+// readable until Migrate re-encrypts them with the new one.
+func Init(keyDir string, enable, usePassphrase bool) error {
+	var newKey []byte
+	var err error
+
+	if usePassphrase {
+		pass := os.Getenv(PassphraseEnv)
+		if pass == "" && enable {
+			return ErrNoPassEnv
+		}
+		if pass != "" {
+			newKey, err = passphraseKey(keyDir, pass, enable)
+		}
+	} else {
+		newKey, err = fileKey(keyDir, enable)
+	}
+	if err != nil {
+		return err
+	}
+
+	var a cipher.AEAD
+	if newKey != nil {
+		block, err := aes.NewCipher(newKey)
+		if err != nil {
+			return err
+		}
+		if a, err = cipher.NewGCM(block); err != nil {
+			return err
+		}
+	}
+
+	mu.Lock()
+	if aead != nil && !bytes.Equal(key, newKey) {
+		prev = aead
+	}
+	enabled = enable
+	key = newKey
+	aead = a
+	mu.Unlock()
+	return nil
+}
+
+// Enabled reports whether new writes are encrypted.
+func Enabled() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return enabled
+}
+
+// fileKey reads keyDir/data.key, creating it (0600) if create is set.
+// Returns nil without error if the file is absent and create is false.
+func fileKey(keyDir string, create bool) ([]byte, error) {
+	path := filepath.Join(keyDir, keyFileName)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) != keyLen {
+			return nil, fmt.Errorf("invalid key file %s", path)
+		}
+		_ = os.Chmod(path, 0600)
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if !create {
+		return nil, nil
+	}
+
+	key := make([]byte, keyLen)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(keyDir, 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key)), 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// passphraseKey derives a key from pass and the salt in keyDir/data.salt,
+// creating the salt if create is set.
+func passphraseKey(keyDir, pass string, create bool) ([]byte, error) {
+	path := filepath.Join(keyDir, saltFileName)
+	salt, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		if !create {
+			return nil, nil
+		}
+		salt = make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(keyDir, 0700); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, salt, 0600); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	return pbkdf2.Key([]byte(pass), salt, pbkdf2Iterations, keyLen, sha256.New), nil
+}
+
+// IsEncrypted reports whether data carries the encrypted-file header.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, magic)
+}
+
+// Encrypt seals plaintext into the on-disk format (header, nonce, ciphertext).
+func Encrypt(plaintext []byte) ([]byte, error) {
+	mu.RLock()
+	a := aead
+	mu.RUnlock()
+	if a == nil {
+		return nil, ErrNoKey
+	}
+	nonce := make([]byte, a.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(magic)+len(nonce)+len(plaintext)+a.Overhead())
+	out = append(out, magic...)
+	out = append(out, nonce...)
+	return a.Seal(out, nonce, plaintext, magic), nil
+}
+
+// Decrypt opens data written by Encrypt, with the current key or else the
+// one it replaced. Plaintext input is returned as-is.
+func Decrypt(data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return data, nil
+	}
+	mu.RLock()
+	a, p := aead, prev
+	mu.RUnlock()
+	if a == nil && p == nil {
+		return nil, ErrNoKey
+	}
+	if plain, ok := open(a, data); ok {
+		return plain, nil
+	}
+	if plain, ok := open(p, data); ok {
+		return plain, nil
+	}
+	return nil, ErrDecrypt
+}
+
+// open decrypts data (which has the header) with a, if a is set.
+func open(a cipher.AEAD, data []byte) ([]byte, bool) {
+	if a == nil {
+		return nil, false
+	}
+	body := data[len(magic):]
+	if len(body) < a.NonceSize() {
+		return nil, false
+	}
+	nonce, ct := body[:a.NonceSize()], body[a.NonceSize():]
+	plain, err := a.Open(nil, nonce, ct, magic)
+	return plain, err == nil
+}
+
+// current reports whether data is in the form new writes take: encrypted
+// with the current key when encryption is on, plaintext when it is off.
+func current(data []byte, want bool) bool {
+	if !IsEncrypted(data) || !want {
+		return IsEncrypted(data) == want
+	}
+	mu.RLock()
+	a := aead
+	mu.RUnlock()
+	_, ok := open(a, data)
+	return ok
+}
+
+// ReadFile reads a data file, decrypting it if needed.
+func ReadFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := Decrypt(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	return plain, nil
+}
+
+// WriteFile writes a data file with 0600 permissions, encrypting it when
+// encryption is enabled.
+func WriteFile(path string, data []byte) error {
+	if Enabled() {
+		enc, err := Encrypt(data)
+		if err != nil {
+			return err
+		}
+		data = enc
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of an existing file; tighten it explicitly
+	return os.Chmod(path, 0600)
+}
+
+// isDataFile reports whether name is a data file or a backup of one
+// (*.json, *.json.N.bak, *.json.vN.bak). Quarantined and temporary files
+// are left alone.
+func isDataFile(name string) bool {
+	if strings.HasSuffix(name, ".json") {
+		return true
+	}
+	return strings.Contains(name, ".json.") && strings.HasSuffix(name, ".bak")
+}
+
+// Migrate rewrites every data file and backup in folder, and every file in
+// its subdirectories (companion caches such as favicons), to match the
+// current setting (encrypting plaintext, re-encrypting files sealed with a
+// replaced key, or decrypting when disabled). It also tightens permissions
+// to 0600 on files and 0700 on directories. Callers must keep other writers
+// out of folder meanwhile. Returns the number of files rewritten.
+func Migrate(folder string) (int, error) {
+	return migrateDir(folder, isDataFile, true)
+}
+
+func migrateDir(folder string, match func(name string) bool, descend bool) (int, error) {
+	_ = os.Chmod(folder, 0700)
+
+	entries, err := os.ReadDir(folder)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	want := Enabled()
+	rewritten := 0
+	for _, e := range entries {
+		path := filepath.Join(folder, e.Name())
+		if e.IsDir() {
+			if descend {
+				n, err := migrateDir(path, func(name string) bool {
+					return !strings.HasSuffix(name, ".tmp")
+				}, false)
+				rewritten += n
+				if err != nil {
+					return rewritten, err
+				}
+			}
+			continue
+		}
+		_ = os.Chmod(path, 0600)
+		if !match(e.Name()) {
+			continue
+		}
+
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return rewritten, err
+		}
+		if current(raw, want) {
+			continue
+		}
+		plain, err := Decrypt(raw)
+		if err != nil {
+			return rewritten, fmt.Errorf("%s: %w", e.Name(), err)
+		}
+		tmp := path + ".tmp"
+		if err := WriteFile(tmp, plain); err != nil {
+			return rewritten, err
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			return rewritten, err
+		}
+		rewritten++
+	}
+	return rewritten, nil
+}
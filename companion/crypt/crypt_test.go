@@ -0,0 +1,148 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// reset forgets every key, as in a fresh process.
+func reset() {
+	mu.Lock()
+	enabled, key, aead, prev = false, nil, nil, nil
+	mu.Unlock()
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	dirA, dirB := t.TempDir(), t.TempDir()
+	keyA := func(t *testing.T) error { return Init(dirA, true, false) }
+	keyB := func(t *testing.T) error { return Init(dirB, true, false) }
+	pass := func(p string) func(t *testing.T) error {
+		return func(t *testing.T) error {
+			t.Setenv(PassphraseEnv, p)
+			return Init(dirA, true, true)
+		}
+	}
+	noKey := func(t *testing.T) error { return Init(t.TempDir(), false, false) }
+
+	tests := []struct {
+		name    string
+		write   func(t *testing.T) error
+		read    func(t *testing.T) error
+		fresh   bool // read in a fresh process, without the writer's key
+		tamper  bool
+		wantErr error
+	}{
+		{"same key file", keyA, keyA, true, false, nil},
+		{"other key file", keyA, keyB, true, false, ErrDecrypt},
+		{"replaced key still reads", keyA, keyB, false, false, nil},
+		{"same passphrase", pass("correct horse"), pass("correct horse"), true, false, nil},
+		{"wrong passphrase", pass("correct horse"), pass("battery staple"), true, false, ErrDecrypt},
+		{"no key", keyA, noKey, true, false, ErrNoKey},
+		{"tampered", keyA, keyA, true, true, ErrDecrypt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reset()
+			defer reset()
+			plain := []byte(`{"tabs":["https://example.com/?sig=secret"]}`)
+			if err := tt.write(t); err != nil {
+				t.Fatal(err)
+			}
+			enc, err := Encrypt(plain)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !IsEncrypted(enc) || bytes.Contains(enc, []byte("secret")) {
+				t.Fatalf("Encrypt output is not sealed: %q", enc)
+			}
+			if tt.tamper {
+				enc[len(enc)-1] ^= 1
+			}
+			if tt.fresh {
+				reset()
+			}
+			if err := tt.read(t); err != nil {
+				t.Fatal(err)
+			}
+			got, err := Decrypt(enc)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Decrypt error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && !bytes.Equal(got, plain) {
+				t.Errorf("Decrypt = %q, want %q", got, plain)
+			}
+		})
+	}
+}
+
+func TestDecryptPlaintext(t *testing.T) {
+	reset()
+	plain := []byte(`{"browsers":{}}`)
+	got, err := Decrypt(plain)
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("Decrypt(plaintext) = %q, %v; want it unchanged", got, err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	reset()
+	defer reset()
+	keyDir, folder := t.TempDir(), t.TempDir()
+	plain := []byte(`{"tabs":[]}`)
+	files := []string{"tabs.json", "tabs.json.1.bak", "favicons/abc.png"}
+	skipped := []string{"tabs.json.corrupt-20260101-000000", "notes.txt"}
+	if err := os.Mkdir(filepath.Join(folder, "favicons"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range append(files, skipped...) {
+		if err := os.WriteFile(filepath.Join(folder, name), plain, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	steps := []struct {
+		name   string
+		enable bool
+		want   int
+		sealed bool
+	}{
+		{"encrypt", true, len(files), true},
+		{"already current", true, 0, true},
+		{"decrypt", false, len(files), false},
+	}
+	for _, st := range steps {
+		if err := Init(keyDir, st.enable, false); err != nil {
+			t.Fatal(err)
+		}
+		n, err := Migrate(folder)
+		if err != nil {
+			t.Fatalf("%s: Migrate: %v", st.name, err)
+		}
+		if n != st.want {
+			t.Errorf("%s: rewrote %d files, want %d", st.name, n, st.want)
+		}
+		for _, name := range files {
+			path := filepath.Join(folder, name)
+			raw, _ := os.ReadFile(path)
+			if IsEncrypted(raw) != st.sealed {
+				t.Errorf("%s: %s encrypted = %v, want %v", st.name, name, IsEncrypted(raw), st.sealed)
+			}
+			if got, err := ReadFile(path); err != nil || !bytes.Equal(got, plain) {
+				t.Errorf("%s: ReadFile(%s) = %q, %v", st.name, name, got, err)
+			}
+			if fi, err := os.Stat(path); err == nil && fi.Mode().Perm() != 0600 {
+				t.Errorf("%s: %s mode = %v, want 0600", st.name, name, fi.Mode().Perm())
+			}
+		}
+		// Quarantined and unrelated files are left as they are
+		for _, name := range skipped {
+			raw, _ := os.ReadFile(filepath.Join(folder, name))
+			if IsEncrypted(raw) {
+				t.Errorf("%s: %s was encrypted", st.name, name)
+			}
+		}
+	}
+}
@@ -0,0 +1,162 @@
+// Package folderlock keeps two companions from using the same data folder.
+// The lock is an OS-level advisory lock on <folder>/companion.lock, so it is
+// released automatically if the holder dies; the file also records the
+// holder's PID and port so a refused start can say who owns the folder.
+package folderlock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileName is the lock file created in the data folder.
+const FileName = "companion.lock"
+
+// Owner describes the process holding a lock.
+type Owner struct {
+	PID       int    `json:"pid"`
+	Port      int    `json:"port"`
+	StartedAt string `json:"startedAt"`
+}
+
+// LockedError is returned when a live process holds the folder.
+type LockedError struct {
+	Folder string
+	Owner  Owner
+}
+
+func (e *LockedError) Error() string {
+	if e.Owner.PID == 0 {
+		return fmt.Sprintf("data folder %s is in use by another SyncTabs Companion", e.Folder)
+	}
+	return fmt.Sprintf("data folder %s is in use by another SyncTabs Companion (PID %d, port %d)",
+		e.Folder, e.Owner.PID, e.Owner.Port)
+}
+
+// Lock is a held data folder lock.
+type Lock struct {
+	mu     sync.Mutex
+	f      *os.File
+	folder string
+	owner  Owner
+
+	// Stale is the previous owner if a lock file left behind by a dead
+	// process was taken over.
+	Stale *Owner
+}
+
+// Acquire locks folder for this process, recording port as its port.
+// A lock file whose holder is gone is taken over (see Lock.Stale).
+func Acquire(folder string, port int) (*Lock, error) {
+	if err := os.MkdirAll(folder, 0700); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(folder, FileName)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	prev, hasPrev := readOwner(f)
+	if err := lockFile(f); err != nil {
+		f.Close()
+		if err == errWouldBlock {
+			return nil, &LockedError{Folder: folder, Owner: prev}
+		}
+		return nil, fmt.Errorf("lock %s: %w", path, err)
+	}
+
+	l := &Lock{
+		f:      f,
+		folder: folder,
+		owner:  Owner{PID: os.Getpid(), Port: port, StartedAt: time.Now().Format(time.RFC3339)},
+	}
+	if hasPrev && prev.PID != 0 && prev.PID != os.Getpid() {
+		l.Stale = &prev
+	}
+	if err := l.write(); err != nil {
+		l.Release()
+		return nil, err
+	}
+	return l, nil
+}
+
+// readOwner reads the owner recorded in an open lock file.
+func readOwner(f *os.File) (Owner, bool) {
+	var o Owner
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return o, false
+	}
+	data, err := io.ReadAll(f)
+	if err != nil || len(data) == 0 {
+		return o, false
+	}
+	return o, json.Unmarshal(data, &o) == nil
+}
+
+func (l *Lock) write() error {
+	data, err := json.Marshal(l.owner)
+	if err != nil {
+		return err
+	}
+	if err := l.f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := l.f.WriteAt(data, 0); err != nil {
+		return err
+	}
+	return l.f.Sync()
+}
+
+// Folder returns the locked folder.
+func (l *Lock) Folder() string {
+	return l.folder
+}
+
+// SetPort records a new port for the holder (after a port change).
+func (l *Lock) SetPort(port int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	l.owner.Port = port
+	return l.write()
+}
+
+// Release clears the owner record and unlocks. The file itself stays:
+// removing it after unlocking would let a process that opened it meanwhile
+// and one that creates a fresh file both hold "the" lock. Safe to call more
+// than once.
+func (l *Lock) Release() error {
+	return l.release(false)
+}
+
+// Remove deletes the lock file, while still holding the lock, and then
+// releases it. For folders being abandoned (see Server.MoveDataFolder).
+func (l *Lock) Remove() error {
+	return l.release(true)
+}
+
+func (l *Lock) release(remove bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	// Clear the owner record so the file is not later mistaken for a
+	// stale lock
+	_ = l.f.Truncate(0)
+	if remove {
+		_ = os.Remove(l.f.Name())
+	}
+	_ = unlockFile(l.f)
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
@@ -0,0 +1,120 @@
+package folderlock
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAcquire(t *testing.T) {
+	dead := `{"pid":999999,"port":9000,"startedAt":"2026-01-01T00:00:00Z"}`
+
+	tests := []struct {
+		name      string
+		lockFile  string // contents left by an earlier holder; "" for none
+		held      bool   // a live holder has the folder
+		wantStale int    // PID reported as stale, 0 for none
+	}{
+		{"fresh folder", "", false, 0},
+		{"released by its holder", "{}", false, 0},
+		{"left by a dead process", dead, false, 999999},
+		{"unreadable record", "not json", false, 0},
+		{"held", "", true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			folder := filepath.Join(t.TempDir(), "data")
+			if tt.lockFile != "" {
+				_ = os.MkdirAll(folder, 0700)
+				if err := os.WriteFile(filepath.Join(folder, FileName), []byte(tt.lockFile), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.held {
+				holder, err := Acquire(folder, 9234)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer holder.Release()
+				if err := holder.SetPort(9300); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			l, err := Acquire(folder, 9235)
+			var locked *LockedError
+			if tt.held {
+				if !errors.As(err, &locked) {
+					t.Fatalf("Acquire error = %v, want LockedError", err)
+				}
+				if locked.Owner.PID != os.Getpid() || locked.Owner.Port != 9300 {
+					t.Errorf("owner = %+v, want this process on the updated port", locked.Owner)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Acquire: %v", err)
+			}
+			defer l.Release()
+			switch {
+			case tt.wantStale == 0 && l.Stale != nil:
+				t.Errorf("Stale = %+v, want none", l.Stale)
+			case tt.wantStale != 0 && (l.Stale == nil || l.Stale.PID != tt.wantStale):
+				t.Errorf("Stale = %+v, want PID %d", l.Stale, tt.wantStale)
+			}
+			if l.Folder() != folder {
+				t.Errorf("Folder = %s, want %s", l.Folder(), folder)
+			}
+		})
+	}
+}
+
+func TestReleaseAndRemove(t *testing.T) {
+	tests := []struct {
+		name     string
+		remove   bool
+		wantFile bool
+	}{
+		{"release keeps the file", false, true},
+		{"remove deletes it", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			folder := t.TempDir()
+			l, err := Acquire(folder, 9234)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.remove {
+				err = l.Remove()
+			} else {
+				err = l.Release()
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := l.Release(); err != nil {
+				t.Errorf("second Release: %v", err)
+			}
+
+			data, err := os.ReadFile(filepath.Join(folder, FileName))
+			if exists := err == nil; exists != tt.wantFile {
+				t.Fatalf("lock file exists = %v, want %v", exists, tt.wantFile)
+			}
+			if len(data) != 0 {
+				t.Errorf("owner record not cleared: %s", data)
+			}
+
+			// The next holder neither waits nor sees a stale lock
+			next, err := Acquire(folder, 9235)
+			if err != nil {
+				t.Fatalf("Acquire after release: %v", err)
+			}
+			defer next.Release()
+			if next.Stale != nil {
+				t.Errorf("Stale = %+v after a clean release", next.Stale)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ go 1.21
 require (
 	fyne.io/systray v1.11.0
 	github.com/gorilla/websocket v1.5.1
+	golang.org/x/crypto v0.17.0
 	golang.org/x/sys v0.15.0
 )
 
@@ -29,16 +29,22 @@ var (
 	logPath string
 )
 
+// FileName is the log file created in the data folder.
+const FileName = "synctabs-companion.log"
+
 // Init opens (or creates) the log file at dataFolder/synctabs-companion.log.
 func Init(dataFolder string, lvl string) error {
 	mu.Lock()
 	defer mu.Unlock()
+	return open(dataFolder, lvl)
+}
 
+func open(dataFolder string, lvl string) error {
 	if err := os.MkdirAll(dataFolder, 0755); err != nil {
 		return err
 	}
 
-	logPath = filepath.Join(dataFolder, "synctabs-companion.log")
+	logPath = filepath.Join(dataFolder, FileName)
 
 	// Rotate if too large
 	if info, err := os.Stat(logPath); err == nil && info.Size() >= maxLogSize {
@@ -61,6 +67,29 @@ func Init(dataFolder string, lvl string) error {
 	return nil
 }
 
+// Move switches logging to dataFolder/synctabs-companion.log after
+// copyFile has carried the current log file there. Logging waits while
+// this runs, so no line is written to the old file after it was copied;
+// copyFile must not log. If copyFile fails, logging stays where it was.
+func Move(dataFolder string, lvl string, copyFile func(src, dst string) error) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	old := logFile
+	if old != nil {
+		if err := copyFile(logPath, filepath.Join(dataFolder, FileName)); err != nil {
+			return err
+		}
+	}
+	if err := open(dataFolder, lvl); err != nil {
+		return err
+	}
+	if old != nil {
+		_ = old.Close()
+	}
+	return nil
+}
+
 // SetLevel changes the log level at runtime.
 func SetLevel(lvl string) {
 	mu.Lock()
@@ -2,12 +2,15 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"time"
 
 	"github.com/harshvasudeva/synctabs-companion/config"
+	"github.com/harshvasudeva/synctabs-companion/crypt"
+	"github.com/harshvasudeva/synctabs-companion/folderlock"
 	"github.com/harshvasudeva/synctabs-companion/logger"
 	"github.com/harshvasudeva/synctabs-companion/server"
 	"github.com/harshvasudeva/synctabs-companion/startup"
@@ -23,6 +26,11 @@ const (
 )
 
 func main() {
+	// "send" hands a URL to the running companion and exits
+	if len(os.Args) > 1 && os.Args[1] == "send" {
+		os.Exit(runSend(os.Args[2:]))
+	}
+
 	// ─── 1. Load Configuration ────────────────────────────────────────
 	if err := config.Load(); err != nil {
 		showFatalDialog("SyncTabs Companion", "Failed to load configuration: "+err.Error())
@@ -38,6 +46,13 @@ func main() {
 	logger.Info("Data folder: %s", cfg.DataFolder)
 	logger.Info("Port: %d", cfg.Port)
 
+	// ─── 2b. Data Encryption ──────────────────────────────────────────
+	if err := crypt.Init(config.AppDataDir(), cfg.EncryptData, cfg.EncryptionKeySource == config.KeySourcePassphrase); err != nil {
+		msg := "Failed to initialise data encryption: " + err.Error()
+		logger.Error(msg)
+		showFatalDialog("SyncTabs Companion", msg)
+		os.Exit(1)
+	}
 	// ─── 3. Single-Instance Check ─────────────────────────────────────
 	switch checkSingleInstance(cfg.Port) {
 	case instanceAlreadyRunning:
@@ -56,11 +71,35 @@ func main() {
 		// Good to go
 	}
 
+	// ─── 3b. Data Folder Lock ─────────────────────────────────────────
+	// Another companion on a different port may still be using this folder
+	lock, err := folderlock.Acquire(cfg.DataFolder, cfg.Port)
+	if err != nil {
+		msg := "Cannot use the data folder: " + err.Error()
+		var locked *folderlock.LockedError
+		if errors.As(err, &locked) {
+			msg += "\nQuit the other instance or choose a different data folder."
+		}
+		logger.Error(msg)
+		showFatalDialog("SyncTabs Companion", msg)
+		os.Exit(1)
+	}
+	if lock.Stale != nil {
+		logger.Warn("Recovered stale data folder lock (left by PID %d, port %d)", lock.Stale.PID, lock.Stale.Port)
+	}
+
+	// Encrypt or decrypt data files to match the setting (needs the lock)
+	if n, err := crypt.Migrate(cfg.DataFolder); err != nil {
+		logger.Error("Data file migration failed: %v", err)
+	} else if n > 0 {
+		logger.Info("Migrated %d data file(s) (encryption %v)", n, cfg.EncryptData)
+	}
+
 	// ─── 4. Sync Auto-Start with Config ──────────────────────────────
 	startup.SyncWithConfig(cfg.AutoStart)
 
 	// ─── 5. Create and Start Server ───────────────────────────────────
-	srv, err := server.New(cfg)
+	srv, err := server.New(cfg, lock)
 	if err != nil {
 		msg := "Failed to create server: " + err.Error()
 		logger.Error(msg)
@@ -81,6 +120,7 @@ func main() {
 	// ─── 6. Run Tray (blocks until quit) ─────────────────────────────
 	// systray.Run MUST be called from the main goroutine on Windows.
 	tray.Run(srv)
+	srv.Close()
 }
 
 // checkSingleInstance probes the health endpoint to detect running instances.
@@ -0,0 +1,22 @@
+package schema
+
+import "encoding/json"
+
+// migrations lists, per kind, the steps from each version to the next:
+// migrations[kind][v] upgrades version v to v+1, so the current version
+// is the length of the list. Append new steps; never edit released ones.
+var migrations = map[string][]Migration{
+	Tabs:       {wrapOnly},
+	Pending:    {wrapOnly},
+	Stats:      {wrapOnly},
+	Usage:      {wrapOnly},
+	Aliases:    {wrapOnly},
+	Config:     {wrapOnly},
+	Stashes:    {wrapOnly},
+	Workspaces: {wrapOnly},
+}
+
+// wrapOnly is the v0→v1 step: unversioned files only gain the envelope.
+func wrapOnly(data json.RawMessage) (json.RawMessage, error) {
+	return data, nil
+}
@@ -0,0 +1,135 @@
+// Package schema versions the companion's on-disk files. Data files are
+// wrapped in an envelope recording their kind and schema version; on load,
+// registered forward migrations bring older files up to date, and files
+// written by a newer companion are refused instead of being overwritten.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// File kinds
+const (
+	Tabs       = "tabs"
+	Pending    = "pending-tabs"
+	Stats      = "stats"
+	Usage      = "usage"
+	Aliases    = "aliases"
+	Config     = "config"
+	Stashes    = "stashes"
+	Workspaces = "workspaces"
+)
+
+// Migration upgrades a payload from one version to the next.
+type Migration func(data json.RawMessage) (json.RawMessage, error)
+
+// NewerError is returned for files written by a newer companion.
+type NewerError struct {
+	Kind      string
+	Version   int
+	Supported int
+}
+
+func (e *NewerError) Error() string {
+	return fmt.Sprintf("%s data is schema v%d but this companion only supports up to v%d; "+
+		"it was written by a newer SyncTabs Companion — upgrade the companion (the file was left untouched)",
+		e.Kind, e.Version, e.Supported)
+}
+
+// envelope is the on-disk wrapper around a data file's payload.
+type envelope struct {
+	Kind          string          `json:"kind"`
+	SchemaVersion int             `json:"schemaVersion"`
+	Data          json.RawMessage `json:"data"`
+}
+
+// Current returns the schema version written for kind.
+func Current(kind string) int {
+	return len(migrations[kind])
+}
+
+// migrate runs the migrations for kind from version from to current.
+func migrate(kind string, data json.RawMessage, from int) (json.RawMessage, error) {
+	current := Current(kind)
+	if from > current {
+		return nil, &NewerError{Kind: kind, Version: from, Supported: current}
+	}
+	for v := from; v < current; v++ {
+		out, err := migrations[kind][v](data)
+		if err != nil {
+			return nil, fmt.Errorf("%s migration v%d→v%d: %w", kind, v, v+1, err)
+		}
+		data = out
+	}
+	return data, nil
+}
+
+// Decode unwraps a data file and migrates its payload to the current
+// version. Files without an envelope predate versioning and are treated
+// as version 0. Returns the payload and the version the file was written at.
+// Input that is not JSON is returned as-is so the caller reports it as corrupt.
+func Decode(kind string, raw []byte) ([]byte, int, error) {
+	var probe struct {
+		Kind          *string         `json:"kind"`
+		SchemaVersion *int            `json:"schemaVersion"`
+		Data          json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(raw, &probe); err != nil {
+		return raw, Current(kind), nil
+	}
+
+	from := 0
+	data := json.RawMessage(raw)
+	if probe.SchemaVersion != nil && probe.Data != nil {
+		if probe.Kind != nil && *probe.Kind != kind {
+			return nil, 0, fmt.Errorf("file holds %q data, expected %q", *probe.Kind, kind)
+		}
+		from, data = *probe.SchemaVersion, probe.Data
+	}
+
+	out, err := migrate(kind, data, from)
+	return out, from, err
+}
+
+// DecodeFlat is Decode for files that carry "schemaVersion" as a top-level
+// field instead of an envelope (config.json stays hand-editable).
+func DecodeFlat(kind string, raw []byte) ([]byte, int, error) {
+	var probe struct {
+		SchemaVersion int `json:"schemaVersion"`
+	}
+	if err := json.Unmarshal(raw, &probe); err != nil {
+		return raw, Current(kind), nil
+	}
+	out, err := migrate(kind, raw, probe.SchemaVersion)
+	return out, probe.SchemaVersion, err
+}
+
+// Encode wraps v in an envelope at the current version for kind.
+func Encode(kind string, v interface{}, indent bool) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	env := envelope{Kind: kind, SchemaVersion: Current(kind), Data: data}
+	if indent {
+		return json.MarshalIndent(env, "", "  ")
+	}
+	return json.Marshal(env)
+}
+
+// BackupPath returns where the pre-migration copy of path is kept.
+func BackupPath(path string, version int) string {
+	return fmt.Sprintf("%s.v%d.bak", path, version)
+}
+
+// Backup keeps the original bytes of a file about to be migrated from
+// version. An existing backup for that version is never overwritten.
+func Backup(path string, raw []byte, version int) (string, error) {
+	dst := BackupPath(path, version)
+	if _, err := os.Stat(dst); err == nil {
+		return dst, nil
+	}
+	return dst, os.WriteFile(dst, raw, 0600)
+}
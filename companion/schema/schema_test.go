@@ -0,0 +1,144 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testKind = "test"
+
+// renameField is a v1→v2 step renaming "name" to "title".
+func renameField(data json.RawMessage) (json.RawMessage, error) {
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	if v, ok := m["name"]; ok {
+		m["title"] = v
+		delete(m, "name")
+	}
+	return json.Marshal(m)
+}
+
+func withTestKind(t *testing.T, steps ...Migration) {
+	t.Helper()
+	migrations[testKind] = steps
+	t.Cleanup(func() { delete(migrations, testKind) })
+}
+
+func TestDecode(t *testing.T) {
+	withTestKind(t, wrapOnly, renameField)
+	failing := func(json.RawMessage) (json.RawMessage, error) { return nil, errors.New("bad payload") }
+
+	tests := []struct {
+		name    string
+		steps   []Migration // nil: the default test kind
+		raw     string
+		want    string
+		from    int
+		newer   bool
+		wantErr string
+	}{
+		{"unversioned file", nil, `{"name":"a"}`, `{"title":"a"}`, 0, false, ""},
+		{"older envelope", nil, `{"kind":"test","schemaVersion":1,"data":{"name":"a"}}`, `{"title":"a"}`, 1, false, ""},
+		{"current envelope", nil, `{"kind":"test","schemaVersion":2,"data":{"title":"a"}}`, `{"title":"a"}`, 2, false, ""},
+		{"newer envelope", nil, `{"kind":"test","schemaVersion":3,"data":{}}`, "", 3, true, ""},
+		{"other kind", nil, `{"kind":"tabs","schemaVersion":1,"data":{}}`, "", 0, false, `holds "tabs" data`},
+		{"not JSON is left to the caller", nil, `{"name":`, `{"name":`, 2, false, ""},
+		{"failing migration", []Migration{wrapOnly, failing}, `{"name":"a"}`, "", 0, false, "test migration v1→v2: bad payload"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations[testKind] = []Migration{wrapOnly, renameField}
+			if tt.steps != nil {
+				migrations[testKind] = tt.steps
+			}
+			out, from, err := Decode(testKind, []byte(tt.raw))
+			var newer *NewerError
+			switch {
+			case tt.newer:
+				if !errors.As(err, &newer) || newer.Version != tt.from || newer.Supported != 2 {
+					t.Fatalf("Decode error = %v, want NewerError v%d", err, tt.from)
+				}
+				return
+			case tt.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Decode error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			case err != nil:
+				t.Fatalf("Decode: %v", err)
+			}
+			if string(out) != tt.want || from != tt.from {
+				t.Errorf("Decode = %s, v%d; want %s, v%d", out, from, tt.want, tt.from)
+			}
+		})
+	}
+}
+
+func TestDecodeFlat(t *testing.T) {
+	withTestKind(t, wrapOnly, renameField)
+	tests := []struct {
+		name  string
+		raw   string
+		title bool
+		from  int
+		newer bool
+	}{
+		{"unversioned", `{"name":"a"}`, true, 0, false},
+		{"v1", `{"schemaVersion":1,"name":"a"}`, true, 1, false},
+		{"current", `{"schemaVersion":2,"title":"a"}`, true, 2, false},
+		{"newer", `{"schemaVersion":5}`, false, 5, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, from, err := DecodeFlat(testKind, []byte(tt.raw))
+			var newer *NewerError
+			if tt.newer != errors.As(err, &newer) || (!tt.newer && err != nil) {
+				t.Fatalf("DecodeFlat error = %v, want newer %v", err, tt.newer)
+			}
+			if from != tt.from {
+				t.Errorf("from = %d, want %d", from, tt.from)
+			}
+			if tt.title && !strings.Contains(string(out), `"title":"a"`) {
+				t.Errorf("DecodeFlat = %s, want title migrated", out)
+			}
+		})
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	withTestKind(t, wrapOnly, renameField)
+	raw, err := Encode(testKind, map[string]string{"title": "a"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(raw), `"schemaVersion":2`) || !strings.Contains(string(raw), `"kind":"test"`) {
+		t.Errorf("Encode = %s, want kind and current version", raw)
+	}
+	out, from, err := Decode(testKind, raw)
+	if err != nil || string(out) != `{"title":"a"}` || from != 2 {
+		t.Errorf("Decode(Encode) = %s, v%d, %v", out, from, err)
+	}
+}
+
+func TestBackupKeepsFirstCopy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tabs.json")
+	for _, content := range []string{"original", "second"} {
+		dst, err := Backup(path, []byte(content), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dst != path+".v0.bak" {
+			t.Errorf("Backup path = %s", dst)
+		}
+	}
+	got, _ := os.ReadFile(path + ".v0.bak")
+	if string(got) != "original" {
+		t.Errorf("backup = %q, want the first copy kept", got)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/harshvasudeva/synctabs-companion/config"
+	"github.com/harshvasudeva/synctabs-companion/server"
+)
+
+// runSend implements "synctabs-companion send [-to browser] [-title t] URL":
+// hands a URL to the running companion, which delivers or queues it.
+// Without -to the companion's routing rules pick the browser.
+func runSend(args []string) int {
+	fs := flag.NewFlagSet("send", flag.ContinueOnError)
+	to := fs.String("to", "auto", `target browser ID, alias or name, or "auto" for the routing rules`)
+	title := fs.String("title", "", "tab title (defaults to the URL)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: synctabs-companion send [-to browser] [-title title] URL")
+		return 2
+	}
+	if err := config.Load(); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to load configuration:", err)
+		return 1
+	}
+
+	token, err := config.APIToken()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to read the API token:", err)
+		return 1
+	}
+
+	body, _ := json.Marshal(map[string]string{"url": fs.Arg(0), "title": *title, "target": *to})
+	req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("http://127.0.0.1:%d/send", config.Get().Port), bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(server.APITokenHeader, token)
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "SyncTabs Companion is not running:", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintln(os.Stderr, strings.TrimSpace(string(data)))
+		return 1
+	}
+
+	var result struct {
+		Status          string `json:"status"`
+		TargetBrowserID string `json:"targetBrowserId"`
+	}
+	_ = json.Unmarshal(data, &result)
+	fmt.Printf("%s → %s\n", result.Status, result.TargetBrowserID)
+	return 0
+}
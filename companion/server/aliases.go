@@ -0,0 +1,276 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/harshvasudeva/synctabs-companion/logger"
+	"github.com/harshvasudeva/synctabs-companion/schema"
+)
+
+const (
+	MaxAliasLength = 64
+	MaxIconLength  = 32
+)
+
+var aliasColorRe = regexp.MustCompile(`^#(?:[0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// BrowserAlias is the user-assigned label for a browserId.
+type BrowserAlias struct {
+	Alias string `json:"alias,omitempty"`
+	Color string `json:"color,omitempty"`
+	Icon  string `json:"icon,omitempty"`
+}
+
+// UnmarshalJSON also accepts a bare string, taken as the alias alone, so
+// {"alias": "Work"} works as well as {"alias": {"alias": "Work"}}.
+func (a *BrowserAlias) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		*a = BrowserAlias{Alias: name}
+		return nil
+	}
+	type plain BrowserAlias
+	return json.Unmarshal(b, (*plain)(a))
+}
+
+func (a BrowserAlias) isEmpty() bool {
+	return a.Alias == "" && a.Color == "" && a.Icon == ""
+}
+
+// validate trims and checks an alias before it is stored.
+func (a BrowserAlias) validate() (BrowserAlias, error) {
+	a.Alias = strings.TrimSpace(a.Alias)
+	a.Color = strings.TrimSpace(a.Color)
+	a.Icon = strings.TrimSpace(a.Icon)
+	if utf8.RuneCountInString(a.Alias) > MaxAliasLength {
+		return a, fmt.Errorf("alias longer than %d characters", MaxAliasLength)
+	}
+	if a.Color != "" && !aliasColorRe.MatchString(a.Color) {
+		return a, fmt.Errorf("invalid color %q (want #rgb or #rrggbb)", a.Color)
+	}
+	if utf8.RuneCountInString(a.Icon) > MaxIconLength {
+		return a, fmt.Errorf("icon longer than %d characters", MaxIconLength)
+	}
+	return a, nil
+}
+
+// AliasStore holds browser aliases backed by aliases.json, kept apart from
+// tabs.json so labels survive state resets.
+type AliasStore struct {
+	mu     sync.RWMutex
+	saveMu sync.Mutex              // held across snapshot and write so saves land in order
+	data   map[string]BrowserAlias // browserId -> alias
+	folder string
+}
+
+// NewAliasStore creates an AliasStore and loads from disk.
+func NewAliasStore(dataFolder string) (*AliasStore, error) {
+	a := &AliasStore{
+		data:   make(map[string]BrowserAlias),
+		folder: dataFolder,
+	}
+	if err := a.Load(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *AliasStore) aliasesPath() string {
+	return filepath.Join(a.folder, "aliases.json")
+}
+
+// Load reads aliases.json.
+func (a *AliasStore) Load() error {
+	if err := os.MkdirAll(a.folder, 0755); err != nil {
+		return err
+	}
+
+	// A damaged file is quarantined and rebuilt from backups (see recovery.go)
+	entries, recovered, err := loadEntries(a.aliasesPath(), schema.Aliases)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	raw := make(map[string]BrowserAlias, len(entries))
+	for id, entry := range entries {
+		var v BrowserAlias
+		if json.Unmarshal(entry, &v) == nil {
+			raw[id] = v
+		}
+	}
+
+	a.mu.Lock()
+	for id, alias := range raw {
+		if id == "" || id == "null" || id == "undefined" || alias.isEmpty() {
+			continue
+		}
+		a.data[id] = alias
+	}
+	a.mu.Unlock()
+
+	if recovered {
+		if err := a.Save(); err != nil {
+			logger.Error("Alias save failed: %v", err)
+		}
+	}
+	return nil
+}
+
+// Save writes aliases.json atomically.
+func (a *AliasStore) Save() error {
+	a.saveMu.Lock()
+	defer a.saveMu.Unlock()
+
+	a.mu.RLock()
+	data, err := json.Marshal(a.data)
+	path := a.aliasesPath()
+	a.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	return writeDataFile(path, schema.Aliases, json.RawMessage(data), true)
+}
+
+// Get returns the alias for a browser (zero value if none).
+func (a *AliasStore) Get(browserId string) BrowserAlias {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.data[browserId]
+}
+
+// All returns a snapshot of every alias.
+func (a *AliasStore) All() map[string]BrowserAlias {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	result := make(map[string]BrowserAlias, len(a.data))
+	for id, alias := range a.data {
+		result[id] = alias
+	}
+	return result
+}
+
+// Set validates and stores an alias; an empty alias clears the entry.
+// Returns the stored (normalised) value.
+func (a *AliasStore) Set(browserId string, alias BrowserAlias) (BrowserAlias, error) {
+	if browserId == "" || browserId == "null" || browserId == "undefined" {
+		return alias, fmt.Errorf("invalid browserId")
+	}
+	alias, err := alias.validate()
+	if err != nil {
+		return alias, err
+	}
+
+	a.mu.Lock()
+	if alias.isEmpty() {
+		delete(a.data, browserId)
+	} else {
+		a.data[browserId] = alias
+	}
+	a.mu.Unlock()
+	return alias, a.Save()
+}
+
+// Forget removes a browser's alias.
+func (a *AliasStore) Forget(browserId string) error {
+	a.mu.Lock()
+	_, ok := a.data[browserId]
+	delete(a.data, browserId)
+	a.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return a.Save()
+}
+
+// DisplayName returns the alias if set, otherwise browserName.
+func (a *AliasStore) DisplayName(browserId, browserName string) string {
+	if alias := a.Get(browserId); alias.Alias != "" {
+		return alias.Alias
+	}
+	return browserName
+}
+
+// UpdateDataFolder points the store at a new folder and saves to it.
+// Existing files are copied beforehand by Server.MoveDataFolder.
+func (a *AliasStore) UpdateDataFolder(newFolder string) error {
+	a.mu.Lock()
+	a.folder = newFolder
+	a.mu.Unlock()
+	return a.Save()
+}
+
+// SetAlias stores an alias and broadcasts it to every connected browser
+// the aliased browser is not hidden from.
+func (s *Server) SetAlias(browserId string, alias BrowserAlias) (BrowserAlias, error) {
+	if _, ok := s.state.Get(browserId); !ok {
+		return alias, ErrUnknownBrowser
+	}
+	stored, err := s.aliases.Set(browserId, alias)
+	if err != nil {
+		return stored, err
+	}
+	msg := map[string]interface{}{
+		"type":      "alias-updated",
+		"browserId": browserId,
+		"alias":     stored,
+	}
+	s.reg.broadcastEach(func(viewerId string) interface{} {
+		if viewerId != browserId && s.state.visibility(browserId, viewerId) == shareNone {
+			return nil
+		}
+		return msg
+	})
+	logger.Info("[Alias] %s → %q", browserId, stored.Alias)
+	return stored, nil
+}
+
+// handleAliases responds to GET /aliases and POST /aliases
+// with {"browserId", "alias", "color", "icon"}.
+func (s *Server) handleAliases(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, s.aliases.All())
+
+	case http.MethodPost:
+		// Fields are flat here, so BrowserAlias is not embedded (its
+		// UnmarshalJSON would be promoted and swallow browserId)
+		var req struct {
+			BrowserID string `json:"browserId"`
+			Alias     string `json:"alias"`
+			Color     string `json:"color"`
+			Icon      string `json:"icon"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		stored, err := s.SetAlias(req.BrowserID, BrowserAlias{Alias: req.Alias, Color: req.Color, Icon: req.Icon})
+		if err == ErrUnknownBrowser {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"ok":        true,
+			"browserId": req.BrowserID,
+			"alias":     stored,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
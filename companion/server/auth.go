@@ -0,0 +1,128 @@
+package server
+
+import (
+	"crypto/subtle"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// APITokenHeader carries the per-install token (see config.APIToken) for
+// local clients that have no extension origin, such as the send command.
+const APITokenHeader = "X-SyncTabs-Token"
+
+// extensionSchemes are the origins browsers give extension pages. Web
+// pages cannot claim them.
+var extensionSchemes = []string{"chrome-extension://", "moz-extension://", "safari-web-extension://"}
+
+func extensionOrigin(origin string) bool {
+	for _, scheme := range extensionSchemes {
+		if strings.HasPrefix(origin, scheme) && len(origin) > len(scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// loopback reports whether the request came from this machine and was
+// addressed to it by a loopback name, so a rebound DNS name cannot reach
+// the API from a web page.
+func loopback(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil || (host != "127.0.0.1" && host != "::1") {
+		return false
+	}
+	name := r.Host
+	if h, _, err := net.SplitHostPort(name); err == nil {
+		name = h
+	}
+	name = strings.Trim(name, "[]")
+	return name == "127.0.0.1" || name == "::1" || strings.EqualFold(name, "localhost")
+}
+
+// authorized reports whether a loopback request comes from a browser
+// extension (by its Origin) or presents the API token.
+func (s *Server) authorized(r *http.Request) bool {
+	if !loopback(r) {
+		return false
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		return extensionOrigin(origin)
+	}
+	token := r.Header.Get(APITokenHeader)
+	return s.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+// allowCORS lets an extension origin read the response.
+func allowCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	w.Header().Add("Vary", "Origin")
+	if !extensionOrigin(origin) {
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, "+APITokenHeader)
+}
+
+// hasBodyType reports whether a mutating request's body is one of types.
+// Requiring a type a plain form cannot send means a browser always asks
+// first (CORS preflight), which the origin check then refuses.
+func hasBodyType(r *http.Request, types []string) bool {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return true
+	}
+	if r.Method == http.MethodDelete && r.ContentLength == 0 {
+		return true
+	}
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	for _, t := range types {
+		if mt == t {
+			return true
+		}
+	}
+	return false
+}
+
+// requireLocalhost rejects non-loopback connections. Used only for
+// /health, which startup checks and the extension probe without a token.
+func (s *Server) requireLocalhost(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !loopback(r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		allowCORS(w, r)
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireClient rejects requests that are not authorized (see authorized)
+// and request bodies that are not JSON or one of bodyTypes.
+func (s *Server) requireClient(next http.HandlerFunc, bodyTypes ...string) http.HandlerFunc {
+	bodyTypes = append([]string{"application/json"}, bodyTypes...)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.authorized(r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		allowCORS(w, r)
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if !hasBodyType(r, bodyTypes) {
+			http.Error(w, "Content-Type must be "+strings.Join(bodyTypes, " or "), http.StatusUnsupportedMediaType)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequireClient(t *testing.T) {
+	const token = "0123456789abcdef0123456789abcdef"
+	const ext = "chrome-extension://abcdef"
+	s := &Server{token: token}
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+
+	tests := []struct {
+		name      string
+		method    string
+		remote    string
+		host      string
+		origin    string
+		token     string
+		bodyType  string
+		bodyTypes []string // accepted besides JSON
+		want      int
+		cors      bool
+	}{
+		{"extension", http.MethodGet, "127.0.0.1:5000", "127.0.0.1:9234", ext, "", "", nil, http.StatusOK, true},
+		{"extension preflight", http.MethodOptions, "127.0.0.1:5000", "127.0.0.1:9234", ext, "", "", nil, http.StatusNoContent, true},
+		{"firefox extension over IPv6", http.MethodGet, "[::1]:5000", "[::1]:9234", "moz-extension://1234", "", "", nil, http.StatusOK, true},
+		{"token", http.MethodGet, "127.0.0.1:5000", "localhost:9234", "", token, "", nil, http.StatusOK, false},
+		{"wrong token", http.MethodGet, "127.0.0.1:5000", "127.0.0.1:9234", "", "nope", "", nil, http.StatusForbidden, false},
+		{"no origin, no token", http.MethodGet, "127.0.0.1:5000", "127.0.0.1:9234", "", "", "", nil, http.StatusForbidden, false},
+		{"web page", http.MethodGet, "127.0.0.1:5000", "127.0.0.1:9234", "https://evil.example", "", "", nil, http.StatusForbidden, false},
+		{"web page with the token", http.MethodGet, "127.0.0.1:5000", "127.0.0.1:9234", "https://evil.example", token, "", nil, http.StatusForbidden, false},
+		{"rebound host name", http.MethodGet, "127.0.0.1:5000", "evil.example:9234", ext, "", "", nil, http.StatusForbidden, false},
+		{"other machine", http.MethodGet, "192.0.2.1:5000", "127.0.0.1:9234", ext, "", "", nil, http.StatusForbidden, false},
+		{"JSON body", http.MethodPost, "127.0.0.1:5000", "127.0.0.1:9234", ext, "", "application/json; charset=utf-8", nil, http.StatusOK, true},
+		{"form body", http.MethodPost, "127.0.0.1:5000", "127.0.0.1:9234", ext, "", "text/plain", nil, http.StatusUnsupportedMediaType, true},
+		{"extra body type", http.MethodPost, "127.0.0.1:5000", "127.0.0.1:9234", "", token, "application/octet-stream", []string{"application/octet-stream"}, http.StatusOK, false},
+		{"body type not accepted here", http.MethodPost, "127.0.0.1:5000", "127.0.0.1:9234", "", token, "application/octet-stream", nil, http.StatusUnsupportedMediaType, false},
+		{"delete without body", http.MethodDelete, "127.0.0.1:5000", "127.0.0.1:9234", ext, "", "", nil, http.StatusOK, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/status", strings.NewReader(""))
+			if tt.method == http.MethodPost {
+				req = httptest.NewRequest(tt.method, "/status", strings.NewReader("{}"))
+			}
+			req.RemoteAddr, req.Host = tt.remote, tt.host
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.token != "" {
+				req.Header.Set(APITokenHeader, tt.token)
+			}
+			if tt.bodyType != "" {
+				req.Header.Set("Content-Type", tt.bodyType)
+			}
+			rec := httptest.NewRecorder()
+			s.requireClient(ok, tt.bodyTypes...)(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); (got != "") != tt.cors || (tt.cors && got != tt.origin) {
+				t.Errorf("Access-Control-Allow-Origin = %q, want cors %v", got, tt.cors)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/harshvasudeva/synctabs-companion/config"
+	"github.com/harshvasudeva/synctabs-companion/crypt"
 	"github.com/harshvasudeva/synctabs-companion/logger"
 	"github.com/harshvasudeva/synctabs-companion/startup"
 )
@@ -18,6 +19,7 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	for id, data := range all {
 		summary[id] = map[string]interface{}{
 			"browserName": data.BrowserName,
+			"alias":       s.aliases.Get(id),
 			"tabCount":    len(data.Tabs),
 			"online":      data.Online,
 			"lastSeen":    data.LastSeen,
@@ -60,8 +62,41 @@ func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		// Not a config field: whether a data folder move deletes the old files
+		removeOldFolder, _ := partial["removeOldDataFolder"].(bool)
+		delete(partial, "removeOldDataFolder")
+
+		// Validate encryption changes before persisting them: switching on
+		// must be able to obtain a key, or data would stay plaintext.
+		encryptionChanged := false
+		if _, ok := partial["encryptData"]; ok {
+			encryptionChanged = true
+		}
+		if _, ok := partial["encryptionKeySource"]; ok {
+			encryptionChanged = true
+		}
+		if encryptionChanged {
+			cur := config.Get()
+			enable, _ := partial["encryptData"].(bool)
+			if _, ok := partial["encryptData"]; !ok {
+				enable = cur.EncryptData
+			}
+			source, _ := partial["encryptionKeySource"].(string)
+			if source == "" {
+				source = cur.EncryptionKeySource
+			}
+			if err := crypt.Init(config.AppDataDir(), enable, source == config.KeySourcePassphrase); err != nil {
+				http.Error(w, "Encryption: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
 		restartNeeded, dataFolderChanged, err := config.Update(partial)
 		if err != nil {
+			if encryptionChanged {
+				cur := config.Get()
+				_ = crypt.Init(config.AppDataDir(), cur.EncryptData, cur.EncryptionKeySource == config.KeySourcePassphrase)
+			}
 			http.Error(w, "Invalid config: "+err.Error(), http.StatusBadRequest)
 			return
 		}
@@ -79,6 +114,40 @@ func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
 			logger.Info("Log level changed to %s", newCfg.LogLevel)
 		}
 
+		// Rewrite data files to match the new encryption setting
+		if encryptionChanged {
+			if n, err := migrateDataFiles(newCfg.DataFolder); err != nil {
+				logger.Error("Data file migration failed: %v", err)
+			} else {
+				logger.Info("Encryption %v — migrated %d data file(s)", newCfg.EncryptData, n)
+			}
+		}
+
+		// Purge incognito tabs the new policy no longer allows
+		if _, ok := partial["incognitoPolicy"]; ok {
+			s.ApplyIncognitoPolicy()
+		}
+
+		// Re-apply privacy rules to everything already held
+		if _, ok := partial["privacyRules"]; ok {
+			s.ApplyPrivacyRules()
+		}
+
+		if _, ok := partial["routingRules"]; ok {
+			SetRoutingRules(newCfg.RoutingRules)
+		}
+		if _, ok := partial["incomingRules"]; ok {
+			SetIncomingRules(newCfg.IncomingRules)
+		}
+		if _, ok := partial["mirrorRules"]; ok {
+			s.SetMirrorRules(newCfg.MirrorRules)
+		}
+
+		// Apply retention change immediately rather than on the next hourly check
+		if _, ok := partial["retentionDays"]; ok {
+			go s.EnforceRetention()
+		}
+
 		// Apply autoStart change immediately
 		if val, ok := partial["autoStart"]; ok {
 			if as, ok := val.(bool); ok {
@@ -98,21 +167,26 @@ func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
-		// Handle data folder migration (if not restarting)
-		if dataFolderChanged && !restartNeeded {
-			if err := s.state.UpdateDataFolder(newCfg.DataFolder); err != nil {
-				logger.Error("Failed to move state data: %v", err)
-			}
-			if err := s.pending.UpdateDataFolder(newCfg.DataFolder); err != nil {
-				logger.Error("Failed to move pending data: %v", err)
+		// Move the data folder now (also before a port restart) so the
+		// outcome can be reported; on failure the old folder stays in use
+		resp := map[string]interface{}{"ok": true}
+		if dataFolderChanged {
+			report, err := s.MoveDataFolder(newCfg.DataFolder, removeOldFolder)
+			if err != nil {
+				_, _, _ = config.Update(map[string]interface{}{"dataFolder": report.From})
+				if err := config.Save(); err != nil {
+					logger.Error("Failed to save config: %v", err)
+				}
+				newCfg = config.Get()
+				resp["ok"] = false
+				resp["error"] = "Data folder move failed: " + err.Error()
 			}
+			resp["dataFolderMove"] = report
 		}
 
-		writeJSON(w, map[string]interface{}{
-			"ok":            true,
-			"config":        newCfg,
-			"restartNeeded": restartNeeded,
-		})
+		resp["config"] = newCfg
+		resp["restartNeeded"] = restartNeeded
+		writeJSON(w, resp)
 
 		// Port change: restart server after responding
 		if restartNeeded {
@@ -141,6 +215,7 @@ type StatusResponse struct {
 	ConnectedBrowsers []string `json:"connectedBrowsers"`
 	LogLevel    string   `json:"logLevel"`
 	DataFolder  string   `json:"dataFolder"`
+	Recoveries  []RecoveryEvent `json:"recoveries,omitempty"`
 }
 
 // handleStatus responds to GET /status
@@ -156,5 +231,57 @@ func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 		ConnectedBrowsers: s.BrowserNames(),
 		LogLevel:          cfg.LogLevel,
 		DataFolder:        cfg.DataFolder,
+		Recoveries:        Recoveries(),
 	})
 }
+
+// handleDuplicates responds to GET /duplicates[?browserId=] (report) and
+// POST /duplicates {"policy", "url", "browserId"} (dedupe). With a
+// browserId the request sees what that browser would (see FindDuplicates);
+// without one it sees no private or receive-only browsers.
+func (s *Server) handleDuplicates(w http.ResponseWriter, r *http.Request) {
+	known := func(viewerId string) bool {
+		if _, ok := s.state.Get(viewerId); viewerId != "" && !ok {
+			http.Error(w, ErrUnknownBrowser.Error(), http.StatusNotFound)
+			return false
+		}
+		return true
+	}
+	switch r.Method {
+	case http.MethodGet:
+		viewerId := r.URL.Query().Get("browserId")
+		if !known(viewerId) {
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"groups": s.state.FindDuplicates(viewerId),
+		})
+
+	case http.MethodPost:
+		var req struct {
+			Policy    string `json:"policy"`
+			URL       string `json:"url"`
+			BrowserID string `json:"browserId"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !known(req.BrowserID) {
+			return
+		}
+		res, err := dedupe(s.state, s.reg, req.BrowserID, req.Policy, req.URL)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.Info("[Dedupe] HTTP requested %s across %d group(s)", res.Policy, res.Groups)
+		writeJSON(w, map[string]interface{}{
+			"ok":     true,
+			"result": res,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
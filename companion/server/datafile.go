@@ -0,0 +1,77 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/harshvasudeva/synctabs-companion/crypt"
+	"github.com/harshvasudeva/synctabs-companion/logger"
+	"github.com/harshvasudeva/synctabs-companion/schema"
+)
+
+// dataFilesMu is held shared by every write into the data folder and
+// exclusively by migrateDataFiles, so re-encryption never races a save.
+var dataFilesMu sync.RWMutex
+
+// migrateDataFiles rewrites the data folder to match the current
+// encryption setting (see crypt.Migrate) with all writers held off.
+func migrateDataFiles(folder string) (int, error) {
+	dataFilesMu.Lock()
+	defer dataFilesMu.Unlock()
+	return crypt.Migrate(folder)
+}
+
+// readDataFile reads a store's file: it decrypts it, unwraps the schema
+// envelope and runs any pending migrations. Before a file is migrated its
+// original bytes are kept next to it (see schema.Backup). Files written by a
+// newer companion yield a *schema.NewerError and are left untouched.
+func readDataFile(path, kind string) ([]byte, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := crypt.Decrypt(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+
+	data, from, err := schema.Decode(kind, plain)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	if current := schema.Current(kind); from < current {
+		backup, err := schema.Backup(path, raw, from)
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("Migrated %s from schema v%d to v%d (original kept as %s)",
+			filepath.Base(path), from, current, filepath.Base(backup))
+	}
+	return data, nil
+}
+
+// writeDataFile atomically writes v to path as a versioned, optionally
+// encrypted data file, keeping the previous contents as backup generation 1.
+// Each store holds its saveMu around this, so one path has one writer.
+func writeDataFile(path, kind string, v interface{}, indent bool) error {
+	data, err := schema.Encode(kind, v, indent)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	dataFilesMu.RLock()
+	defer dataFilesMu.RUnlock()
+	tmp := path + ".tmp"
+	if err := crypt.WriteFile(tmp, data); err != nil {
+		return err
+	}
+	if err := rotateBackups(path); err != nil {
+		logger.Warn("Could not rotate backups of %s: %v", filepath.Base(path), err)
+	}
+	return os.Rename(tmp, path)
+}
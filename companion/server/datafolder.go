@@ -0,0 +1,298 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/harshvasudeva/synctabs-companion/config"
+	"github.com/harshvasudeva/synctabs-companion/folderlock"
+	"github.com/harshvasudeva/synctabs-companion/logger"
+)
+
+// ErrFolderInUse is returned when the destination already holds SyncTabs data.
+var ErrFolderInUse = errors.New("destination folder already contains SyncTabs data")
+
+// FolderMoveFile is the outcome for one file of a data folder move.
+type FolderMoveFile struct {
+	Name  string `json:"name"`
+	Bytes int64  `json:"bytes"`
+	Error string `json:"error,omitempty"`
+}
+
+// FolderMoveReport describes a data folder move, returned by POST /config.
+type FolderMoveReport struct {
+	From       string           `json:"from"`
+	To         string           `json:"to"`
+	Files      []FolderMoveFile `json:"files"`
+	Copied     int              `json:"copied"`
+	Bytes      int64            `json:"bytes"`
+	RemovedOld bool             `json:"removedOld"`
+	Error      string           `json:"error,omitempty"`
+	Seconds    float64          `json:"seconds"`
+}
+
+// dataStore is implemented by every store persisted in the data folder.
+type dataStore interface {
+	Save() error
+	UpdateDataFolder(newFolder string) error
+}
+
+func (s *Server) dataStores() []dataStore {
+	return []dataStore{s.state, s.pending, s.stats, s.usage, s.aliases, s.favicons, s.stashes, s.workspaces}
+}
+
+// companionDirs are data folder subdirectories owned by the companion,
+// moved in full.
+var companionDirs = []string{FaviconDir}
+
+// isCompanionFile reports whether a data folder entry belongs to the
+// companion and should move with it (temporary files are skipped).
+func isCompanionFile(name string) bool {
+	if strings.HasSuffix(name, ".tmp") {
+		return false
+	}
+	return strings.Contains(name, ".json") || strings.HasPrefix(name, logger.FileName)
+}
+
+// companionFiles lists the companion's files in folder as paths relative
+// to it, including everything in companionDirs.
+func companionFiles(folder string) ([]string, error) {
+	entries, err := os.ReadDir(folder)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && isCompanionFile(e.Name()) {
+			files = append(files, e.Name())
+		}
+	}
+	for _, dir := range companionDirs {
+		sub, err := os.ReadDir(filepath.Join(folder, dir))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range sub {
+			if !e.IsDir() && !strings.HasSuffix(e.Name(), ".tmp") {
+				files = append(files, filepath.Join(dir, e.Name()))
+			}
+		}
+	}
+	return files, nil
+}
+
+// MoveDataFolder moves every companion file from the current data folder to
+// newFolder. Files are copied (so moves across drives work) and verified
+// by checksum before any store switches over; if anything fails, the
+// companion keeps using the old folder untouched. On success the stores
+// save their latest state to the new folder, the log follows, and — if
+// removeOld is set — the copied files are deleted from the old folder.
+func (s *Server) MoveDataFolder(newFolder string, removeOld bool) (*FolderMoveReport, error) {
+	start := time.Now()
+	s.mu.Lock()
+	oldFolder := s.cfg.DataFolder
+	s.mu.Unlock()
+
+	report := &FolderMoveReport{From: oldFolder, To: newFolder, Files: []FolderMoveFile{}}
+	fail := func(err error) (*FolderMoveReport, error) {
+		report.Error = err.Error()
+		report.Seconds = time.Since(start).Seconds()
+		logger.Error("[DataFolder] Move to %s failed: %v (still using %s)", newFolder, err, oldFolder)
+		return report, err
+	}
+
+	if filepath.Clean(newFolder) == filepath.Clean(oldFolder) {
+		return report, nil
+	}
+
+	// Take the destination's lock first: never move into a folder another
+	// companion is using
+	s.mu.Lock()
+	port := s.cfg.Port
+	s.mu.Unlock()
+	newLock, err := folderlock.Acquire(newFolder, port)
+	if err != nil {
+		return fail(err)
+	}
+	logStale(newLock)
+	baseFail := fail
+	fail = func(err error) (*FolderMoveReport, error) {
+		_ = newLock.Release()
+		return baseFail(err)
+	}
+
+	existing, err := companionFiles(newFolder)
+	if err != nil {
+		return fail(err)
+	}
+	if len(existing) > 0 {
+		return fail(fmt.Errorf("%w (%s)", ErrFolderInUse, existing[0]))
+	}
+
+	// Flush in-memory state so the copies are current
+	for _, st := range s.dataStores() {
+		if err := st.Save(); err != nil {
+			return fail(err)
+		}
+	}
+
+	files, err := companionFiles(oldFolder)
+	if err != nil {
+		return fail(err)
+	}
+	var copied []string
+	cleanup := func() {
+		for _, name := range copied {
+			_ = os.Remove(filepath.Join(newFolder, name))
+		}
+	}
+	for _, name := range files {
+		if name == logger.FileName {
+			continue // still being written; it moves with the logger below
+		}
+		dst := filepath.Join(newFolder, name)
+		if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+			cleanup()
+			return fail(err)
+		}
+		n, err := copyVerified(filepath.Join(oldFolder, name), dst)
+		f := FolderMoveFile{Name: filepath.ToSlash(name), Bytes: n}
+		if err != nil {
+			f.Error = err.Error()
+			report.Files = append(report.Files, f)
+			cleanup()
+			return fail(fmt.Errorf("%s: %w", name, err))
+		}
+		report.Files = append(report.Files, f)
+		report.Copied++
+		report.Bytes += n
+		copied = append(copied, name)
+		logger.Debug("[DataFolder] Copied %s (%d bytes)", name, n)
+	}
+
+	// Switch every store; undo the ones already switched if one fails
+	stores := s.dataStores()
+	for i, st := range stores {
+		if err := st.UpdateDataFolder(newFolder); err != nil {
+			for _, prev := range stores[:i] {
+				_ = prev.UpdateDataFolder(oldFolder)
+			}
+			cleanup()
+			return fail(err)
+		}
+	}
+	s.mu.Lock()
+	s.cfg.DataFolder = newFolder
+	oldLock := s.lock
+	s.lock = newLock
+	s.mu.Unlock()
+	if oldLock != nil {
+		if removeOld {
+			_ = oldLock.Remove()
+		} else {
+			_ = oldLock.Release()
+		}
+	}
+
+	// The live log is copied with logging held, so nothing written after
+	// the copy is lost
+	var logCopy FolderMoveFile
+	err = logger.Move(newFolder, config.Get().LogLevel, func(src, dst string) error {
+		n, err := copyVerified(src, dst)
+		logCopy = FolderMoveFile{Name: logger.FileName, Bytes: n}
+		return err
+	})
+	if err != nil {
+		logCopy.Error = err.Error()
+		logger.Warn("[DataFolder] Could not move log file: %v", err)
+	} else if logCopy.Name != "" {
+		report.Copied++
+		report.Bytes += logCopy.Bytes
+		copied = append(copied, logger.FileName)
+	}
+	if logCopy.Name != "" {
+		report.Files = append(report.Files, logCopy)
+	}
+
+	if removeOld {
+		for _, name := range copied {
+			if err := os.Remove(filepath.Join(oldFolder, name)); err != nil {
+				logger.Warn("[DataFolder] Could not remove old %s: %v", name, err)
+			}
+		}
+		// Only succeed if nothing else lives there
+		for _, dir := range companionDirs {
+			_ = os.Remove(filepath.Join(oldFolder, dir))
+		}
+		_ = os.Remove(oldFolder)
+		report.RemovedOld = true
+	}
+
+	report.Seconds = time.Since(start).Seconds()
+	logger.Info("[DataFolder] Moved %d file(s), %d bytes: %s → %s", report.Copied, report.Bytes, oldFolder, newFolder)
+	return report, nil
+}
+
+// logStale notes when a lock left behind by a dead process was taken over.
+func logStale(l *folderlock.Lock) {
+	if l.Stale != nil {
+		logger.Warn("Recovered stale lock on %s (left by PID %d, port %d)", l.Folder(), l.Stale.PID, l.Stale.Port)
+	}
+}
+
+// copyVerified copies src to dst via a temporary file, checks the copy's
+// SHA-256 against the source and then renames it into place.
+func copyVerified(src, dst string) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, err
+	}
+	srcHash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(out, srcHash), in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return n, err
+	}
+
+	written, err := os.Open(tmp)
+	if err != nil {
+		return n, err
+	}
+	dstHash := sha256.New()
+	_, err = io.Copy(dstHash, written)
+	written.Close()
+	if err == nil && !bytes.Equal(srcHash.Sum(nil), dstHash.Sum(nil)) {
+		err = errors.New("verification failed: copy differs from source")
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return n, err
+	}
+	return n, os.Rename(tmp, dst)
+}
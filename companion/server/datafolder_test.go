@@ -0,0 +1,172 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/harshvasudeva/synctabs-companion/config"
+	"github.com/harshvasudeva/synctabs-companion/folderlock"
+	"github.com/harshvasudeva/synctabs-companion/logger"
+)
+
+// newFolderServer starts a Server on a fresh data folder holding its lock,
+// with the log written there too.
+func newFolderServer(t *testing.T) (*Server, string) {
+	t.Helper()
+	t.Setenv("APPDATA", t.TempDir()) // for the API token
+	folder := t.TempDir()
+	lock, err := folderlock.Acquire(folder, 9234)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := logger.Init(folder, "info"); err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(config.Config{Port: 9234, DataFolder: folder}, lock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		logger.Close()
+		_ = s.lock.Release()
+	})
+	return s, folder
+}
+
+func TestMoveDataFolder(t *testing.T) {
+	tests := []struct {
+		name      string
+		prepare   func(t *testing.T, dst string)
+		removeOld bool
+		wantErr   error
+		locked    bool
+	}{
+		{"keep old folder", nil, false, nil, false},
+		{"remove old folder", nil, true, nil, false},
+		{"destination has data", func(t *testing.T, dst string) {
+			_ = os.WriteFile(filepath.Join(dst, "tabs.json"), []byte("{}"), 0600)
+		}, false, ErrFolderInUse, false},
+		{"destination locked", func(t *testing.T, dst string) {
+			l, err := folderlock.Acquire(dst, 9235)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { _ = l.Release() })
+		}, false, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, src := newFolderServer(t)
+			s.state.Register("b1", "Google Chrome", BrowserIdentity{})
+			files := map[string]string{
+				"notes.json.1.bak":                 "backup",
+				logger.FileName + ".bak":           "rotated log",
+				filepath.Join(FaviconDir, "a.png"): "icon",
+			}
+			_ = os.MkdirAll(filepath.Join(src, FaviconDir), 0700)
+			for name, content := range files {
+				if err := os.WriteFile(filepath.Join(src, name), []byte(content), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			logger.Info("before the move")
+
+			dst := filepath.Join(t.TempDir(), "moved")
+			if tt.prepare != nil {
+				_ = os.MkdirAll(dst, 0700)
+				tt.prepare(t, dst)
+			}
+			report, err := s.MoveDataFolder(dst, tt.removeOld)
+
+			var locked *folderlock.LockedError
+			if tt.wantErr != nil || tt.locked {
+				if (tt.locked && !errors.As(err, &locked)) || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+					t.Fatalf("MoveDataFolder error = %v", err)
+				}
+				// Nothing switched, nothing copied
+				if s.cfg.DataFolder != src || logger.LogPath() != filepath.Join(src, logger.FileName) {
+					t.Errorf("switched to %s (log %s) after a failed move", s.cfg.DataFolder, logger.LogPath())
+				}
+				if report.Copied != 0 {
+					t.Errorf("copied %d files on a failed move", report.Copied)
+				}
+				if _, err := os.Stat(filepath.Join(dst, "tabs.json.1.bak")); !os.IsNotExist(err) {
+					t.Errorf("files left in the destination")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("MoveDataFolder: %v", err)
+			}
+			logger.Info("after the move")
+
+			for name, content := range files {
+				got, err := os.ReadFile(filepath.Join(dst, name))
+				if err != nil || string(got) != content {
+					t.Errorf("%s = %q, %v; want %q", name, got, err, content)
+				}
+			}
+			if _, err := os.Stat(filepath.Join(dst, "tabs.json")); err != nil {
+				t.Errorf("tabs.json not moved: %v", err)
+			}
+			log, _ := os.ReadFile(filepath.Join(dst, logger.FileName))
+			for _, line := range []string{"before the move", "Moved", "after the move"} {
+				if !strings.Contains(string(log), line) {
+					t.Errorf("moved log is missing %q", line)
+				}
+			}
+			if s.cfg.DataFolder != dst {
+				t.Errorf("data folder = %s, want %s", s.cfg.DataFolder, dst)
+			}
+			_, err = os.Stat(src)
+			if removed := os.IsNotExist(err); removed != tt.removeOld || report.RemovedOld != tt.removeOld {
+				t.Errorf("old folder removed = %v (report %v), want %v", removed, report.RemovedOld, tt.removeOld)
+			}
+			if !tt.removeOld {
+				// The old copy is no longer written to
+				old, _ := os.ReadFile(filepath.Join(src, logger.FileName))
+				if strings.Contains(string(old), "after the move") {
+					t.Errorf("old log still written after the move")
+				}
+			}
+		})
+	}
+}
+
+func TestCopyVerified(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.json")
+	_ = os.WriteFile(src, []byte(`{"a":1}`), 0600)
+
+	tests := []struct {
+		name    string
+		src     string
+		dst     string
+		want    int64
+		wantErr bool
+	}{
+		{"copies", src, filepath.Join(dir, "dst.json"), 7, false},
+		{"missing source", filepath.Join(dir, "none.json"), filepath.Join(dir, "x.json"), 0, true},
+		{"missing destination folder", src, filepath.Join(dir, "no", "dst.json"), 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := copyVerified(tt.src, tt.dst)
+			if (err != nil) != tt.wantErr || n != tt.want {
+				t.Fatalf("copyVerified = %d, %v; want %d, error %v", n, err, tt.want, tt.wantErr)
+			}
+			if _, err := os.Stat(tt.dst + ".tmp"); !os.IsNotExist(err) {
+				t.Errorf("temporary file left behind")
+			}
+			if !tt.wantErr {
+				got, _ := os.ReadFile(tt.dst)
+				if string(got) != `{"a":1}` {
+					t.Errorf("copy = %q", got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Dedupe policies accepted by the 'dedupe' request.
+const (
+	DedupeKeepRecent = "keep-recent"
+	DedupeKeepPinned = "keep-pinned"
+)
+
+// trackingParams are query parameters stripped during canonicalisation.
+// Entries ending in '_' match any parameter with that prefix.
+var trackingParams = []string{
+	"utm_", "fbclid", "gclid", "dclid", "msclkid", "yclid", "igshid",
+	"mc_cid", "mc_eid", "_ga", "_gl", "ref_src", "spm",
+}
+
+func isTrackingParam(name string) bool {
+	name = strings.ToLower(name)
+	for _, p := range trackingParams {
+		if strings.HasSuffix(p, "_") && strings.HasPrefix(name, p) {
+			return true
+		}
+		if name == p {
+			return true
+		}
+	}
+	return false
+}
+
+// CanonicalURL normalises a URL so that copies of the same page compare equal:
+// lowercases scheme and host, drops default ports, fragments, trailing slashes
+// and common tracking parameters, and sorts the remaining query.
+// Unparseable URLs are returned unchanged.
+func CanonicalURL(rawURL string) string {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if port != "" {
+		host = host + ":" + port
+	}
+	u.Host = host
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	if len(u.Path) > 1 {
+		u.Path = strings.TrimRight(u.Path, "/")
+		u.RawPath = ""
+	}
+	if u.Path == "/" {
+		u.Path = ""
+	}
+
+	q := u.Query()
+	for name := range q {
+		if isTrackingParam(name) {
+			q.Del(name)
+		}
+	}
+	u.RawQuery = q.Encode() // Encode sorts by key
+	return u.String()
+}
+
+// DuplicateCopy is one open copy of a duplicated page.
+type DuplicateCopy struct {
+	BrowserID    string  `json:"browserId"`
+	BrowserName  string  `json:"browserName"`
+	Online       bool    `json:"online"`
+	TabID        int     `json:"tabId"`
+	WindowID     int     `json:"windowId"`
+	URL          string  `json:"url"`
+	Title        string  `json:"title"`
+	Pinned       bool    `json:"pinned"`
+	Active       bool    `json:"active"`
+	LastAccessed float64 `json:"lastAccessed"`
+}
+
+// DuplicateGroup collects every copy of one canonical URL across browsers and windows.
+type DuplicateGroup struct {
+	URL    string          `json:"url"`
+	Copies []DuplicateCopy `json:"copies"`
+}
+
+// FindDuplicates groups tabs with identical canonical URLs across the
+// browsers whose tabs viewerId may see (see shareLevelFor), including its
+// own. HTTP callers pass the browserId they ask for, or "" to see what an
+// unregistered connection would, so private browsers are never listed. Only groups with more than one
+// copy are returned, largest first.
+func (s *StateStore) FindDuplicates(viewerId string) []DuplicateGroup {
+	s.mu.RLock()
+	viewer := s.data[viewerId]
+	groups := make(map[string][]DuplicateCopy)
+	for id, entry := range s.data {
+		if id != viewerId && shareLevelFor(entry, viewer, viewerId) != shareFull {
+			continue
+		}
+		for _, tab := range entry.Tabs {
+			if !isValidURL(tab.URL) {
+				continue
+			}
+			key := CanonicalURL(tab.URL)
+			groups[key] = append(groups[key], DuplicateCopy{
+				BrowserID:    id,
+				BrowserName:  entry.BrowserName,
+				Online:       entry.Online,
+				TabID:        tab.ID,
+				WindowID:     tab.WindowID,
+				URL:          tab.URL,
+				Title:        tab.Title,
+				Pinned:       tab.Pinned,
+				Active:       tab.Active,
+				LastAccessed: tab.LastAccessed,
+			})
+		}
+	}
+	s.mu.RUnlock()
+
+	result := make([]DuplicateGroup, 0)
+	for key, copies := range groups {
+		if len(copies) < 2 {
+			continue
+		}
+		result = append(result, DuplicateGroup{URL: key, Copies: copies})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if len(result[i].Copies) != len(result[j].Copies) {
+			return len(result[i].Copies) > len(result[j].Copies)
+		}
+		return result[i].URL < result[j].URL
+	})
+	return result
+}
+
+// pickKeeper returns the index of the copy to keep under the given policy.
+func pickKeeper(copies []DuplicateCopy, policy string) int {
+	better := func(a, b DuplicateCopy) bool {
+		if policy == DedupeKeepPinned && a.Pinned != b.Pinned {
+			return a.Pinned
+		}
+		if a.LastAccessed != b.LastAccessed {
+			return a.LastAccessed > b.LastAccessed
+		}
+		return a.Pinned && !b.Pinned
+	}
+	keep := 0
+	for i := 1; i < len(copies); i++ {
+		if better(copies[i], copies[keep]) {
+			keep = i
+		}
+	}
+	return keep
+}
+
+// DedupeResult summarises a dedupe run.
+type DedupeResult struct {
+	Policy  string           `json:"policy"`
+	Groups  int              `json:"groups"`
+	Closing map[string][]int `json:"closing"` // browserId -> tab IDs asked to close
+	Skipped []DuplicateCopy  `json:"skipped"` // copies in offline browsers
+}
+
+// planDedupe decides which tabs to close. If onlyURL is non-empty, only the
+// group with that canonical URL is considered.
+func planDedupe(groups []DuplicateGroup, policy, onlyURL string) (DedupeResult, error) {
+	if policy == "" {
+		policy = DedupeKeepRecent
+	}
+	if policy != DedupeKeepRecent && policy != DedupeKeepPinned {
+		return DedupeResult{}, fmt.Errorf("unknown dedupe policy %q", policy)
+	}
+	if onlyURL != "" {
+		onlyURL = CanonicalURL(onlyURL)
+	}
+
+	res := DedupeResult{
+		Policy:  policy,
+		Closing: make(map[string][]int),
+		Skipped: []DuplicateCopy{},
+	}
+	for _, g := range groups {
+		if onlyURL != "" && g.URL != onlyURL {
+			continue
+		}
+		res.Groups++
+		keep := pickKeeper(g.Copies, policy)
+		for i, c := range g.Copies {
+			if i == keep {
+				continue
+			}
+			if !c.Online {
+				res.Skipped = append(res.Skipped, c)
+				continue
+			}
+			res.Closing[c.BrowserID] = append(res.Closing[c.BrowserID], c.TabID)
+		}
+	}
+	return res, nil
+}
+
+// dedupe plans a dedupe run over the duplicates viewerId may see and sends
+// 'close-tabs' to each owning browser.
+func dedupe(state *StateStore, reg *connectionRegistry, viewerId, policy, onlyURL string) (DedupeResult, error) {
+	res, err := planDedupe(state.FindDuplicates(viewerId), policy, onlyURL)
+	if err != nil {
+		return res, err
+	}
+	for browserId, tabIds := range res.Closing {
+		conn, ok := reg.get(browserId)
+		if !ok {
+			continue
+		}
+		_ = conn.sendJSON(map[string]interface{}{
+			"type":   "close-tabs",
+			"tabIds": tabIds,
+			"reason": "dedupe",
+		})
+	}
+	return res, nil
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCanonicalURL(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"scheme and host case", "HTTPS://Docs.Example.COM/Doc", "https://docs.example.com/Doc"},
+		{"default https port", "https://example.com:443/a", "https://example.com/a"},
+		{"default http port", "http://example.com:80/a", "http://example.com/a"},
+		{"other port kept", "https://example.com:8443/a", "https://example.com:8443/a"},
+		{"fragment", "https://example.com/a#section", "https://example.com/a"},
+		{"trailing slash", "https://example.com/a/b/", "https://example.com/a/b"},
+		{"root slash", "https://example.com/", "https://example.com"},
+		{"tracking prefix", "https://example.com/a?utm_source=x&utm_medium=y&id=1", "https://example.com/a?id=1"},
+		{"tracking names", "https://example.com/a?fbclid=1&gclid=2&_ga=3", "https://example.com/a"},
+		{"tracking name case", "https://example.com/a?UTM_Source=x&Q=1", "https://example.com/a?Q=1"},
+		{"query sorted", "https://example.com/a?b=2&a=1", "https://example.com/a?a=1&b=2"},
+		{"no host unchanged", "about:blank", "about:blank"},
+		{"unparseable unchanged", "http://[::1", "http://[::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CanonicalURL(tt.in); got != tt.want {
+				t.Errorf("CanonicalURL(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanDedupe(t *testing.T) {
+	dup := func(browser string, tab int, online, pinned bool, accessed float64) DuplicateCopy {
+		return DuplicateCopy{BrowserID: browser, TabID: tab, Online: online, Pinned: pinned, LastAccessed: accessed}
+	}
+	groups := []DuplicateGroup{
+		{URL: "https://a.example", Copies: []DuplicateCopy{
+			dup("chrome", 1, true, false, 100),
+			dup("edge", 2, true, true, 50),
+			dup("brave", 3, true, false, 200),
+		}},
+		{URL: "https://b.example", Copies: []DuplicateCopy{
+			dup("chrome", 4, true, false, 10),
+			dup("edge", 5, false, false, 5),
+		}},
+	}
+
+	tests := []struct {
+		name    string
+		policy  string
+		only    string
+		closing map[string][]int
+		skipped []int
+		groups  int
+		bad     bool
+	}{
+		{"keep recent", DedupeKeepRecent, "", map[string][]int{"chrome": {1}, "edge": {2}}, []int{5}, 2, false},
+		{"default is keep recent", "", "", map[string][]int{"chrome": {1}, "edge": {2}}, []int{5}, 2, false},
+		{"keep pinned", DedupeKeepPinned, "", map[string][]int{"chrome": {1}, "brave": {3}}, []int{5}, 2, false},
+		{"one URL, canonicalised", DedupeKeepRecent, "HTTPS://A.example/#top", map[string][]int{"chrome": {1}, "edge": {2}}, []int{}, 1, false},
+		{"unknown policy", "keep-oldest", "", nil, nil, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := planDedupe(groups, tt.policy, tt.only)
+			if (err != nil) != tt.bad {
+				t.Fatalf("planDedupe error = %v, want error %v", err, tt.bad)
+			}
+			if tt.bad {
+				return
+			}
+			if !reflect.DeepEqual(res.Closing, tt.closing) {
+				t.Errorf("closing = %v, want %v", res.Closing, tt.closing)
+			}
+			skipped := []int{}
+			for _, c := range res.Skipped {
+				skipped = append(skipped, c.TabID)
+			}
+			if !reflect.DeepEqual(skipped, tt.skipped) {
+				t.Errorf("skipped = %v, want %v", skipped, tt.skipped)
+			}
+			if res.Groups != tt.groups {
+				t.Errorf("groups = %d, want %d", res.Groups, tt.groups)
+			}
+		})
+	}
+}
@@ -0,0 +1,410 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/harshvasudeva/synctabs-companion/crypt"
+	"github.com/harshvasudeva/synctabs-companion/logger"
+)
+
+const (
+	// FaviconDir is the favicon cache folder inside the data folder.
+	FaviconDir = "favicons"
+	// MaxFaviconBytes caps a single decoded favicon; larger ones are dropped.
+	MaxFaviconBytes = 64 * 1024
+	// FaviconGCGrace keeps unreferenced favicons this long before removal,
+	// so an icon stored moments before its tab update lands is not lost.
+	FaviconGCGrace = 10 * time.Minute
+	// FaviconTouchInterval is how often a cached icon that keeps arriving
+	// has its file time refreshed; in between, its last use is only
+	// recorded in memory.
+	FaviconTouchInterval = RetentionCheckInterval
+)
+
+// faviconTypes maps accepted data: URI media types to file extensions.
+var faviconTypes = map[string]string{
+	"image/png":                "png",
+	"image/x-icon":             "ico",
+	"image/vnd.microsoft.icon": "ico",
+	"image/gif":                "gif",
+	"image/jpeg":               "jpg",
+	"image/webp":               "webp",
+	"image/svg+xml":            "svg",
+	"image/bmp":                "bmp",
+}
+
+// faviconMIME is the reverse of faviconTypes, used when serving.
+var faviconMIME = map[string]string{
+	"png":  "image/png",
+	"ico":  "image/x-icon",
+	"gif":  "image/gif",
+	"jpg":  "image/jpeg",
+	"webp": "image/webp",
+	"svg":  "image/svg+xml",
+	"bmp":  "image/bmp",
+}
+
+var faviconHashRe = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// FaviconStore is a content-addressed cache of favicons extracted from
+// inline data: URIs. Each image is stored once as favicons/<hash>.<ext>
+// and tabs refer to it by the bare hash, which clients resolve against
+// GET /favicon/{hash} of the companion they are connected to.
+type FaviconStore struct {
+	mu     sync.RWMutex
+	folder string
+	uses   map[string]*faviconUse // by file name
+}
+
+// faviconUse is when a cached icon last arrived and when its file time
+// was last refreshed.
+type faviconUse struct {
+	used    time.Time
+	touched time.Time
+}
+
+// NewFaviconStore creates a FaviconStore in dataFolder/favicons.
+func NewFaviconStore(dataFolder string) (*FaviconStore, error) {
+	f := &FaviconStore{folder: dataFolder, uses: make(map[string]*faviconUse)}
+	if err := os.MkdirAll(f.dir(), 0700); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *FaviconStore) dir() string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return filepath.Join(f.folder, FaviconDir)
+}
+
+// faviconRef extracts the hash from a favicon reference: a bare hash, or
+// a companion URL (any port) as stored by older versions.
+func faviconRef(u string) (string, bool) {
+	if faviconHashRe.MatchString(u) {
+		return u, true
+	}
+	if !strings.HasPrefix(u, "http://127.0.0.1:") {
+		return "", false
+	}
+	i := strings.Index(u, "/favicon/")
+	if i < 0 {
+		return "", false
+	}
+	hash := u[i+len("/favicon/"):]
+	return hash, faviconHashRe.MatchString(hash)
+}
+
+// decodeDataURI decodes an image data: URI, returning its bytes and file
+// extension.
+func decodeDataURI(u string) ([]byte, string, error) {
+	meta, payload, ok := strings.Cut(strings.TrimPrefix(u, "data:"), ",")
+	if !ok {
+		return nil, "", fmt.Errorf("malformed data URI")
+	}
+	params := strings.Split(meta, ";")
+	ext, ok := faviconTypes[strings.ToLower(strings.TrimSpace(params[0]))]
+	if !ok {
+		return nil, "", fmt.Errorf("unsupported favicon type %q", params[0])
+	}
+
+	var data []byte
+	var err error
+	if params[len(params)-1] == "base64" {
+		data, err = base64.StdEncoding.DecodeString(payload)
+		if err != nil {
+			data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(payload, "="))
+		}
+	} else {
+		var s string
+		s, err = url.PathUnescape(payload)
+		data = []byte(s)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) == 0 || len(data) > MaxFaviconBytes {
+		return nil, "", fmt.Errorf("favicon size %d out of range", len(data))
+	}
+	return data, ext, nil
+}
+
+// Put stores a data: URI favicon and returns its hash.
+func (f *FaviconStore) Put(dataURI string) (string, error) {
+	data, ext, err := decodeDataURI(dataURI)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:16])
+	name := hash + "." + ext
+	now := time.Now()
+	if f.use(name, now) {
+		return hash, nil
+	}
+
+	path := filepath.Join(f.dir(), name)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return "", err
+		}
+		dataFilesMu.RLock()
+		tmp := path + ".tmp"
+		err := crypt.WriteFile(tmp, data)
+		if err == nil {
+			err = os.Rename(tmp, path)
+		}
+		dataFilesMu.RUnlock()
+		if err != nil {
+			return "", err
+		}
+	} else {
+		// Touch so GC treats it as recently used after a restart too
+		_ = os.Chtimes(path, now, now)
+	}
+	f.mu.Lock()
+	f.uses[name] = &faviconUse{used: now, touched: now}
+	f.mu.Unlock()
+	return hash, nil
+}
+
+// use records that the icon stored as name arrived again and reports
+// whether its file was touched recently enough to leave the disk alone.
+func (f *FaviconStore) use(name string, now time.Time) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u, ok := f.uses[name]
+	if !ok || now.Sub(u.touched) >= FaviconTouchInterval {
+		return false
+	}
+	u.used = now
+	return true
+}
+
+// intern replaces an inline favicon with a reference. Icons that cannot be
+// stored are dropped rather than truncated into a broken URI.
+func (f *FaviconStore) intern(u string) string {
+	if !strings.HasPrefix(u, "data:") {
+		return u
+	}
+	ref, err := f.Put(u)
+	if err != nil {
+		logger.Debug("[Favicon] Dropped inline favicon: %v", err)
+		return ""
+	}
+	return ref
+}
+
+// InternTabs replaces inline favicons in tabs (in place). Call it on tabs
+// already filtered for storage, so nothing dropped is written to disk.
+// Incognito tabs lose inline favicons unless persistIncognito is set.
+func (f *FaviconStore) InternTabs(tabs []Tab, persistIncognito bool) {
+	for i := range tabs {
+		if tabs[i].Incognito && !persistIncognito && strings.HasPrefix(tabs[i].FavIconURL, "data:") {
+			tabs[i].FavIconURL = ""
+			continue
+		}
+		tabs[i].FavIconURL = f.intern(tabs[i].FavIconURL)
+	}
+}
+
+// Intern replaces a single inline favicon.
+func (f *FaviconStore) Intern(u string) string {
+	return f.intern(u)
+}
+
+// find returns the stored file for hash.
+func (f *FaviconStore) find(hash string) (string, string, bool) {
+	matches, _ := filepath.Glob(filepath.Join(f.dir(), hash+".*"))
+	for _, m := range matches {
+		ext := strings.TrimPrefix(filepath.Ext(m), ".")
+		if mime, ok := faviconMIME[ext]; ok {
+			return m, mime, true
+		}
+	}
+	return "", "", false
+}
+
+// GC removes favicons no longer referenced by any tab. inUse holds the
+// referenced hashes. Returns the number of files removed.
+func (f *FaviconStore) GC(inUse map[string]bool) int {
+	entries, err := os.ReadDir(f.dir())
+	if err != nil {
+		return 0
+	}
+	cutoff := time.Now().Add(-FaviconGCGrace)
+	removed := 0
+	for _, e := range entries {
+		name := e.Name()
+		hash := strings.SplitN(name, ".", 2)[0]
+		if e.IsDir() || inUse[hash] {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || info.ModTime().After(cutoff) || f.usedSince(name, cutoff) {
+			continue
+		}
+		if os.Remove(filepath.Join(f.dir(), name)) == nil {
+			f.mu.Lock()
+			delete(f.uses, name)
+			f.mu.Unlock()
+			removed++
+		}
+	}
+	return removed
+}
+
+// usedSince reports whether the icon stored as name arrived after t.
+func (f *FaviconStore) usedSince(name string, t time.Time) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	u, ok := f.uses[name]
+	return ok && u.used.After(t)
+}
+
+// Save is a no-op: favicons are written as they arrive.
+func (f *FaviconStore) Save() error {
+	return nil
+}
+
+// UpdateDataFolder points the store at a new folder. Existing files are
+// copied beforehand by Server.MoveDataFolder.
+func (f *FaviconStore) UpdateDataFolder(newFolder string) error {
+	f.mu.Lock()
+	f.folder = newFolder
+	f.mu.Unlock()
+	return os.MkdirAll(f.dir(), 0700)
+}
+
+// MapFavicons rewrites every tab's favicon URL with fn.
+func (s *StateStore) MapFavicons(fn func(string) string) {
+	s.mu.Lock()
+	changed := false
+	for _, entry := range s.data {
+		for i := range entry.Tabs {
+			if u := fn(entry.Tabs[i].FavIconURL); u != entry.Tabs[i].FavIconURL {
+				entry.Tabs[i].FavIconURL = u
+				changed = true
+			}
+		}
+	}
+	s.mu.Unlock()
+	if changed {
+		s.DebouncedSave()
+	}
+}
+
+// MapFavicons rewrites every queued tab's favicon URL with fn.
+func (p *PendingStore) MapFavicons(fn func(string) string) {
+	p.mu.Lock()
+	changed := false
+	var mapTabs func(tabs []PendingTab)
+	mapTabs = func(tabs []PendingTab) {
+		for i := range tabs {
+			if u := fn(tabs[i].FavIconURL); u != tabs[i].FavIconURL {
+				tabs[i].FavIconURL = u
+				changed = true
+			}
+			mapTabs(tabs[i].Bundle)
+		}
+	}
+	for _, tabs := range p.data {
+		mapTabs(tabs)
+	}
+	p.mu.Unlock()
+	if changed {
+		p.DebouncedSave()
+	}
+}
+
+// faviconsInUse collects the favicon hashes referenced by stored and
+// queued tabs.
+func (s *Server) faviconsInUse() map[string]bool {
+	inUse := make(map[string]bool)
+	mark := func(u string) {
+		if hash, ok := faviconRef(u); ok {
+			inUse[hash] = true
+		}
+	}
+	s.state.MapFavicons(func(u string) string { mark(u); return u })
+	s.pending.MapFavicons(func(u string) string { mark(u); return u })
+	s.stashes.MapFavicons(func(u string) string { mark(u); return u })
+	s.workspaces.MapFavicons(func(u string) string { mark(u); return u })
+	return inUse
+}
+
+// CollectFavicons deletes cached favicons no tab refers to any more.
+func (s *Server) CollectFavicons() {
+	if n := s.favicons.GC(s.faviconsInUse()); n > 0 {
+		logger.Info("[Favicon] Removed %d unused favicon(s)", n)
+	}
+}
+
+// InternFavicons moves inline favicons already in state (e.g. saved by an
+// older companion) into the cache, and turns companion URL references into
+// bare hashes.
+func (s *Server) InternFavicons() {
+	fn := func(u string) string {
+		u = s.favicons.intern(u)
+		if hash, ok := faviconRef(u); ok {
+			return hash
+		}
+		return u
+	}
+	s.state.MapFavicons(fn)
+	s.pending.MapFavicons(fn)
+	s.stashes.MapFavicons(fn)
+	s.workspaces.MapFavicons(fn)
+}
+
+// handleFavicon responds to GET /favicon/{hash}. Content never changes for
+// a hash, so responses are cacheable indefinitely.
+func (s *Server) handleFavicon(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	hash := strings.TrimPrefix(r.URL.Path, "/favicon/")
+	if !faviconHashRe.MatchString(hash) {
+		http.NotFound(w, r)
+		return
+	}
+	etag := `"` + hash + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	path, mime, ok := s.favicons.find(hash)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	data, err := crypt.ReadFile(path)
+	if err != nil {
+		logger.Warn("[Favicon] Read %s failed: %v", hash, err)
+		http.Error(w, "Favicon unavailable", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", mime)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", etag)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// SVG can carry script; never let it run
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		_, _ = w.Write(data)
+	}
+}
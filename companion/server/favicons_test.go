@@ -0,0 +1,63 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFaviconTouch(t *testing.T) {
+	const icon = "data:image/png;base64,iVBORw0KGgo="
+	old := time.Now().Add(-2 * FaviconGCGrace)
+
+	tests := []struct {
+		name      string
+		touchedAt time.Duration // how long ago the file time was last refreshed
+		usedAt    time.Duration // how long ago the icon last arrived
+		wantTouch bool
+		wantGC    bool
+	}{
+		{"touched recently", time.Minute, time.Minute, false, false},
+		{"touched an interval ago", FaviconTouchInterval, FaviconTouchInterval, true, false},
+		{"unused past the grace", FaviconTouchInterval, 2 * FaviconGCGrace, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewFaviconStore(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			hash, err := f.Put(icon)
+			if err != nil {
+				t.Fatal(err)
+			}
+			name := hash + ".png"
+			path := filepath.Join(f.dir(), name)
+			_ = os.Chtimes(path, old, old)
+			f.uses[name].touched = time.Now().Add(-tt.touchedAt)
+
+			if tt.wantGC {
+				// No new arrival; the last one is past the grace
+				f.uses[name].used = time.Now().Add(-tt.usedAt)
+			} else if _, err := f.Put(icon); err != nil {
+				t.Fatal(err)
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if touched := info.ModTime().After(old); touched != tt.wantTouch {
+				t.Errorf("file touched = %v, want %v", touched, tt.wantTouch)
+			}
+
+			removed := f.GC(map[string]bool{})
+			if (removed == 1) != tt.wantGC {
+				t.Errorf("GC removed %d, want removed %v", removed, tt.wantGC)
+			}
+			if _, ok := f.uses[name]; ok == tt.wantGC {
+				t.Errorf("in-memory use kept = %v after GC", ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/harshvasudeva/synctabs-companion/logger"
+)
+
+// HandoffTimeout is how long the target has to confirm it opened a
+// handed-off tab before the handoff is rolled back.
+const HandoffTimeout = 15 * time.Second
+
+// Reasons a handoff was rolled back
+const (
+	HandoffTimedOut           = "timeout"
+	HandoffTargetFailed       = "target-failed"
+	HandoffTargetDisconnected = "target-disconnected"
+	HandoffSourceDisconnected = "source-disconnected"
+)
+
+// handoff is a tab move waiting for the target's confirmation.
+type handoff struct {
+	id       string
+	sourceId string
+	targetId string
+	tabId    int
+	tab      PendingTab
+	timer    *time.Timer
+}
+
+// handoffTable holds the handoffs in flight.
+type handoffTable struct {
+	mu      sync.Mutex
+	pending map[string]*handoff
+}
+
+func (t *handoffTable) add(h *handoff) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.pending == nil {
+		t.pending = make(map[string]*handoff)
+	}
+	t.pending[h.id] = h
+}
+
+// take removes and returns a handoff; only the first caller gets it, so a
+// confirmation racing the timeout is settled exactly once.
+func (t *handoffTable) take(id string) (*handoff, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h, ok := t.pending[id]
+	if ok {
+		delete(t.pending, id)
+		h.timer.Stop()
+	}
+	return h, ok
+}
+
+// involving returns the handoffs to or from browserId.
+func (t *handoffTable) involving(browserId string) []*handoff {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var out []*handoff
+	for _, h := range t.pending {
+		if h.sourceId == browserId || h.targetId == browserId {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+// StartHandoff moves a tab from sourceId to targetId: the target is sent
+// the tab and, once it confirms the tab opened, the source is told to
+// close its copy. Without confirmation within HandoffTimeout, or if the
+// target reports failure or disconnects, the source tab is left open.
+// Both browsers must be connected, and the tab must be deliverable right
+// away (no confirmation by incoming rules, no quiet hours).
+func (s *Server) StartHandoff(sourceId string, tabId int, targetId string, tab PendingTab) (string, error) {
+	if targetId == sourceId {
+		return "", errors.New("cannot hand off a tab to the same browser")
+	}
+	if _, ok := s.reg.get(sourceId); !ok {
+		return "", ErrBrowserOffline
+	}
+	target, ok := s.reg.get(targetId)
+	if !ok {
+		return "", fmt.Errorf("target: %w", ErrBrowserOffline)
+	}
+	if err := s.state.checkSend(sourceId, targetId); err != nil {
+		return "", err
+	}
+	confirm, err := checkIncoming(targetId, sourceId, tab.URL)
+	if err != nil {
+		return "", err
+	}
+	if confirm {
+		return "", errors.New("target browser must confirm tabs from this browser; use send-tab")
+	}
+	if _, quiet := quietUntil(targetId, time.Now()); quiet {
+		return "", errors.New("target browser is in quiet hours")
+	}
+
+	h := &handoff{id: newID(), sourceId: sourceId, targetId: targetId, tabId: tabId, tab: tab}
+	h.timer = time.AfterFunc(HandoffTimeout, func() {
+		s.rollbackHandoff(h.id, HandoffTimedOut)
+	})
+	s.handoffs.add(h)
+
+	if err := target.sendJSON(map[string]interface{}{
+		"type":      "handoff-open",
+		"handoffId": h.id,
+		"tab":       tab,
+	}); err != nil {
+		s.handoffs.take(h.id)
+		return "", err
+	}
+	logger.Info("[Handoff] %s: %s → %s started: %s", h.id, sourceId, targetId, LogURL(tab.URL))
+	return h.id, nil
+}
+
+// CompleteHandoff settles a handoff with the target's report. On success
+// the source is told to close its tab if it still shows the handed-off
+// URL; otherwise the handoff is rolled back. Only the handoff's target may report.
+func (s *Server) CompleteHandoff(targetId, handoffId string, opened bool, detail string) error {
+	s.handoffs.mu.Lock()
+	h, ok := s.handoffs.pending[handoffId]
+	s.handoffs.mu.Unlock()
+	if !ok || h.targetId != targetId {
+		return errors.New("unknown or expired handoff")
+	}
+	if !opened {
+		if detail != "" {
+			logger.Info("[Handoff] %s: target failed: %s", handoffId, detail)
+		}
+		s.rollbackHandoff(handoffId, HandoffTargetFailed)
+		return nil
+	}
+	if _, ok := s.handoffs.take(handoffId); !ok {
+		return errors.New("unknown or expired handoff")
+	}
+
+	// The source tab may have navigated away or closed since the handoff
+	// started; then it is left alone rather than closing another page
+	source, online := s.reg.get(h.sourceId)
+	closed := false
+	if online {
+		closed = s.sourceShows(h)
+		if closed {
+			_ = source.sendJSON(map[string]interface{}{
+				"type":   "close-tabs",
+				"tabIds": []int{h.tabId},
+				"reason": "handed-off",
+			})
+		}
+		_ = source.sendJSON(map[string]interface{}{
+			"type":            "handoff-result",
+			"handoffId":       h.id,
+			"status":          "completed",
+			"sourceClosed":    closed,
+			"targetBrowserId": h.targetId,
+			"tabId":           h.tabId,
+		})
+	}
+	logger.Info("[Handoff] %s: completed (source closed: %v): %s", h.id, closed, LogURL(h.tab.URL))
+	return nil
+}
+
+// sourceShows reports whether the source's last tabs update still has the
+// handed-off tab showing its URL.
+func (s *Server) sourceShows(h *handoff) bool {
+	data, ok := s.state.Get(h.sourceId)
+	if !ok {
+		return false
+	}
+	for _, t := range data.Tabs {
+		if t.ID == h.tabId {
+			return t.URL == h.tab.URL
+		}
+	}
+	return false
+}
+
+// rollbackHandoff abandons a handoff, leaving the source tab open. The
+// target is told to cancel in case its copy opens late.
+func (s *Server) rollbackHandoff(handoffId, reason string) {
+	h, ok := s.handoffs.take(handoffId)
+	if !ok {
+		return
+	}
+	if source, ok := s.reg.get(h.sourceId); ok {
+		_ = source.sendJSON(map[string]interface{}{
+			"type":            "handoff-result",
+			"handoffId":       h.id,
+			"status":          "rolled-back",
+			"reason":          reason,
+			"targetBrowserId": h.targetId,
+			"tabId":           h.tabId,
+		})
+	}
+	if reason != HandoffTargetDisconnected {
+		if target, ok := s.reg.get(h.targetId); ok {
+			_ = target.sendJSON(map[string]interface{}{
+				"type":      "handoff-cancel",
+				"handoffId": h.id,
+			})
+		}
+	}
+	logger.Info("[Handoff] %s: rolled back (%s)", h.id, reason)
+}
+
+// abortHandoffs rolls back the handoffs of a browser that disconnected.
+func (s *Server) abortHandoffs(browserId string) {
+	if _, ok := s.reg.get(browserId); ok {
+		return // replaced by a new connection
+	}
+	for _, h := range s.handoffs.involving(browserId) {
+		reason := HandoffSourceDisconnected
+		if h.targetId == browserId {
+			reason = HandoffTargetDisconnected
+		}
+		s.rollbackHandoff(h.id, reason)
+	}
+}
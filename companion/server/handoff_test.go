@@ -0,0 +1,184 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// testPeer is a browser's end of a live connection to the companion.
+type testPeer struct {
+	conn *clientConn // the companion's end
+	msgs chan map[string]interface{}
+}
+
+// newTestPeer connects a browser to a throwaway WebSocket server and
+// returns both ends.
+func newTestPeer(t *testing.T, browserId string) *testPeer {
+	t.Helper()
+	serverEnd := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		serverEnd <- ws
+	}))
+	t.Cleanup(srv.Close)
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	p := &testPeer{conn: &clientConn{ws: <-serverEnd, browserId: browserId}, msgs: make(chan map[string]interface{}, 16)}
+	t.Cleanup(func() { p.conn.ws.Close() })
+	go func() {
+		defer close(p.msgs)
+		for {
+			var msg map[string]interface{}
+			if err := client.ReadJSON(&msg); err != nil {
+				return
+			}
+			p.msgs <- msg
+		}
+	}()
+	return p
+}
+
+// received describes what the browser got so far as "handoff-result
+// rolled-back timeout, handoff-cancel".
+func (p *testPeer) received(t *testing.T) string {
+	t.Helper()
+	if err := p.conn.sendJSON(map[string]string{"type": "end"}); err != nil {
+		t.Fatal(err)
+	}
+	var parts []string
+	for {
+		select {
+		case msg, ok := <-p.msgs:
+			if !ok || msg["type"] == "end" {
+				return strings.Join(parts, ", ")
+			}
+			s := msg["type"].(string)
+			for _, k := range []string{"status", "reason"} {
+				if v, _ := msg[k].(string); v != "" && v != "handed-off" {
+					s += " " + v
+				}
+			}
+			parts = append(parts, s)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out reading messages")
+		}
+	}
+}
+
+func TestHandoff(t *testing.T) {
+	const url = "https://example.com/moved"
+	tests := []struct {
+		name       string
+		events     []string // run in order; "a+b" runs a and b concurrently
+		wantSource []string // either outcome of a race
+		wantTarget string
+		pending    bool
+	}{
+		{"confirmed", []string{"opened"}, []string{"close-tabs, handoff-result completed"}, "handoff-open", false},
+		{"target failed", []string{"failed"}, []string{"handoff-result rolled-back target-failed"}, "handoff-open, handoff-cancel", false},
+		{"timed out", []string{"timeout"}, []string{"handoff-result rolled-back timeout"}, "handoff-open, handoff-cancel", false},
+		{"confirmed after the timeout", []string{"timeout", "opened"}, []string{"handoff-result rolled-back timeout"}, "handoff-open, handoff-cancel", false},
+		{"timeout after confirmation", []string{"opened", "timeout"}, []string{"close-tabs, handoff-result completed"}, "handoff-open", false},
+		{"confirmation racing the timeout", []string{"opened+timeout"}, []string{
+			"close-tabs, handoff-result completed",
+			"handoff-result rolled-back timeout",
+		}, "", false},
+		{"target disconnected", []string{"target-gone"}, []string{"presence, handoff-result rolled-back target-disconnected"}, "handoff-open", false},
+		{"source reconnected", []string{"source-reconnected"}, []string{""}, "handoff-open", true},
+		{"source reconnected, then confirmed", []string{"source-reconnected", "opened"}, []string{"close-tabs, handoff-result completed"}, "handoff-open", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usage, err := NewUsageTracker(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			aliases, err := NewAliasStore(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := &Server{
+				state: newTestState(map[string]*BrowserData{
+					"src": {BrowserName: "Google Chrome", Online: true, Tabs: []Tab{{ID: 7, URL: url}}},
+					"dst": {BrowserName: "Firefox", Online: true},
+				}),
+				usage:   usage,
+				aliases: aliases,
+				reg:     newConnectionRegistry(),
+			}
+			source, target := newTestPeer(t, "src"), newTestPeer(t, "dst")
+			s.reg.set("src", source.conn)
+			s.reg.set("dst", target.conn)
+
+			id, err := s.StartHandoff("src", 7, "dst", PendingTab{ID: "p", URL: url})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// As when a connection's handler returns (see HandleConnection)
+			disconnect := func(conn *clientConn) {
+				handleDisconnect(conn, s.state, s.usage, s.aliases, s.reg)
+				s.abortHandoffs(conn.browserId)
+			}
+			run := func(event string) {
+				switch event {
+				case "opened", "failed":
+					_ = s.CompleteHandoff("dst", id, event == "opened", "")
+				case "timeout":
+					s.rollbackHandoff(id, HandoffTimedOut) // what the timer does
+				case "target-gone":
+					disconnect(target.conn)
+				case "source-reconnected":
+					old := source.conn
+					source = newTestPeer(t, "src")
+					s.reg.set("src", source.conn)
+					disconnect(old)
+				}
+			}
+			for _, ev := range tt.events {
+				var wg sync.WaitGroup
+				for _, e := range strings.Split(ev, "+") {
+					wg.Add(1)
+					go func(e string) {
+						defer wg.Done()
+						run(e)
+					}(e)
+				}
+				wg.Wait()
+			}
+
+			got := source.received(t)
+			ok := false
+			for _, want := range tt.wantSource {
+				ok = ok || got == want
+			}
+			if !ok {
+				t.Errorf("source got %q, want one of %q", got, tt.wantSource)
+			}
+			if tt.wantTarget != "" {
+				if got := target.received(t); got != tt.wantTarget {
+					t.Errorf("target got %q, want %q", got, tt.wantTarget)
+				}
+			}
+			if _, pending := s.handoffs.take(id); pending != tt.pending {
+				t.Errorf("handoff pending = %v, want %v", pending, tt.pending)
+			}
+			if _, online := s.reg.get("src"); !online {
+				t.Error("source dropped from the registry")
+			}
+		})
+	}
+}
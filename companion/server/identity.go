@@ -0,0 +1,293 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/harshvasudeva/synctabs-companion/logger"
+)
+
+const (
+	MaxProfileLabelLength = 64
+	MaxFingerprintLength  = 128
+)
+
+var ErrCannotMerge = errors.New("cannot merge a browser into itself or while it is online")
+
+// BrowserIdentity is the optional identity a browser sends on 'register'.
+// Fingerprint identifies a browser install and should survive an extension
+// reinstall (which generates a new browserId); ProfileLabel tells apart
+// profiles of the same install.
+type BrowserIdentity struct {
+	ProfileLabel string `json:"profileLabel"`
+	Fingerprint  string `json:"installFingerprint"`
+}
+
+func (ident BrowserIdentity) normalised() BrowserIdentity {
+	return BrowserIdentity{
+		ProfileLabel: truncate(strings.TrimSpace(ident.ProfileLabel), MaxProfileLabelLength),
+		Fingerprint:  truncate(strings.TrimSpace(ident.Fingerprint), MaxFingerprintLength),
+	}
+}
+
+// identityKey is the hashed fingerprint, profile label and browser name of
+// an install, or "" without a fingerprint. Split records these rather than
+// IDs, since a reinstall always arrives with a new ID.
+func identityKey(fingerprint, profileLabel, browserName string) string {
+	if fingerprint == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(fingerprint + "\x00" + profileLabel + "\x00" + browserName))
+	return hex.EncodeToString(sum[:])
+}
+
+func (b *BrowserData) identityKey() string {
+	return identityKey(b.Fingerprint, b.ProfileLabel, b.BrowserName)
+}
+
+// public returns a copy of b without the install secrets: a fingerprint
+// sent to other browsers could be replayed to claim this entry.
+func (b *BrowserData) public() BrowserData {
+	cp := *b
+	cp.Fingerprint = ""
+	cp.DistinctIdentities = nil
+	return cp
+}
+
+// applyIdentity records identity fields sent by the browser. Empty fields
+// leave the stored value untouched so older extensions don't erase them.
+func (b *BrowserData) applyIdentity(ident BrowserIdentity) {
+	ident = ident.normalised()
+	if ident.ProfileLabel != "" {
+		b.ProfileLabel = ident.ProfileLabel
+	}
+	if ident.Fingerprint != "" {
+		b.Fingerprint = ident.Fingerprint
+	}
+}
+
+// inheritFrom carries settings of a replaced or merged entry over to b.
+func (b *BrowserData) inheritFrom(oldId string, old *BrowserData) {
+	b.NeverForget = b.NeverForget || old.NeverForget
+	b.MergedIDs = appendUnique(b.MergedIDs, oldId)
+	for _, id := range old.MergedIDs {
+		b.MergedIDs = appendUnique(b.MergedIDs, id)
+	}
+	for _, id := range old.DistinctFrom {
+		b.DistinctFrom = appendUnique(b.DistinctFrom, id)
+	}
+	for _, key := range old.DistinctIdentities {
+		b.DistinctIdentities = appendUnique(b.DistinctIdentities, key)
+	}
+	if b.ProfileLabel == "" {
+		b.ProfileLabel = old.ProfileLabel
+	}
+	if b.Sharing == "" {
+		b.Sharing = old.Sharing
+	}
+	for _, id := range old.HiddenFrom {
+		b.HiddenFrom = appendUnique(b.HiddenFrom, id)
+	}
+	if b.Fingerprint == "" {
+		b.Fingerprint = old.Fingerprint
+	}
+}
+
+// splitFromIdentity reports whether an install with identity key was split
+// from b.
+func (b *BrowserData) splitFromIdentity(key string) bool {
+	for _, k := range b.DistinctIdentities {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// findReinstall returns the ID of an offline entry that is provably the same
+// install as a newly registering browser: same non-empty fingerprint, same
+// profile label and browser name, and no install with that identity split
+// from it. A matching name alone is never enough. Caller holds s.mu.
+func (s *StateStore) findReinstall(browserId, browserName string, ident BrowserIdentity) string {
+	ident = ident.normalised()
+	key := identityKey(ident.Fingerprint, ident.ProfileLabel, browserName)
+	if key == "" {
+		return ""
+	}
+	match := ""
+	for id, entry := range s.data {
+		if id == browserId || entry.Online || entry.splitFromIdentity(key) {
+			continue
+		}
+		if entry.Fingerprint != ident.Fingerprint ||
+			entry.ProfileLabel != ident.ProfileLabel ||
+			entry.BrowserName != browserName {
+			continue
+		}
+		if match != "" {
+			// Ambiguous: more than one candidate, so nothing is proven
+			return ""
+		}
+		match = id
+	}
+	return match
+}
+
+// Merge folds the offline entry fromId into intoId and removes fromId.
+// intoId keeps its own tabs unless it has none.
+func (s *StateStore) Merge(fromId, intoId string) error {
+	s.mu.Lock()
+	from, okFrom := s.data[fromId]
+	into, okInto := s.data[intoId]
+	if !okFrom || !okInto {
+		s.mu.Unlock()
+		return ErrUnknownBrowser
+	}
+	if fromId == intoId || from.Online {
+		s.mu.Unlock()
+		return ErrCannotMerge
+	}
+
+	into.inheritFrom(fromId, from)
+	into.DistinctFrom = removeString(into.DistinctFrom, fromId)
+	if len(into.Tabs) == 0 && !into.Online {
+		into.Tabs = from.Tabs
+	}
+	delete(s.data, fromId)
+	s.mu.Unlock()
+
+	s.DebouncedSave()
+	return nil
+}
+
+// Split marks two entries as distinct so they are never treated as the
+// same install, and stops either from claiming the other as merged. Each
+// entry also remembers the other's identity, so a later reinstall of one
+// is never merged into the other automatically. Two entries that share an
+// identity are then never auto-merged into at all, as neither is provable.
+func (s *StateStore) Split(aId, bId string) error {
+	s.mu.Lock()
+	a, okA := s.data[aId]
+	b, okB := s.data[bId]
+	if !okA || !okB {
+		s.mu.Unlock()
+		return ErrUnknownBrowser
+	}
+	if aId == bId {
+		s.mu.Unlock()
+		return errors.New("cannot split a browser from itself")
+	}
+	a.DistinctFrom = appendUnique(a.DistinctFrom, bId)
+	b.DistinctFrom = appendUnique(b.DistinctFrom, aId)
+	if key := b.identityKey(); key != "" {
+		a.DistinctIdentities = appendUnique(a.DistinctIdentities, key)
+	}
+	if key := a.identityKey(); key != "" {
+		b.DistinctIdentities = appendUnique(b.DistinctIdentities, key)
+	}
+	a.MergedIDs = removeString(a.MergedIDs, bId)
+	b.MergedIDs = removeString(b.MergedIDs, aId)
+	s.mu.Unlock()
+
+	s.DebouncedSave()
+	return nil
+}
+
+// adoptReplaced moves companion-side data of a replaced or merged entry
+// (pending tabs, alias, workspace entries) onto its successor.
+func (s *Server) adoptReplaced(oldId, newId string) {
+	if moved := s.pending.MoveQueue(oldId, newId); moved > 0 {
+		logger.Info("[Identity] Moved %d pending tab(s) %s → %s", moved, oldId, newId)
+	}
+	if moved := s.workspaces.MoveBrowser(oldId, newId); moved > 0 {
+		logger.Info("[Identity] Moved %s → %s in %d workspace(s)", oldId, newId, moved)
+	}
+	if old := s.aliases.Get(oldId); !old.isEmpty() {
+		if s.aliases.Get(newId).isEmpty() {
+			if _, err := s.aliases.Set(newId, old); err != nil {
+				logger.Warn("Could not move alias %s → %s: %v", oldId, newId, err)
+			}
+		}
+		if err := s.aliases.Forget(oldId); err != nil {
+			logger.Error("Alias save failed: %v", err)
+		}
+	}
+	s.reg.broadcast("", map[string]interface{}{
+		"type":      "browser-removed",
+		"browserId": oldId,
+	})
+}
+
+// MergeBrowsers consolidates fromId into intoId.
+func (s *Server) MergeBrowsers(fromId, intoId string) error {
+	if err := s.state.Merge(fromId, intoId); err != nil {
+		return err
+	}
+	s.adoptReplaced(fromId, intoId)
+	logger.Info("[Identity] Merged %s into %s", fromId, intoId)
+	return nil
+}
+
+// SplitBrowsers marks two entries as separate browsers.
+func (s *Server) SplitBrowsers(aId, bId string) error {
+	if err := s.state.Split(aId, bId); err != nil {
+		return err
+	}
+	logger.Info("[Identity] Split %s from %s", aId, bId)
+	return nil
+}
+
+// handleBrowserIdentity serves POST /browsers/{id}/merge {"into": id}
+// and POST /browsers/{id}/split {"from": id}.
+func (s *Server) handleBrowserIdentity(w http.ResponseWriter, r *http.Request, browserId, op string) {
+	var req struct {
+		Into string `json:"into"`
+		From string `json:"from"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	if op == "merge" {
+		err = s.MergeBrowsers(browserId, req.Into)
+	} else {
+		err = s.SplitBrowsers(browserId, req.From)
+	}
+	if errors.Is(err, ErrUnknownBrowser) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"ok": true})
+}
+
+func appendUnique(list []string, v string) []string {
+	for _, x := range list {
+		if x == v {
+			return list
+		}
+	}
+	return append(list, v)
+}
+
+func removeString(list []string, v string) []string {
+	out := list[:0]
+	for _, x := range list {
+		if x != v {
+			out = append(out, x)
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
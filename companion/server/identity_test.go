@@ -0,0 +1,88 @@
+package server
+
+import "testing"
+
+// newTestState returns a StateStore over entries that never touches disk.
+func newTestState(entries map[string]*BrowserData) *StateStore {
+	if entries == nil {
+		entries = make(map[string]*BrowserData)
+	}
+	return &StateStore{data: entries, saveCh: make(chan struct{}, 1)}
+}
+
+func TestRegisterIdentity(t *testing.T) {
+	const fp = "fingerprint-1"
+	chrome := func(label, fingerprint string, online bool) *BrowserData {
+		return &BrowserData{
+			BrowserName:  "Google Chrome",
+			Tabs:         []Tab{{ID: 1, URL: "https://example.com/" + label}},
+			Online:       online,
+			ProfileLabel: label,
+			Fingerprint:  fingerprint,
+			NeverForget:  true,
+		}
+	}
+	work := BrowserIdentity{ProfileLabel: "Work", Fingerprint: fp}
+
+	tests := []struct {
+		name     string
+		existing map[string]*BrowserData
+		register string // browser name
+		ident    BrowserIdentity
+		replaced string
+	}{
+		{"same name without fingerprint", map[string]*BrowserData{"old": chrome("", "", false)}, "Google Chrome", BrowserIdentity{}, ""},
+		{"same name, other fingerprint", map[string]*BrowserData{"old": chrome("Work", "other", false)}, "Google Chrome", work, ""},
+		{"second profile of the install", map[string]*BrowserData{"old": chrome("Personal", fp, false)}, "Google Chrome", work, ""},
+		{"reinstall", map[string]*BrowserData{"old": chrome("Work", fp, false)}, "Google Chrome", work, "old"},
+		{"reinstall, label padded", map[string]*BrowserData{"old": chrome("Work", fp, false)}, "Google Chrome", BrowserIdentity{ProfileLabel: " Work ", Fingerprint: fp}, "old"},
+		{"old entry still online", map[string]*BrowserData{"old": chrome("Work", fp, true)}, "Google Chrome", work, ""},
+		{"other browser", map[string]*BrowserData{"old": chrome("Work", fp, false)}, "Microsoft Edge", work, ""},
+		{"ambiguous", map[string]*BrowserData{"a": chrome("Work", fp, false), "b": chrome("Work", fp, false)}, "Google Chrome", work, ""},
+		{"split from it", map[string]*BrowserData{"old": func() *BrowserData {
+			b := chrome("Work", fp, false)
+			b.DistinctIdentities = []string{identityKey(fp, "Work", "Google Chrome")}
+			return b
+		}()}, "Google Chrome", work, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(tt.existing)
+			s := newTestState(tt.existing)
+			_, replaced := s.Register("new", tt.register, tt.ident)
+			if replaced != tt.replaced {
+				t.Fatalf("Register replaced %q, want %q", replaced, tt.replaced)
+			}
+			entry, ok := s.Get("new")
+			if !ok || !entry.Online {
+				t.Fatalf("new entry missing or offline: %+v", entry)
+			}
+			if tt.replaced == "" {
+				if len(s.data) != before+1 {
+					t.Errorf("have %d entries, want %d: an existing entry was removed", len(s.data), before+1)
+				}
+				return
+			}
+			if _, ok := s.Get(tt.replaced); ok {
+				t.Errorf("replaced entry %q still present", tt.replaced)
+			}
+			if !entry.NeverForget || len(entry.MergedIDs) != 1 || entry.MergedIDs[0] != tt.replaced {
+				t.Errorf("new entry did not inherit from %q: %+v", tt.replaced, entry)
+			}
+		})
+	}
+}
+
+func TestBuildStateHidesFingerprint(t *testing.T) {
+	s := newTestState(map[string]*BrowserData{
+		"a": {BrowserName: "Google Chrome", Fingerprint: "secret", ProfileLabel: "Work", Online: true},
+		"b": {BrowserName: "Google Chrome", Online: true},
+	})
+	got := s.BuildStateForClient("b")["a"]
+	if got.Fingerprint != "" {
+		t.Errorf("fingerprint sent to another browser: %q", got.Fingerprint)
+	}
+	if got.ProfileLabel != "Work" {
+		t.Errorf("profile label = %q, want Work", got.ProfileLabel)
+	}
+}
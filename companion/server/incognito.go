@@ -0,0 +1,75 @@
+package server
+
+import (
+	"github.com/harshvasudeva/synctabs-companion/config"
+	"github.com/harshvasudeva/synctabs-companion/logger"
+)
+
+// keepsIncognito reports whether incognito tabs are accepted at all.
+func keepsIncognito(policy string) bool {
+	return policy != config.IncognitoDrop
+}
+
+// persistsIncognito reports whether incognito tabs may be written to disk
+// (or to history such as stats and usage).
+func persistsIncognito(policy string) bool {
+	return policy == config.IncognitoSync
+}
+
+// withoutIncognito returns tabs minus incognito ones and how many were removed.
+// The input slice is not modified.
+func withoutIncognito(tabs []Tab) ([]Tab, int) {
+	removed := 0
+	for _, t := range tabs {
+		if t.Incognito {
+			removed++
+		}
+	}
+	if removed == 0 {
+		return tabs, 0
+	}
+	out := make([]Tab, 0, len(tabs)-removed)
+	for _, t := range tabs {
+		if !t.Incognito {
+			out = append(out, t)
+		}
+	}
+	return out, removed
+}
+
+// PurgeIncognito removes incognito tabs the policy no longer allows to be
+// held: all of them under "drop", those of offline browsers under
+// "online-only". Returns the IDs of browsers whose tabs changed.
+func (s *StateStore) PurgeIncognito(policy string) []string {
+	if persistsIncognito(policy) {
+		return nil
+	}
+	s.mu.Lock()
+	var changed []string
+	for id, entry := range s.data {
+		if entry.Online && keepsIncognito(policy) {
+			continue
+		}
+		if tabs, n := withoutIncognito(entry.Tabs); n > 0 {
+			entry.Tabs = tabs
+			changed = append(changed, id)
+		}
+	}
+	s.mu.Unlock()
+	if len(changed) > 0 {
+		s.DebouncedSave()
+	}
+	return changed
+}
+
+// ApplyIncognitoPolicy purges incognito tabs under the current policy and
+// pushes the trimmed tab lists to peers.
+func (s *Server) ApplyIncognitoPolicy() {
+	policy := config.Get().IncognitoPolicy
+	for _, id := range s.state.PurgeIncognito(policy) {
+		if data, ok := s.state.Get(id); ok {
+			broadcastBrowserTabs(s.reg, s.aliases, id, data)
+		}
+		logger.Info("[Incognito] Purged incognito tabs of %s (policy %s)", id, policy)
+	}
+}
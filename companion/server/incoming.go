@@ -0,0 +1,204 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/harshvasudeva/synctabs-companion/config"
+	"github.com/harshvasudeva/synctabs-companion/logger"
+)
+
+// Reason codes reported to a sender whose tab was not accepted
+const (
+	RejectSenderNotAllowed = "sender-not-allowed"
+	RejectURLBlocked       = "url-blocked"
+	RejectDeclined         = "declined"
+)
+
+// StatusAwaitingConfirm is the send status of a tab held for the target to accept.
+const StatusAwaitingConfirm = "awaiting-confirmation"
+
+// RejectedError is returned when a target's incoming rules refuse a tab.
+type RejectedError struct {
+	Reason string // Reject* code
+}
+
+func (e *RejectedError) Error() string {
+	switch e.Reason {
+	case RejectSenderNotAllowed:
+		return "target browser does not accept tabs from this sender"
+	case RejectURLBlocked:
+		return "target browser blocks this URL"
+	case RejectDeclined:
+		return "target browser declined the tab"
+	}
+	return "tab rejected by target browser"
+}
+
+// compiledIncoming is the compiled form of config.IncomingRules.
+type compiledIncoming struct {
+	allow          map[string]bool
+	confirmUnknown bool
+	blockDomains   []string
+	blockPatterns  []*regexp.Regexp
+}
+
+var (
+	incomingMu  sync.RWMutex
+	incomingCur = map[string]*compiledIncoming{}
+)
+
+// SetIncomingRules compiles per-target rules and makes them current.
+// Rules are validated by config, so compile errors only skip the entry.
+func SetIncomingRules(rules map[string]config.IncomingRules) {
+	compiled := make(map[string]*compiledIncoming, len(rules))
+	for target, r := range rules {
+		c := &compiledIncoming{allow: make(map[string]bool), confirmUnknown: r.ConfirmUnknown}
+		for _, id := range r.AllowSenders {
+			c.allow[id] = true
+		}
+		for _, d := range r.BlockDomains {
+			if d = normaliseDomain(d); d != "" {
+				c.blockDomains = append(c.blockDomains, d)
+			}
+		}
+		for _, pat := range r.BlockPatterns {
+			if re, err := regexp.Compile(pat); err == nil {
+				c.blockPatterns = append(c.blockPatterns, re)
+			} else {
+				logger.Warn("Ignoring incoming pattern %q for %s: %v", pat, target, err)
+			}
+		}
+		compiled[target] = c
+	}
+	incomingMu.Lock()
+	incomingCur = compiled
+	incomingMu.Unlock()
+}
+
+// checkIncoming applies targetId's incoming rules to a tab from senderId.
+// Returns whether the tab must be confirmed, or a *RejectedError.
+func checkIncoming(targetId, senderId, rawURL string) (bool, error) {
+	incomingMu.RLock()
+	c := incomingCur[targetId]
+	incomingMu.RUnlock()
+	if c == nil {
+		return false, nil
+	}
+
+	host := ""
+	if u, err := url.Parse(rawURL); err == nil {
+		host = strings.ToLower(u.Hostname())
+	}
+	for _, d := range c.blockDomains {
+		if domainMatches(host, d) {
+			return false, &RejectedError{Reason: RejectURLBlocked}
+		}
+	}
+	for _, re := range c.blockPatterns {
+		if re.MatchString(rawURL) {
+			return false, &RejectedError{Reason: RejectURLBlocked}
+		}
+	}
+
+	if c.allow[senderId] {
+		return false, nil
+	}
+	if c.confirmUnknown {
+		return true, nil
+	}
+	if len(c.allow) > 0 {
+		return false, &RejectedError{Reason: RejectSenderNotAllowed}
+	}
+	return false, nil
+}
+
+// sendConfirmRequest asks a connected target to accept held tabs.
+func sendConfirmRequest(conn *clientConn, tabs []PendingTab) {
+	_ = conn.sendJSON(map[string]interface{}{
+		"type": "confirm-tabs",
+		"tabs": tabs,
+	})
+}
+
+// sendRejected reports a refused tab to its sender, if connected.
+func (s *Server) sendRejected(senderId, targetId, rawURL, reason string) {
+	conn, ok := s.reg.get(senderId)
+	if !ok {
+		return
+	}
+	_ = conn.sendJSON(map[string]interface{}{
+		"type":            "send-tab-rejected",
+		"targetBrowserId": targetId,
+		"url":             rawURL,
+		"reason":          reason,
+		"message":         (&RejectedError{Reason: reason}).Error(),
+	})
+}
+
+// ConfirmPending accepts or declines a tab held for targetId. Accepted tabs
+// are delivered (or queued if the target went offline, held in its quiet
+// hours); the sender is told the outcome.
+func (s *Server) ConfirmPending(targetId, itemId string, accept bool) (string, error) {
+	tab, err := s.pending.TakeAwaiting(targetId, itemId)
+	if err != nil {
+		return "", err
+	}
+	if !accept {
+		s.sendRejected(tab.SenderBrowserID, targetId, tab.URL, RejectDeclined)
+		logger.Info("[Send] %s declined tab from %s: %s", targetId, tab.SenderBrowserID, LogURL(tab.URL))
+		return "declined", nil
+	}
+
+	tab.AwaitingConfirm = false
+	status := "delivered"
+	conn, online := s.reg.get(targetId)
+	until, quiet := quietUntil(targetId, time.Now())
+	if online && !quiet {
+		_ = conn.sendJSON(map[string]interface{}{
+			"type": "pending-tabs",
+			"tabs": []PendingTab{tab},
+		})
+	} else {
+		status = "queued"
+		if err := s.pending.Requeue(targetId, []PendingTab{tab}); err != nil {
+			return "", err
+		}
+		if quiet {
+			s.pending.Hold(targetId, until)
+			status = StatusHeld
+		}
+	}
+	if conn, ok := s.reg.get(tab.SenderBrowserID); ok {
+		_ = conn.sendJSON(map[string]interface{}{
+			"type":            "send-tab-ack",
+			"status":          status,
+			"targetBrowserId": targetId,
+			"confirmed":       true,
+		})
+	}
+	logger.Info("[Send] %s accepted tab from %s (%s): %s", targetId, tab.SenderBrowserID, status, LogURL(tab.URL))
+	return status, nil
+}
+
+// handlePendingConfirm serves POST /pending/{target}/{id}/confirm {"accept"}.
+func (s *Server) handlePendingConfirm(w http.ResponseWriter, r *http.Request, target, itemId string) {
+	var req struct {
+		Accept bool `json:"accept"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	status, err := s.ConfirmPending(target, itemId, req.Accept)
+	if err != nil {
+		pendingError(w, err)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"ok": true, "status": status})
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/harshvasudeva/synctabs-companion/config"
+)
+
+func TestCheckIncoming(t *testing.T) {
+	SetIncomingRules(map[string]config.IncomingRules{
+		"strict":  {AllowSenders: []string{"laptop"}},
+		"careful": {AllowSenders: []string{"laptop"}, ConfirmUnknown: true},
+		"blocks":  {BlockDomains: []string{".Casino.example"}, BlockPatterns: []string{`/admin/`, `(`}},
+		"mixed":   {AllowSenders: []string{"laptop"}, ConfirmUnknown: true, BlockDomains: []string{"ads.example"}},
+	})
+	defer SetIncomingRules(nil)
+
+	tests := []struct {
+		name    string
+		target  string
+		sender  string
+		url     string
+		confirm bool
+		reject  string // Reject* code, or "" to accept
+	}{
+		{"no rules", "other", "phone", "https://a.example/", false, ""},
+		{"allowed sender", "strict", "laptop", "https://a.example/", false, ""},
+		{"unlisted sender", "strict", "phone", "https://a.example/", false, RejectSenderNotAllowed},
+		{"pseudo-sender", "strict", ImportSenderID, "https://a.example/", false, RejectSenderNotAllowed},
+		{"confirm unknown", "careful", "phone", "https://a.example/", true, ""},
+		{"known skips confirm", "careful", "laptop", "https://a.example/", false, ""},
+		{"blocked domain", "blocks", "phone", "https://casino.example/", false, RejectURLBlocked},
+		{"blocked subdomain", "blocks", "phone", "https://www.CASINO.example/", false, RejectURLBlocked},
+		{"blocked pattern", "blocks", "phone", "https://a.example/admin/users", false, RejectURLBlocked},
+		{"not blocked", "blocks", "phone", "https://a.example/home", false, ""},
+		{"block beats allow", "mixed", "laptop", "https://ads.example/x", false, RejectURLBlocked},
+		{"block beats confirm", "mixed", "phone", "https://ads.example/x", false, RejectURLBlocked},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			confirm, err := checkIncoming(tt.target, tt.sender, tt.url)
+			reason := ""
+			var rejected *RejectedError
+			if errors.As(err, &rejected) {
+				reason = rejected.Reason
+			} else if err != nil {
+				t.Fatalf("checkIncoming error = %v", err)
+			}
+			if confirm != tt.confirm || reason != tt.reject {
+				t.Errorf("checkIncoming(%q, %q, %q) = %v, %q; want %v, %q", tt.target, tt.sender, tt.url, confirm, reason, tt.confirm, tt.reject)
+			}
+		})
+	}
+}
@@ -0,0 +1,397 @@
+package server
+
+import (
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/harshvasudeva/synctabs-companion/config"
+	"github.com/harshvasudeva/synctabs-companion/logger"
+)
+
+const (
+	// MirrorEchoTimeout is how long a browser has to carry out a mirror
+	// instruction; until then its matching change is not mirrored back.
+	MirrorEchoTimeout = 30 * time.Second
+	// MirrorConflictWindow: a close arriving this soon after another
+	// browser opened the same URL is a conflict, and open wins.
+	MirrorConflictWindow = 5 * time.Second
+	// MirrorForgetAfter is how long a closed URL is remembered, so that
+	// browsers reconnecting later close it too.
+	MirrorForgetAfter = 24 * time.Hour
+)
+
+// compiledMirror is the compiled form of a config.MirrorRule.
+type compiledMirror struct {
+	config.MirrorRule
+	pattern  *regexp.Regexp
+	browsers map[string]bool
+}
+
+func (r *compiledMirror) matches(t Tab) bool {
+	if t.Incognito {
+		return false
+	}
+	if r.Pinned && !t.Pinned {
+		return false
+	}
+	if r.Window != "" && t.WindowName != r.Window {
+		return false
+	}
+	return r.pattern == nil || r.pattern.MatchString(t.URL)
+}
+
+func (r *compiledMirror) member(browserId string) bool {
+	return len(r.browsers) == 0 || r.browsers[browserId]
+}
+
+// mirrorURL is the shared state of one mirrored URL.
+type mirrorURL struct {
+	open  bool
+	at    time.Time
+	by    string // browser that made the last change
+	title string
+}
+
+// mirrorSet is the runtime state of one rule.
+type mirrorSet struct {
+	urls   map[string]*mirrorURL
+	seen   map[string]map[string]bool      // browser → matching URLs at its last update; absent until it reports after connecting
+	frozen map[string]bool                 // browsers whose seen was kept through their quiet hours
+	expect map[string]map[string]time.Time // browser → "open <url>" / "close <url>" → deadline
+}
+
+func newMirrorSet() *mirrorSet {
+	return &mirrorSet{
+		urls:   make(map[string]*mirrorURL),
+		seen:   make(map[string]map[string]bool),
+		frozen: make(map[string]bool),
+		expect: make(map[string]map[string]time.Time),
+	}
+}
+
+func (m *mirrorSet) expecting(browserId, op, url string) bool {
+	_, ok := m.expect[browserId][op+" "+url]
+	return ok
+}
+
+func (m *mirrorSet) setExpect(browserId, op, url string, now time.Time) {
+	if m.expect[browserId] == nil {
+		m.expect[browserId] = make(map[string]time.Time)
+	}
+	m.expect[browserId][op+" "+url] = now.Add(MirrorEchoTimeout)
+}
+
+// consume reports whether a change was one the companion asked for, and
+// forgets the expectation.
+func (m *mirrorSet) consume(browserId, op, url string) bool {
+	if !m.expecting(browserId, op, url) {
+		return false
+	}
+	delete(m.expect[browserId], op+" "+url)
+	return true
+}
+
+func (m *mirrorSet) prune(now time.Time) {
+	for id, keys := range m.expect {
+		for k, deadline := range keys {
+			if now.After(deadline) {
+				delete(keys, k)
+			}
+		}
+		if len(keys) == 0 {
+			delete(m.expect, id)
+		}
+	}
+	for url, st := range m.urls {
+		if !st.open && now.Sub(st.at) > MirrorForgetAfter {
+			delete(m.urls, url)
+		}
+	}
+}
+
+// mirrorEngine holds the mirror rules and their state. State is kept in
+// memory; after a restart the browsers' current tabs are merged again.
+type mirrorEngine struct {
+	mu    sync.Mutex
+	rules []*compiledMirror
+	sets  map[string]*mirrorSet // by rule name
+}
+
+// mirrorAction is an instruction for one browser.
+type mirrorAction struct {
+	browserId string
+	msg       map[string]interface{}
+}
+
+// SetMirrorRules compiles rules and makes them current, starting every
+// rule afresh. Rules are validated by config, so compile errors only skip
+// the offending entry.
+func (s *Server) SetMirrorRules(rules []config.MirrorRule) {
+	compiled := make([]*compiledMirror, 0, len(rules))
+	for _, r := range rules {
+		cr := &compiledMirror{MirrorRule: r, browsers: make(map[string]bool)}
+		if r.Pattern != "" {
+			re, err := regexp.Compile(r.Pattern)
+			if err != nil {
+				logger.Warn("Ignoring mirror rule %q: %v", r.Name, err)
+				continue
+			}
+			cr.pattern = re
+		}
+		for _, id := range r.Browsers {
+			cr.browsers[id] = true
+		}
+		compiled = append(compiled, cr)
+	}
+	s.mirror.mu.Lock()
+	s.mirror.rules = compiled
+	s.mirror.sets = make(map[string]*mirrorSet, len(compiled))
+	for _, r := range compiled {
+		s.mirror.sets[r.Name] = newMirrorSet()
+	}
+	s.mirror.mu.Unlock()
+}
+
+// mirrorReset makes the next tabs update from browserId a fresh baseline,
+// as after it (re)connects.
+func (s *Server) mirrorReset(browserId string) {
+	s.mirror.mu.Lock()
+	defer s.mirror.mu.Unlock()
+	for _, set := range s.mirror.sets {
+		delete(set.seen, browserId)
+		delete(set.frozen, browserId)
+		delete(set.expect, browserId)
+	}
+}
+
+// mirrorFreeze keeps browserId's last seen tabs while it is in its quiet
+// hours, so that its first update afterwards is compared with them.
+func (s *Server) mirrorFreeze(browserId string) {
+	s.mirror.mu.Lock()
+	defer s.mirror.mu.Unlock()
+	for _, set := range s.mirror.sets {
+		if _, known := set.seen[browserId]; known {
+			set.frozen[browserId] = true
+		}
+	}
+}
+
+// mirrorTabs compares a browser's new tabs with its last update and tells
+// the other connected browsers of each rule to open or close the same
+// URLs. The first update after connecting is reconciled against the
+// shared state instead: the browser opens mirrored URLs it lacks and
+// closes ones the others closed while it was away.
+//
+// Changes the companion itself asked for are not mirrored back. When one
+// browser closes a URL another has just opened, open wins and the closing
+// browser is told to reopen it. Browsers in their quiet hours take no
+// part; afterwards their own changes since the last update before quiet
+// hours are mirrored, and they catch up with the others' changes.
+func (s *Server) mirrorTabs(browserId string, tabs []Tab) {
+	now := time.Now()
+	all := s.state.GetAll()
+	if self, ok := all[browserId]; !ok || self.Virtual || self.Sharing == SharingPrivate {
+		return
+	}
+	// A browser in its quiet hours is sent nothing and its changes wait:
+	// its last update is kept to compare with once they end
+	if _, quiet := quietUntil(browserId, now); quiet {
+		s.mirrorFreeze(browserId)
+		return
+	}
+	online := s.reg.all()
+	for id := range online {
+		if _, quiet := quietUntil(id, now); quiet {
+			delete(online, id)
+		}
+	}
+
+	s.mirror.mu.Lock()
+	var actions []mirrorAction
+	for _, r := range s.mirror.rules {
+		if r.member(browserId) {
+			actions = append(actions, s.mirror.sets[r.Name].observe(r, browserId, tabs, all, online, s.state.visibility, mirrorAccepts, now)...)
+		}
+	}
+	s.mirror.mu.Unlock()
+
+	for _, a := range actions {
+		if conn, ok := online[a.browserId]; ok {
+			_ = conn.sendJSON(a.msg)
+		}
+	}
+}
+
+// mirrorAccepts reports whether targetId's incoming rules take url from
+// senderId without asking. Mirrored tabs are never held for confirmation;
+// a target that would be asked simply does not get them.
+func mirrorAccepts(targetId, senderId, url string) bool {
+	confirm, err := checkIncoming(targetId, senderId, url)
+	return err == nil && !confirm
+}
+
+// observe implements mirrorTabs for one rule. Caller holds the engine lock.
+func (m *mirrorSet) observe(
+	r *compiledMirror,
+	browserId string,
+	tabs []Tab,
+	all map[string]BrowserData,
+	online map[string]*clientConn,
+	visibility func(aboutId, viewerId string) shareLevel,
+	accepts func(targetId, senderId, url string) bool,
+	now time.Time,
+) []mirrorAction {
+	m.prune(now)
+
+	cur := make(map[string]Tab)
+	for _, t := range tabs {
+		if r.matches(t) {
+			cur[t.URL] = t
+		}
+	}
+
+	// Connected members that may see this browser's tabs
+	var peers []string
+	for id := range online {
+		data, ok := all[id]
+		if id == browserId || !ok || data.Sharing == SharingPrivate || !r.member(id) {
+			continue
+		}
+		if visibility(browserId, id) == shareFull {
+			peers = append(peers, id)
+		}
+	}
+
+	var actions []mirrorAction
+	open := func(target, sender, url, title string, conflict bool) {
+		if !accepts(target, sender, url) {
+			return
+		}
+		m.setExpect(target, "open", url, now)
+		actions = append(actions, mirrorAction{target, map[string]interface{}{
+			"type":       "mirror-open",
+			"rule":       r.Name,
+			"url":        url,
+			"title":      title,
+			"pinned":     r.Pinned,
+			"windowName": r.Window,
+			"conflict":   conflict,
+		}})
+	}
+	closeIn := func(target, url string, ids []int) {
+		m.setExpect(target, "close", url, now)
+		actions = append(actions, mirrorAction{target, map[string]interface{}{
+			"type":   "mirror-close",
+			"rule":   r.Name,
+			"url":    url,
+			"tabIds": ids,
+		}})
+	}
+
+	// catchUp brings this browser in line with the shared state, except
+	// for the URLs it changed itself
+	catchUp := func(changed map[string]bool) {
+		for url, st := range m.urls {
+			_, has := cur[url]
+			switch {
+			case changed[url]:
+			case st.open && !has && !m.expecting(browserId, "open", url):
+				open(browserId, st.by, url, st.title, false)
+			case !st.open && has && !m.expecting(browserId, "close", url):
+				closeIn(browserId, url, tabIDsFor(r, tabs, url))
+			}
+		}
+	}
+
+	prev, known := m.seen[browserId]
+	wasQuiet := m.frozen[browserId]
+	delete(m.frozen, browserId)
+	seen := make(map[string]bool, len(cur))
+	for url := range cur {
+		seen[url] = true
+	}
+	m.seen[browserId] = seen
+
+	var added, removed []string
+	if known {
+		changed := make(map[string]bool)
+		for url := range cur {
+			if !prev[url] {
+				added = append(added, url)
+				changed[url] = true
+			}
+		}
+		for url := range prev {
+			if _, ok := cur[url]; !ok {
+				removed = append(removed, url)
+				changed[url] = true
+			}
+		}
+		// The others' changes during its quiet hours were not sent to it
+		if wasQuiet {
+			catchUp(changed)
+		}
+	} else {
+		// Baseline: catch up and share what only this browser has
+		catchUp(nil)
+		for url := range cur {
+			if _, ok := m.urls[url]; !ok {
+				added = append(added, url)
+			}
+		}
+	}
+
+	for _, url := range added {
+		t := cur[url]
+		if m.consume(browserId, "open", url) {
+			continue
+		}
+		if st := m.urls[url]; st != nil && st.open {
+			continue // opened elsewhere at the same time; peers already told
+		}
+		m.urls[url] = &mirrorURL{open: true, at: now, by: browserId, title: t.Title}
+		for _, p := range peers {
+			if tabIDsFor(r, all[p].Tabs, url) == nil && !m.expecting(p, "open", url) {
+				open(p, browserId, url, t.Title, false)
+			}
+		}
+	}
+
+	for _, url := range removed {
+		if m.consume(browserId, "close", url) {
+			continue
+		}
+		st := m.urls[url]
+		if st == nil || !st.open {
+			continue
+		}
+		if st.by != browserId && now.Sub(st.at) < MirrorConflictWindow {
+			logger.Info("[Mirror] %s: %s closed a tab %s just opened; keeping it: %s", r.Name, browserId, st.by, LogURL(url))
+			open(browserId, st.by, url, st.title, true)
+			continue
+		}
+		st.open, st.at, st.by = false, now, browserId
+		for _, p := range peers {
+			if ids := tabIDsFor(r, all[p].Tabs, url); ids != nil && !m.expecting(p, "close", url) {
+				closeIn(p, url, ids)
+			}
+		}
+	}
+
+	if len(actions) > 0 {
+		logger.Debug("[Mirror] %s: %s changed %d URL(s), %d instruction(s)", r.Name, browserId, len(added)+len(removed), len(actions))
+	}
+	return actions
+}
+
+// tabIDsFor returns the IDs of the tabs matching r that show url.
+func tabIDsFor(r *compiledMirror, tabs []Tab, url string) []int {
+	var ids []int
+	for _, t := range tabs {
+		if t.URL == url && r.matches(t) {
+			ids = append(ids, t.ID)
+		}
+	}
+	return ids
+}
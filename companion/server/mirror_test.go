@@ -0,0 +1,132 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/harshvasudeva/synctabs-companion/config"
+)
+
+// mirrorTabsOf builds tabs from "url" or "url@window" specs.
+func mirrorTabsOf(specs []string) []Tab {
+	tabs := make([]Tab, 0, len(specs))
+	for i, spec := range specs {
+		url, window, _ := strings.Cut(spec, "@")
+		tabs = append(tabs, Tab{ID: i + 1, URL: url, Title: url, WindowName: window})
+	}
+	return tabs
+}
+
+// actionsString describes mirror actions as "b open x, a close y": "!"
+// marks a conflict and "@" the window a tab is to open in.
+func actionsString(actions []mirrorAction) string {
+	parts := make([]string, 0, len(actions))
+	for _, a := range actions {
+		op := strings.TrimPrefix(a.msg["type"].(string), "mirror-")
+		s := fmt.Sprintf("%s %s %s", a.browserId, op, a.msg["url"])
+		if w, _ := a.msg["windowName"].(string); w != "" {
+			s += "@" + w
+		}
+		if c, _ := a.msg["conflict"].(bool); c {
+			s += "!"
+		}
+		parts = append(parts, s)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
+
+func TestMirrorObserve(t *testing.T) {
+	type step struct {
+		from  string
+		tabs  []string
+		after time.Duration // since the previous step; 0 for 10s
+		quiet []string      // browsers in their quiet hours
+		want  string
+	}
+	tests := []struct {
+		name  string
+		rule  config.MirrorRule
+		steps []step
+	}{
+		{"open and close, echoes not mirrored back", config.MirrorRule{Pattern: "."}, []step{
+			{from: "b", want: ""},
+			{from: "a", tabs: []string{"x"}, want: "b open x"},
+			{from: "b", tabs: []string{"x"}, want: ""},
+			{from: "b", want: "a close x"},
+			{from: "a", want: ""},
+		}},
+		{"close right after open: open wins", config.MirrorRule{Pattern: "."}, []step{
+			{from: "b", want: ""},
+			{from: "a", tabs: []string{"x"}, want: "b open x"},
+			{from: "b", tabs: []string{"x"}, after: time.Second, want: ""},
+			{from: "b", after: time.Second, want: "b open x!"},
+			{from: "b", tabs: []string{"x"}, want: ""},
+			{from: "a", tabs: []string{"x"}, want: ""},
+		}},
+		{"window selector", config.MirrorRule{Window: "Research"}, []step{
+			{from: "b", tabs: []string{"y@Research"}, want: "a open y@Research"},
+			{from: "a", tabs: []string{"x@Research", "y@Research", "z@Other", "w"}, want: "b open x@Research"},
+			{from: "b", tabs: []string{"y@Research", "x@Research"}, want: ""},
+			{from: "a", tabs: []string{"y@Research", "z@Other", "w"}, want: "b close x"},
+			{from: "a", tabs: []string{"y", "z@Other", "w"}, want: "b close y"},
+		}},
+		{"quiet hours: catch up without reopening what was closed", config.MirrorRule{Pattern: "."}, []step{
+			{from: "b", want: ""},
+			{from: "a", tabs: []string{"x", "y"}, want: "b open x, b open y"},
+			{from: "b", tabs: []string{"x", "y"}, want: ""},
+			{from: "b", tabs: []string{"y"}, quiet: []string{"b"}, want: ""},
+			{from: "a", tabs: []string{"x", "y", "z"}, quiet: []string{"b"}, want: ""},
+			{from: "a", tabs: []string{"x", "z"}, quiet: []string{"b"}, want: ""},
+			{from: "b", tabs: []string{"y", "w"}, want: "a close x, a open w, b close y, b open z"},
+			{from: "a", tabs: []string{"z", "w"}, want: ""},
+			{from: "b", tabs: []string{"w", "z"}, want: ""},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.Name = "r"
+			s := &Server{}
+			s.SetMirrorRules([]config.MirrorRule{tt.rule})
+			r, set := s.mirror.rules[0], s.mirror.sets["r"]
+			all := map[string]BrowserData{"a": {}, "b": {}}
+			full := func(aboutId, viewerId string) shareLevel { return shareFull }
+			accepts := func(targetId, senderId, url string) bool { return true }
+
+			now := time.Date(2026, 1, 5, 12, 0, 0, 0, time.UTC)
+			for i, st := range tt.steps {
+				if st.after == 0 {
+					st.after = 10 * time.Second
+				}
+				now = now.Add(st.after)
+				tabs := mirrorTabsOf(st.tabs)
+				data := all[st.from]
+				data.Tabs = tabs
+				all[st.from] = data
+
+				quiet := make(map[string]bool)
+				for _, id := range st.quiet {
+					quiet[id] = true
+				}
+				var got string
+				if quiet[st.from] {
+					s.mirrorFreeze(st.from)
+				} else {
+					online := make(map[string]*clientConn)
+					for id := range all {
+						if !quiet[id] {
+							online[id] = &clientConn{browserId: id}
+						}
+					}
+					got = actionsString(set.observe(r, st.from, tabs, all, online, full, accepts, now))
+				}
+				if got != st.want {
+					t.Fatalf("step %d (%s %v): actions %q, want %q", i+1, st.from, st.tabs, got, st.want)
+				}
+			}
+		})
+	}
+}
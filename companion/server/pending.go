@@ -2,6 +2,7 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -10,23 +11,79 @@ import (
 
 	"github.com/harshvasudeva/synctabs-companion/config"
 	"github.com/harshvasudeva/synctabs-companion/logger"
+	"github.com/harshvasudeva/synctabs-companion/schema"
 )
 
-const MaxPendingPerBrowser = 50
+// MaxPendingBundle caps the tabs folded into one collapsed entry.
+const MaxPendingBundle = 1000
+
+// ErrQueueFull is returned when a tab cannot be queued under the target's
+// overflow policy.
+var ErrQueueFull = errors.New("pending queue full")
+
+// ErrUnknownPending is returned for a queued item ID that does not exist.
+var ErrUnknownPending = errors.New("unknown pending tab")
 
 // PendingTab represents a tab queued for offline delivery.
 type PendingTab struct {
+	ID                string `json:"id,omitempty"` // assigned when queued
 	URL               string `json:"url"`
 	Title             string `json:"title"`
 	FavIconURL        string `json:"favIconUrl"`
 	SenderBrowserID   string `json:"senderBrowserId"`
 	SenderBrowserName string `json:"senderBrowserName"`
 	SentAt            string `json:"sentAt"`
+
+	// Set when restoring a workspace: tabs with the same window number
+	// belong in one window (numbered from 1)
+	Pinned bool `json:"pinned,omitempty"`
+	Window int  `json:"window,omitempty"`
+
+	// Set on the single entry the "collapse" overflow policy folds extra
+	// tabs into; it is delivered as one entry, opened as one window
+	Bundle []PendingTab `json:"bundle,omitempty"`
+
+	// Held until the target browser accepts it (see incoming.go)
+	AwaitingConfirm bool `json:"awaitingConfirm,omitempty"`
+
+	// Set while the target's quiet hours hold the entry back (see quiet.go)
+	HeldUntil string `json:"heldUntil,omitempty"`
+}
+
+// bundleTitle is the title of a collapsed entry holding n tabs.
+func bundleTitle(n int) string {
+	if n == 1 {
+		return "1 tab waiting"
+	}
+	return fmt.Sprintf("%d tabs waiting", n)
+}
+
+// flattenPending expands collapsed entries into their tabs.
+func flattenPending(tabs []PendingTab) []PendingTab {
+	out := make([]PendingTab, 0, len(tabs))
+	for _, t := range tabs {
+		if t.Bundle != nil {
+			out = append(out, t.Bundle...)
+		} else {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// EnqueueResult describes how a tab was queued.
+type EnqueueResult struct {
+	Limit     int    `json:"limit"`
+	Policy    string `json:"policy,omitempty"`    // overflow policy applied, if the queue was full
+	Duplicate bool   `json:"duplicate,omitempty"` // URL was already queued; nothing added
+	Dropped   int    `json:"dropped,omitempty"`   // oldest tabs removed to make room
+	Bundled   int    `json:"bundled,omitempty"`   // tabs now in the collapsed entry
 }
 
 // PendingStore holds pending tabs backed by pending-tabs.json
 type PendingStore struct {
 	mu     sync.RWMutex
+	saveMu sync.Mutex              // held across snapshot and write so saves land in order
 	data   map[string][]PendingTab // targetBrowserId -> []PendingTab
 	folder string
 	saveCh chan struct{}
@@ -56,42 +113,37 @@ func (p *PendingStore) Load() error {
 		return err
 	}
 
-	data, err := os.ReadFile(p.pendingPath())
+	raw, recovered, err := loadEntries(p.pendingPath(), schema.Pending)
 	if os.IsNotExist(err) {
 		return nil
 	}
 	if err != nil {
 		return err
 	}
-
-	raw := make(map[string][]PendingTab)
-	if err := json.Unmarshal(data, &raw); err != nil {
-		logger.Warn("pending-tabs.json corrupt, starting fresh: %v", err)
-		return nil
+	if recovered {
+		p.DebouncedSave()
 	}
 
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	cutoff := time.Now().AddDate(0, 0, -config.StaleDays)
+	cutoff := config.RetentionCutoff()
 
-	for id, tabs := range raw {
+	for id, rawTabs := range raw {
 		if id == "" || id == "null" || id == "undefined" {
 			continue
 		}
+		var tabs []PendingTab
+		if err := json.Unmarshal(rawTabs, &tabs); err != nil {
+			logger.Warn("Skipping unreadable pending queue for %s: %v", id, err)
+			continue
+		}
 		// Filter stale tabs
-		fresh := tabs[:0]
-		for _, tab := range tabs {
-			if tab.SentAt != "" {
-				if t, err := time.Parse(time.RFC3339, tab.SentAt); err == nil {
-					if t.Before(cutoff) {
-						continue
-					}
-				}
+		if fresh := freshPending(tabs, cutoff); len(fresh) > 0 {
+			// Queues saved by older versions have no item IDs
+			if assignPendingIDs(fresh) {
+				p.DebouncedSave()
 			}
-			fresh = append(fresh, tab)
-		}
-		if len(fresh) > 0 {
 			p.data[id] = fresh
 		}
 	}
@@ -99,8 +151,78 @@ func (p *PendingStore) Load() error {
 	return nil
 }
 
+// assignPendingIDs gives every queued tab without one an ID.
+// Returns whether any were assigned.
+func assignPendingIDs(tabs []PendingTab) bool {
+	assigned := false
+	for i := range tabs {
+		if tabs[i].ID == "" {
+			tabs[i].ID = newID()
+			assigned = true
+		}
+		if assignPendingIDs(tabs[i].Bundle) {
+			assigned = true
+		}
+	}
+	return assigned
+}
+
+// freshPending filters out tabs sent before cutoff (in place). Tabs in a
+// collapsed entry expire by their own send time.
+func freshPending(tabs []PendingTab, cutoff time.Time) []PendingTab {
+	fresh, _ := prunePending(tabs, func(tab PendingTab) bool {
+		t, err := time.Parse(time.RFC3339, tab.SentAt)
+		return err == nil && t.Before(cutoff)
+	})
+	return fresh
+}
+
+// prunePending removes the tabs drop reports true for, including tabs in
+// collapsed entries; an entry left with no tabs goes too. The queue is
+// filtered in place, bundles are copied. Returns the kept entries and how
+// many tabs were removed.
+func prunePending(tabs []PendingTab, drop func(PendingTab) bool) ([]PendingTab, int) {
+	kept := tabs[:0]
+	removed := 0
+	for _, t := range tabs {
+		if t.Bundle == nil {
+			if drop(t) {
+				removed++
+			} else {
+				kept = append(kept, t)
+			}
+			continue
+		}
+		inner := make([]PendingTab, 0, len(t.Bundle))
+		for _, b := range t.Bundle {
+			if drop(b) {
+				removed++
+			} else {
+				inner = append(inner, b)
+			}
+		}
+		if len(inner) == 0 {
+			continue
+		}
+		t.Bundle = inner
+		t.Title = bundleTitle(len(inner))
+		t.SenderBrowserID, t.SenderBrowserName = inner[0].SenderBrowserID, inner[0].SenderBrowserName
+		for _, b := range inner[1:] {
+			if b.SenderBrowserID != t.SenderBrowserID {
+				t.SenderBrowserID, t.SenderBrowserName = "", "Several browsers"
+				break
+			}
+		}
+		kept = append(kept, t)
+	}
+	return kept, removed
+}
+
 // Save writes pending-tabs.json atomically.
 func (p *PendingStore) Save() error {
+	p.saveMu.Lock()
+	defer p.saveMu.Unlock()
+
 	p.mu.RLock()
 	snapshot := make(map[string][]PendingTab, len(p.data))
 	for k, v := range p.data {
@@ -110,20 +232,7 @@ func (p *PendingStore) Save() error {
 	}
 	p.mu.RUnlock()
 
-	data, err := json.MarshalIndent(snapshot, "", "  ")
-	if err != nil {
-		return err
-	}
-
-	if err := os.MkdirAll(p.folder, 0755); err != nil {
-		return err
-	}
-
-	tmp := p.pendingPath() + ".tmp"
-	if err := os.WriteFile(tmp, data, 0644); err != nil {
-		return err
-	}
-	return os.Rename(tmp, p.pendingPath())
+	return writeDataFile(p.pendingPath(), schema.Pending, snapshot, true)
 }
 
 // DebouncedSave triggers a save after 500ms.
@@ -151,22 +260,100 @@ func (p *PendingStore) startSaveWorker() {
 	}
 }
 
-// Enqueue adds a pending tab for a target browser.
-// Returns error if queue is full (MaxPendingPerBrowser).
-func (p *PendingStore) Enqueue(targetBrowserId string, tab PendingTab) error {
+// Enqueue adds a pending tab for a target browser. A URL already queued
+// for the target is not added again. When the queue is at the target's
+// limit (config pendingQueue) its overflow policy decides: "reject"
+// returns ErrQueueFull, "drop-oldest" drops the oldest entry not awaiting
+// confirmation and "collapse" folds the tab into a single "N tabs
+// waiting" entry.
+func (p *PendingStore) Enqueue(targetBrowserId string, tab PendingTab) (EnqueueResult, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	res, err := p.enqueueLocked(targetBrowserId, tab)
+	if err == nil && !res.Duplicate {
+		p.DebouncedSave()
+	}
+	return res, err
+}
+
+// enqueueLocked implements Enqueue. Caller holds mu and saves.
+func (p *PendingStore) enqueueLocked(targetBrowserId string, tab PendingTab) (EnqueueResult, error) {
+	limit, overflow := config.Get().PendingQueue.For(targetBrowserId)
+	if limit < 1 {
+		limit = config.DefaultPendingLimit
+	}
+	res := EnqueueResult{Limit: limit}
+	if tab.ID == "" {
+		tab.ID = newID()
+	}
 
 	queue := p.data[targetBrowserId]
-	if len(queue) >= MaxPendingPerBrowser {
-		return fmt.Errorf("pending queue full for browser %s", targetBrowserId)
+	for _, q := range flattenPending(queue) {
+		if q.URL == tab.URL {
+			res.Duplicate = true
+			return res, nil
+		}
 	}
-	p.data[targetBrowserId] = append(queue, tab)
-	p.DebouncedSave()
-	return nil
+
+	if len(queue) < limit {
+		p.data[targetBrowserId] = append(queue, tab)
+		return res, nil
+	}
+
+	res.Policy = overflow
+	switch overflow {
+	case config.OverflowDropOldest:
+		// Tabs awaiting confirmation are never dropped: neither the target
+		// nor their sender would learn of it
+		need := len(queue) - limit + 1
+		kept := make([]PendingTab, 0, len(queue))
+		for _, q := range queue {
+			if res.Dropped < need && !q.AwaitingConfirm {
+				res.Dropped++
+				continue
+			}
+			kept = append(kept, q)
+		}
+		if res.Dropped < need {
+			res.Dropped = 0
+			return res, fmt.Errorf("%w for browser %s", ErrQueueFull, targetBrowserId)
+		}
+		queue = append(kept, tab)
+	case config.OverflowCollapse:
+		// The last entry becomes (or already is) the bundle; tabs awaiting
+		// confirmation are never folded together with others
+		last := &queue[len(queue)-1]
+		if tab.AwaitingConfirm || last.AwaitingConfirm {
+			return res, fmt.Errorf("%w for browser %s", ErrQueueFull, targetBrowserId)
+		}
+		if last.Bundle == nil {
+			*last = PendingTab{
+				ID:                newID(),
+				SenderBrowserID:   tab.SenderBrowserID,
+				SenderBrowserName: tab.SenderBrowserName,
+				Bundle:            []PendingTab{*last},
+			}
+		}
+		if len(last.Bundle) >= MaxPendingBundle {
+			return res, fmt.Errorf("%w for browser %s", ErrQueueFull, targetBrowserId)
+		}
+		last.Bundle = append(last.Bundle, tab)
+		if last.SenderBrowserID != tab.SenderBrowserID {
+			last.SenderBrowserID, last.SenderBrowserName = "", "Several browsers"
+		}
+		last.Title = bundleTitle(len(last.Bundle))
+		last.SentAt = tab.SentAt
+		res.Bundled = len(last.Bundle)
+	default:
+		return res, fmt.Errorf("%w for browser %s", ErrQueueFull, targetBrowserId)
+	}
+	p.data[targetBrowserId] = queue
+	return res, nil
 }
 
-// Deliver pops and returns all pending tabs for a browser.
+// Deliver pops and returns all pending tabs for a browser. A collapsed
+// entry stays one entry, so the browser can open it as a group. Tabs
+// awaiting confirmation stay queued.
 func (p *PendingStore) Deliver(targetBrowserId string) []PendingTab {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -175,29 +362,459 @@ func (p *PendingStore) Deliver(targetBrowserId string) []PendingTab {
 	if !ok || len(tabs) == 0 {
 		return nil
 	}
-	delete(p.data, targetBrowserId)
+	var ready, held []PendingTab
+	for _, t := range tabs {
+		if t.AwaitingConfirm {
+			held = append(held, t)
+		} else {
+			ready = append(ready, t)
+		}
+	}
+	if len(ready) == 0 {
+		return nil
+	}
+	for i := range ready {
+		ready[i].HeldUntil = ""
+	}
+	p.setQueueLocked(targetBrowserId, held)
 	p.DebouncedSave()
-	return tabs
+	return ready
 }
 
-// UpdateDataFolder moves the store to a new folder path.
-func (p *PendingStore) UpdateDataFolder(newFolder string) error {
+// Awaiting returns the tabs queued for a browser that wait for it to
+// confirm them.
+func (p *PendingStore) Awaiting(targetBrowserId string) []PendingTab {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var out []PendingTab
+	for _, t := range p.data[targetBrowserId] {
+		if t.AwaitingConfirm {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// Hold marks every entry queued for a browser as held by its quiet hours
+// until until, and saves. The mark is what the quiet worker releases, so
+// it survives a restart. Returns the number of entries.
+func (p *PendingStore) Hold(targetBrowserId string, until time.Time) int {
 	p.mu.Lock()
-	oldFolder := p.folder
-	p.folder = newFolder
+	defer p.mu.Unlock()
+	queue := p.data[targetBrowserId]
+	stamp := until.Format(time.RFC3339)
+	for i := range queue {
+		queue[i].HeldUntil = stamp
+	}
+	if len(queue) > 0 {
+		p.DebouncedSave()
+	}
+	return len(queue)
+}
+
+// HeldTargets returns the browsers with entries held by quiet hours.
+func (p *PendingStore) HeldTargets() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var out []string
+	for id, queue := range p.data {
+		for _, t := range queue {
+			if t.HeldUntil != "" {
+				out = append(out, id)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// Unhold clears the quiet-hours mark of a browser's entries.
+func (p *PendingStore) Unhold(targetBrowserId string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	queue := p.data[targetBrowserId]
+	for i := range queue {
+		queue[i].HeldUntil = ""
+	}
+	if len(queue) > 0 {
+		p.DebouncedSave()
+	}
+}
+
+// Count returns the number of entries queued for a browser.
+func (p *PendingStore) Count(targetBrowserId string) int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.data[targetBrowserId])
+}
+
+// TakeAwaiting removes a tab awaiting confirmation from a browser's queue
+// and saves immediately.
+func (p *PendingStore) TakeAwaiting(targetBrowserId, itemId string) (PendingTab, error) {
+	p.mu.Lock()
+	found := false
+	for _, t := range p.data[targetBrowserId] {
+		if t.ID == itemId && t.AwaitingConfirm {
+			found = true
+			break
+		}
+	}
+	var tab PendingTab
+	if found {
+		tab, _ = p.removeLocked(targetBrowserId, itemId)
+	}
+	p.mu.Unlock()
+	if !found {
+		return PendingTab{}, ErrUnknownPending
+	}
+	return tab, p.Save()
+}
+
+// ExpireStale drops tabs sent before cutoff, except for targets in keep.
+// Returns the number of tabs removed.
+func (p *PendingStore) ExpireStale(cutoff time.Time, keep map[string]bool) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	removed := 0
+	for id, tabs := range p.data {
+		if keep[id] {
+			continue
+		}
+		fresh, n := prunePending(tabs, func(tab PendingTab) bool {
+			t, err := time.Parse(time.RFC3339, tab.SentAt)
+			return err == nil && t.Before(cutoff)
+		})
+		removed += n
+		if len(fresh) == 0 {
+			delete(p.data, id)
+		} else {
+			p.data[id] = fresh
+		}
+	}
+	if removed > 0 {
+		p.DebouncedSave()
+	}
+	return removed
+}
+
+// Forget drops every pending tab queued for or sent by a browser, including
+// tabs it sent that were folded into collapsed entries.
+// Returns the number of tabs removed.
+func (p *PendingStore) Forget(browserId string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	removed := len(flattenPending(p.data[browserId]))
+	delete(p.data, browserId)
+	for id, tabs := range p.data {
+		kept, n := prunePending(tabs, func(tab PendingTab) bool {
+			return tab.SenderBrowserID == browserId
+		})
+		removed += n
+		if len(kept) == 0 {
+			delete(p.data, id)
+		} else {
+			p.data[id] = kept
+		}
+	}
+	if removed > 0 {
+		p.DebouncedSave()
+	}
+	return removed
+}
+
+// MoveQueue re-targets every tab queued for fromId to toId.
+// Returns the number of tabs moved.
+func (p *PendingStore) MoveQueue(fromId, toId string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	tabs := p.data[fromId]
+	if len(tabs) == 0 || fromId == toId {
+		return 0
+	}
+	delete(p.data, fromId)
+	p.data[toId] = append(p.data[toId], tabs...)
+	p.DebouncedSave()
+	return len(tabs)
+}
+
+// All returns a copy of every queue, keyed by target browser ID.
+func (p *PendingStore) All() map[string][]PendingTab {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	out := make(map[string][]PendingTab, len(p.data))
+	for id, tabs := range p.data {
+		cp := make([]PendingTab, len(tabs))
+		for i, t := range tabs {
+			cp[i] = t
+			cp[i].Bundle = append([]PendingTab(nil), t.Bundle...)
+		}
+		out[id] = cp
+	}
+	return out
+}
+
+// removeLocked takes the item itemId (a queue entry, or a tab inside a
+// collapsed entry) out of a target's queue. Caller holds mu and saves.
+func (p *PendingStore) removeLocked(targetBrowserId, itemId string) (PendingTab, bool) {
+	queue := p.data[targetBrowserId]
+	for i, t := range queue {
+		if t.ID == itemId {
+			p.setQueueLocked(targetBrowserId, append(queue[:i:i], queue[i+1:]...))
+			return t, true
+		}
+		for j, inner := range t.Bundle {
+			if inner.ID != itemId {
+				continue
+			}
+			bundle := append(t.Bundle[:j:j], t.Bundle[j+1:]...)
+			if len(bundle) == 0 {
+				p.setQueueLocked(targetBrowserId, append(queue[:i:i], queue[i+1:]...))
+			} else {
+				queue[i].Bundle, queue[i].Title = bundle, bundleTitle(len(bundle))
+			}
+			return inner, true
+		}
+	}
+	return PendingTab{}, false
+}
+
+// setQueueLocked replaces a target's queue, dropping it when empty.
+func (p *PendingStore) setQueueLocked(targetBrowserId string, queue []PendingTab) {
+	if len(queue) == 0 {
+		delete(p.data, targetBrowserId)
+	} else {
+		p.data[targetBrowserId] = queue
+	}
+}
+
+// Remove deletes one queued item and saves immediately.
+func (p *PendingStore) Remove(targetBrowserId, itemId string) (PendingTab, error) {
+	p.mu.Lock()
+	tab, ok := p.removeLocked(targetBrowserId, itemId)
 	p.mu.Unlock()
+	if !ok {
+		return PendingTab{}, ErrUnknownPending
+	}
+	return tab, p.Save()
+}
 
-	if err := os.MkdirAll(newFolder, 0755); err != nil {
-		return err
+// Clear deletes a target's whole queue and saves immediately.
+// Returns the number of entries removed.
+func (p *PendingStore) Clear(targetBrowserId string) (int, error) {
+	p.mu.Lock()
+	n := len(p.data[targetBrowserId])
+	delete(p.data, targetBrowserId)
+	p.mu.Unlock()
+	if n == 0 {
+		return 0, nil
 	}
+	return n, p.Save()
+}
 
-	oldPath := filepath.Join(oldFolder, "pending-tabs.json")
-	newPath := filepath.Join(newFolder, "pending-tabs.json")
-	if _, err := os.Stat(oldPath); err == nil {
-		if err := os.Rename(oldPath, newPath); err != nil {
-			logger.Warn("Could not move pending-tabs.json: %v", err)
+// Get returns one queued item (a queue entry, or a tab inside a collapsed
+// entry) without removing it.
+func (p *PendingStore) Get(targetBrowserId, itemId string) (PendingTab, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, t := range p.data[targetBrowserId] {
+		if t.ID == itemId {
+			t.Bundle = append([]PendingTab(nil), t.Bundle...)
+			return t, true
+		}
+		for _, inner := range t.Bundle {
+			if inner.ID == itemId {
+				return inner, true
+			}
 		}
 	}
+	return PendingTab{}, false
+}
+
+// Move re-targets one queued item to another browser's queue, subject to
+// that queue's limit and overflow policy, and saves immediately. A
+// collapsed entry moves as its individual tabs; those whose IDs are in
+// confirm wait for the new target to accept them. If the new queue
+// refuses anything, nothing moves.
+func (p *PendingStore) Move(fromId, itemId, toId string, confirm map[string]bool) (EnqueueResult, error) {
+	p.mu.Lock()
+	before := p.snapshotLocked(fromId, toId)
+	tab, ok := p.removeLocked(fromId, itemId)
+	if !ok {
+		p.mu.Unlock()
+		return EnqueueResult{}, ErrUnknownPending
+	}
+	var res EnqueueResult
+	for _, t := range flattenPending([]PendingTab{tab}) {
+		t.AwaitingConfirm = confirm[t.ID]
+		r, err := p.enqueueLocked(toId, t)
+		if err != nil {
+			for id, queue := range before {
+				p.setQueueLocked(id, queue)
+			}
+			p.mu.Unlock()
+			return r, err
+		}
+		res.Limit, res.Duplicate = r.Limit, r.Duplicate
+		if r.Policy != "" {
+			res.Policy = r.Policy
+		}
+		res.Dropped += r.Dropped
+		if r.Bundled > 0 {
+			res.Bundled = r.Bundled
+		}
+	}
+	p.mu.Unlock()
+	return res, p.Save()
+}
+
+// snapshotLocked copies the given queues for rollback. Caller holds mu.
+func (p *PendingStore) snapshotLocked(ids ...string) map[string][]PendingTab {
+	out := make(map[string][]PendingTab, len(ids))
+	for _, id := range ids {
+		cp := make([]PendingTab, len(p.data[id]))
+		for i, t := range p.data[id] {
+			cp[i] = t
+			cp[i].Bundle = append([]PendingTab(nil), t.Bundle...)
+		}
+		out[id] = cp
+	}
+	return out
+}
+
+// EnqueueBatch queues tabs for a browser in one step. Tabs of the batch
+// that the overflow policy evicts again to make room for later ones count
+// as rejected rather than queued; dropped counts only tabs queued before.
+func (p *PendingStore) EnqueueBatch(targetBrowserId string, tabs []PendingTab) (queued, rejected, dropped int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
+	before := make(map[string]bool)
+	for _, t := range flattenPending(p.data[targetBrowserId]) {
+		before[t.ID] = true
+	}
+	mine := make(map[string]bool, len(tabs))
+	for _, t := range tabs {
+		if t.ID == "" {
+			t.ID = newID()
+		}
+		res, err := p.enqueueLocked(targetBrowserId, t)
+		if err != nil {
+			rejected++
+			continue
+		}
+		if !res.Duplicate {
+			mine[t.ID] = true
+		}
+	}
+	for _, t := range flattenPending(p.data[targetBrowserId]) {
+		if mine[t.ID] {
+			queued++
+		}
+		delete(before, t.ID)
+	}
+	rejected += len(mine) - queued
+	dropped = len(before)
+	if len(mine) > 0 {
+		p.DebouncedSave()
+	}
+	return queued, rejected, dropped
+}
+
+// Requeue puts tabs back at the front of a target's queue (e.g. after a
+// failed delivery), bypassing its limit, and saves immediately.
+func (p *PendingStore) Requeue(targetBrowserId string, tabs []PendingTab) error {
+	if len(tabs) == 0 {
+		return nil
+	}
+	p.mu.Lock()
+	p.data[targetBrowserId] = append(append([]PendingTab(nil), tabs...), p.data[targetBrowserId]...)
+	p.mu.Unlock()
+	return p.Save()
+}
+
+// UpdateDataFolder points the store at a new folder and saves to it.
+// Existing files are copied beforehand by Server.MoveDataFolder.
+func (p *PendingStore) UpdateDataFolder(newFolder string) error {
+	p.mu.Lock()
+	p.folder = newFolder
+	p.mu.Unlock()
 	return p.Save()
 }
+
+// DeliverResult is the outcome of DeliverTabs.
+type DeliverResult struct {
+	Status    string `json:"status"`              // "delivered" or "queued"
+	Delivered int    `json:"delivered,omitempty"` // sent straight to the connected browser
+	Queued    int    `json:"queued,omitempty"`
+	Awaiting  int    `json:"awaiting,omitempty"` // queued until the browser confirms them
+	Refused   int    `json:"refused,omitempty"`  // refused by the browser's incoming rules
+	Rejected  int    `json:"rejected,omitempty"` // not queued: the queue was full
+	Dropped   int    `json:"dropped,omitempty"`  // older queued tabs evicted to make room
+}
+
+// DeliverTabs sends tabs to a browser through its incoming rules, as
+// sent by their SenderBrowserID: refused tabs are dropped, tabs needing
+// confirmation wait in its queue and the rest go straight to it if it is
+// connected and outside its quiet hours, otherwise queued as pending
+// (status "held" in quiet hours). The browser opens tabs with a
+// Window number together in a new window, and pins Pinned ones.
+func (s *Server) DeliverTabs(targetBrowserId string, tabs []PendingTab) DeliverResult {
+	var res DeliverResult
+	var ready, awaiting []PendingTab
+	for _, t := range tabs {
+		confirm, err := checkIncoming(targetBrowserId, t.SenderBrowserID, t.URL)
+		switch {
+		case err != nil:
+			res.Refused++
+		case confirm:
+			t.AwaitingConfirm = true
+			awaiting = append(awaiting, t)
+		default:
+			ready = append(ready, t)
+		}
+	}
+
+	// During quiet hours everything waits in the queue, held
+	until, quiet := quietUntil(targetBrowserId, time.Now())
+	target, online := s.reg.get(targetBrowserId)
+	online = online && !quiet
+	if len(ready) > 0 {
+		if online {
+			_ = target.sendJSON(map[string]interface{}{
+				"type": "pending-tabs",
+				"tabs": ready,
+			})
+			res.Status = "delivered"
+			res.Delivered = len(flattenPending(ready))
+		} else {
+			res.Status = "queued"
+			res.Queued, res.Rejected, res.Dropped = s.pending.EnqueueBatch(targetBrowserId, ready)
+		}
+	}
+	if len(awaiting) > 0 {
+		queued, rejected, dropped := s.pending.EnqueueBatch(targetBrowserId, awaiting)
+		res.Awaiting, res.Rejected, res.Dropped = queued, res.Rejected+rejected, res.Dropped+dropped
+		if online && queued > 0 {
+			sendConfirmRequest(target, s.pending.Awaiting(targetBrowserId))
+		}
+		if res.Status == "" {
+			res.Status = StatusAwaitingConfirm
+		}
+	}
+	if res.Status == "" {
+		res.Status = "refused"
+	} else if quiet {
+		s.pending.Hold(targetBrowserId, until)
+		res.Status = StatusHeld
+	}
+	if res.Rejected > 0 || res.Dropped > 0 {
+		logger.Warn("[Pending] Queue for %s full: %d tab(s) rejected, %d older tab(s) dropped", targetBrowserId, res.Rejected, res.Dropped)
+	}
+	if res.Refused > 0 {
+		logger.Info("[Pending] Incoming rules of %s refused %d tab(s)", targetBrowserId, res.Refused)
+	}
+	return res
+}
@@ -0,0 +1,296 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/harshvasudeva/synctabs-companion/config"
+	"github.com/harshvasudeva/synctabs-companion/logger"
+)
+
+// PendingItem is a queued tab as listed by GET /pending.
+type PendingItem struct {
+	PendingTab
+	AgeSeconds int64 `json:"ageSeconds"`
+}
+
+// PendingQueueInfo describes one target browser's queue.
+type PendingQueueInfo struct {
+	TargetBrowserID string        `json:"targetBrowserId"`
+	BrowserName     string        `json:"browserName"`
+	Online          bool          `json:"online"`
+	Limit           int           `json:"limit"`
+	Overflow        string        `json:"overflow"`
+	Count           int           `json:"count"` // tabs, with collapsed entries expanded
+	Items           []PendingItem `json:"items"`
+}
+
+// sentBy reports whether a queued entry (or any tab in it) came from senderId.
+func (t PendingTab) sentBy(senderId string) bool {
+	if t.SenderBrowserID == senderId {
+		return true
+	}
+	for _, inner := range t.Bundle {
+		if inner.SenderBrowserID == senderId {
+			return true
+		}
+	}
+	return false
+}
+
+// PendingQueues lists queued tabs per target, oldest first, optionally
+// limited to one target and/or one sender.
+func (s *Server) PendingQueues(targetId, senderId string) []PendingQueueInfo {
+	now := time.Now()
+	q := config.Get().PendingQueue
+	var out []PendingQueueInfo
+	for target, tabs := range s.pending.All() {
+		if targetId != "" && target != targetId {
+			continue
+		}
+		info := PendingQueueInfo{TargetBrowserID: target, Items: []PendingItem{}}
+		if data, ok := s.state.Get(target); ok {
+			info.BrowserName = s.aliases.DisplayName(target, data.BrowserName)
+		}
+		_, info.Online = s.reg.get(target)
+		info.Limit, info.Overflow = q.For(target)
+		for _, t := range tabs {
+			if senderId != "" && !t.sentBy(senderId) {
+				continue
+			}
+			item := PendingItem{PendingTab: t}
+			if sent, err := time.Parse(time.RFC3339, t.SentAt); err == nil {
+				item.AgeSeconds = int64(now.Sub(sent).Seconds())
+			}
+			info.Items = append(info.Items, item)
+			info.Count += len(flattenPending([]PendingTab{t}))
+		}
+		if len(info.Items) > 0 {
+			out = append(out, info)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].TargetBrowserID < out[j].TargetBrowserID })
+	return out
+}
+
+// RetargetPending moves a queued item to another browser, checked
+// against that browser's sharing, incoming rules and quiet hours like a
+// fresh send: delivered at once if it is connected and nothing needs
+// confirming, otherwise queued, held or awaiting confirmation there.
+func (s *Server) RetargetPending(fromId, itemId, toId string) (string, EnqueueResult, error) {
+	if toId == "" || toId == fromId {
+		return "", EnqueueResult{}, errors.New("a different target browser is required")
+	}
+	if _, ok := s.state.Get(toId); !ok {
+		return "", EnqueueResult{}, ErrUnknownBrowser
+	}
+	item, ok := s.pending.Get(fromId, itemId)
+	if !ok {
+		return "", EnqueueResult{}, ErrUnknownPending
+	}
+
+	// The new target's sharing and incoming rules apply as if each tab
+	// had been sent to it directly
+	confirm := make(map[string]bool)
+	for _, t := range flattenPending([]PendingTab{item}) {
+		if err := s.state.checkSend(t.SenderBrowserID, toId); err != nil {
+			return "", EnqueueResult{}, err
+		}
+		c, err := checkIncoming(toId, t.SenderBrowserID, t.URL)
+		if err != nil {
+			return "", EnqueueResult{}, err
+		}
+		if c {
+			confirm[t.ID] = true
+		}
+	}
+
+	until, quiet := quietUntil(toId, time.Now())
+	conn, online := s.reg.get(toId)
+	if online && !quiet && len(confirm) == 0 {
+		tab, err := s.pending.Remove(fromId, itemId)
+		if err != nil {
+			return "", EnqueueResult{}, err
+		}
+		tab.AwaitingConfirm = false
+		if err := conn.sendJSON(map[string]interface{}{
+			"type": "pending-tabs",
+			"tabs": []PendingTab{tab},
+		}); err != nil {
+			if rerr := s.pending.Requeue(fromId, []PendingTab{tab}); rerr != nil {
+				logger.Error("Pending save failed: %v", rerr)
+			}
+			return "", EnqueueResult{}, err
+		}
+		logger.Info("[Pending] Re-targeted %s: %s → %s (delivered)", itemId, fromId, toId)
+		return "delivered", EnqueueResult{}, nil
+	}
+
+	res, err := s.pending.Move(fromId, itemId, toId, confirm)
+	if err != nil {
+		return "", res, err
+	}
+	status := "queued"
+	switch {
+	case quiet:
+		s.pending.Hold(toId, until)
+		status = StatusHeld
+	case online:
+		// Mixed: deliver what needs no confirmation, ask about the rest
+		if _, err := s.FlushPending(toId); err != nil {
+			logger.Warn("[Pending] Could not deliver to %s: %v", toId, err)
+		}
+		sendConfirmRequest(conn, s.pending.Awaiting(toId))
+		status = StatusAwaitingConfirm
+	case len(confirm) > 0:
+		status = StatusAwaitingConfirm
+	}
+	logger.Info("[Pending] Re-targeted %s: %s → %s (%s)", itemId, fromId, toId, status)
+	return status, res, nil
+}
+
+// FlushPending delivers a target's whole queue now. The target must be
+// connected and outside its quiet hours; if sending fails the tabs go
+// back in the queue.
+func (s *Server) FlushPending(targetId string) (int, error) {
+	conn, ok := s.reg.get(targetId)
+	if !ok {
+		return 0, ErrBrowserOffline
+	}
+	if until, quiet := s.holdIfQuiet(targetId); quiet {
+		return 0, quietError(until)
+	}
+	tabs := s.pending.Deliver(targetId)
+	if len(tabs) == 0 {
+		return 0, nil
+	}
+	if err := conn.sendJSON(map[string]interface{}{
+		"type": "pending-tabs",
+		"tabs": tabs,
+	}); err != nil {
+		if rerr := s.pending.Requeue(targetId, tabs); rerr != nil {
+			logger.Error("Pending save failed: %v", rerr)
+		}
+		return 0, err
+	}
+	n := len(flattenPending(tabs))
+	if err := s.pending.Save(); err != nil {
+		return n, err
+	}
+	logger.Info("[Pending] Flushed %d tab(s) → %s", n, targetId)
+	return n, nil
+}
+
+// pendingError maps pending queue errors to HTTP status codes.
+func pendingError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrUnknownPending), errors.Is(err, ErrUnknownBrowser):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrQueueFull), errors.Is(err, ErrBrowserOffline), errors.Is(err, ErrQuietHours):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// handlePending responds to GET /pending[?target=id][&sender=id].
+func (s *Server) handlePending(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	queues := s.PendingQueues(q.Get("target"), q.Get("sender"))
+	if queues == nil {
+		queues = []PendingQueueInfo{}
+	}
+	writeJSON(w, queues)
+}
+
+// handlePendingTarget serves /pending/{target}[/...]:
+//
+//	GET    /pending/{target}
+//	DELETE /pending/{target}                  — clear the queue
+//	POST   /pending/{target}/flush            — deliver now (target must be online)
+//	DELETE /pending/{target}/{id}
+//	POST   /pending/{target}/{id}/retarget    {"targetBrowserId"}
+//	POST   /pending/{target}/{id}/confirm     {"accept"} — for tabs held by incoming rules
+func (s *Server) handlePendingTarget(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/pending/"), "/"), "/")
+	target := parts[0]
+	sub := ""
+	if len(parts) > 1 {
+		sub = parts[1]
+	}
+	action := ""
+	if len(parts) > 2 {
+		action = parts[2]
+	}
+
+	switch {
+	case sub == "" && r.Method == http.MethodGet:
+		queue := PendingQueueInfo{TargetBrowserID: target, Items: []PendingItem{}}
+		if queues := s.PendingQueues(target, ""); len(queues) > 0 {
+			queue = queues[0]
+		}
+		writeJSON(w, queue)
+
+	case sub == "" && r.Method == http.MethodDelete:
+		n, err := s.pending.Clear(target)
+		if err != nil {
+			pendingError(w, err)
+			return
+		}
+		logger.Info("[Pending] Cleared %d item(s) queued for %s", n, target)
+		writeJSON(w, map[string]interface{}{"ok": true, "removed": n})
+
+	case sub == "flush" && action == "" && r.Method == http.MethodPost:
+		n, err := s.FlushPending(target)
+		if err != nil {
+			pendingError(w, err)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"ok": true, "delivered": n})
+
+	case action == "" && r.Method == http.MethodDelete:
+		tab, err := s.pending.Remove(target, sub)
+		if err != nil {
+			pendingError(w, err)
+			return
+		}
+		logger.Info("[Pending] Removed %s queued for %s: %s", sub, target, LogURL(tab.URL))
+		writeJSON(w, map[string]interface{}{"ok": true, "removed": tab})
+
+	case action == "retarget" && r.Method == http.MethodPost:
+		var req struct {
+			TargetBrowserID string `json:"targetBrowserId"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		status, res, err := s.RetargetPending(target, sub, req.TargetBrowserID)
+		if err != nil {
+			var rejected *RejectedError
+			if errors.Is(err, ErrUnknownBrowser) || errors.Is(err, ErrUnknownPending) || errors.Is(err, ErrQueueFull) {
+				pendingError(w, err)
+			} else if errors.As(err, &rejected) || errors.Is(err, ErrSendNotAllowed) {
+				http.Error(w, err.Error(), http.StatusForbidden)
+			} else {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+		writeJSON(w, map[string]interface{}{"ok": true, "status": status, "queue": res})
+
+	case action == "confirm" && r.Method == http.MethodPost:
+		s.handlePendingConfirm(w, r, target, sub)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
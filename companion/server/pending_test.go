@@ -0,0 +1,107 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/harshvasudeva/synctabs-companion/config"
+)
+
+// updateConfig applies a partial config for a test. Update validates the
+// port, so one is always given.
+func updateConfig(t *testing.T, partial map[string]interface{}) {
+	t.Helper()
+	partial["port"] = 9234
+	if _, _, err := config.Update(partial); err != nil {
+		t.Fatalf("config.Update: %v", err)
+	}
+}
+
+// queueString describes a queue as "a b! [c d]": "!" marks a tab awaiting
+// confirmation and brackets a collapsed entry.
+func queueString(queue []PendingTab) string {
+	parts := make([]string, 0, len(queue))
+	for _, q := range queue {
+		switch {
+		case q.Bundle != nil:
+			parts = append(parts, "["+queueString(q.Bundle)+"]")
+		case q.AwaitingConfirm:
+			parts = append(parts, q.URL+"!")
+		default:
+			parts = append(parts, q.URL)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// parseQueue is the inverse of queueString for flat queues.
+func parseQueue(s string) []PendingTab {
+	var queue []PendingTab
+	for _, f := range strings.Fields(s) {
+		queue = append(queue, PendingTab{ID: f, URL: strings.TrimSuffix(f, "!"), AwaitingConfirm: strings.HasSuffix(f, "!")})
+	}
+	return queue
+}
+
+func TestEnqueueOverflow(t *testing.T) {
+	updateConfig(t, map[string]interface{}{
+		"pendingQueue": map[string]interface{}{
+			"limit":    2,
+			"overflow": config.OverflowReject,
+			"targets": map[string]interface{}{
+				"drop":     map[string]interface{}{"overflow": config.OverflowDropOldest},
+				"collapse": map[string]interface{}{"overflow": config.OverflowCollapse},
+			},
+		},
+	})
+	bundle := func(urls ...string) PendingTab {
+		b := PendingTab{ID: "bundle", Title: bundleTitle(len(urls))}
+		for _, u := range urls {
+			b.Bundle = append(b.Bundle, PendingTab{ID: u, URL: u})
+		}
+		return b
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		queue  []PendingTab
+		tab    string // "!" suffix: awaiting confirmation
+		want   string
+		full   bool
+		res    EnqueueResult
+	}{
+		{"room left", "reject", parseQueue("a"), "b", "a b", false, EnqueueResult{Limit: 2}},
+		{"duplicate", "reject", parseQueue("a b"), "a", "a b", false, EnqueueResult{Limit: 2, Duplicate: true}},
+		{"duplicate inside a bundle", "collapse", []PendingTab{{ID: "a", URL: "a"}, bundle("b", "c")}, "c", "a [b c]", false, EnqueueResult{Limit: 2, Duplicate: true}},
+		{"reject when full", "reject", parseQueue("a b"), "c", "a b", true, EnqueueResult{Limit: 2, Policy: config.OverflowReject}},
+		{"drop oldest", "drop", parseQueue("a b"), "c", "b c", false, EnqueueResult{Limit: 2, Policy: config.OverflowDropOldest, Dropped: 1}},
+		{"drop skips awaiting", "drop", parseQueue("a! b"), "c", "a! c", false, EnqueueResult{Limit: 2, Policy: config.OverflowDropOldest, Dropped: 1}},
+		{"drop with only awaiting left", "drop", parseQueue("a! b!"), "c", "a! b!", true, EnqueueResult{Limit: 2, Policy: config.OverflowDropOldest}},
+		{"collapse", "collapse", parseQueue("a b"), "c", "a [b c]", false, EnqueueResult{Limit: 2, Policy: config.OverflowCollapse, Bundled: 2}},
+		{"collapse into a bundle", "collapse", []PendingTab{{ID: "a", URL: "a"}, bundle("b", "c")}, "d", "a [b c d]", false, EnqueueResult{Limit: 2, Policy: config.OverflowCollapse, Bundled: 3}},
+		{"collapse keeps awaiting apart", "collapse", parseQueue("a b!"), "c", "a b!", true, EnqueueResult{Limit: 2, Policy: config.OverflowCollapse}},
+		{"collapse refuses awaiting", "collapse", parseQueue("a b"), "c!", "a b", true, EnqueueResult{Limit: 2, Policy: config.OverflowCollapse}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &PendingStore{
+				data:   map[string][]PendingTab{tt.target: tt.queue},
+				saveCh: make(chan struct{}, 1),
+			}
+			tab := parseQueue(tt.tab)[0]
+			tab.ID = ""
+			res, err := p.Enqueue(tt.target, tab)
+			if full := errors.Is(err, ErrQueueFull); full != tt.full || (err != nil && !full) {
+				t.Fatalf("Enqueue error = %v, want full %v", err, tt.full)
+			}
+			if res != tt.res {
+				t.Errorf("Enqueue result = %+v, want %+v", res, tt.res)
+			}
+			if got := queueString(p.data[tt.target]); got != tt.want {
+				t.Errorf("queue = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,291 @@
+package server
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/harshvasudeva/synctabs-companion/config"
+	"github.com/harshvasudeva/synctabs-companion/logger"
+)
+
+// MaskedValue replaces the value of masked query parameters.
+const MaskedValue = "REDACTED"
+
+// privacyEngine is the compiled form of config.PrivacyRules.
+type privacyEngine struct {
+	blockDomains  []string
+	blockPatterns []*regexp.Regexp
+	strip         map[string]bool
+	mask          map[string]bool
+	titles        []compiledTitleRule
+}
+
+type compiledTitleRule struct {
+	domain  string
+	pattern *regexp.Regexp
+	title   string
+}
+
+var (
+	privacyMu  sync.RWMutex
+	privacyCur = &privacyEngine{}
+)
+
+// SetPrivacyRules compiles rules and makes them current. Rules are
+// validated by config, so compile errors only skip the offending entry.
+func SetPrivacyRules(rules config.PrivacyRules) {
+	e := &privacyEngine{
+		strip: make(map[string]bool),
+		mask:  make(map[string]bool),
+	}
+	for _, d := range rules.BlockDomains {
+		if d = normaliseDomain(d); d != "" {
+			e.blockDomains = append(e.blockDomains, d)
+		}
+	}
+	for _, pat := range rules.BlockPatterns {
+		if re, err := regexp.Compile(pat); err == nil {
+			e.blockPatterns = append(e.blockPatterns, re)
+		} else {
+			logger.Warn("Ignoring privacy pattern %q: %v", pat, err)
+		}
+	}
+	for _, p := range rules.StripParams {
+		e.strip[strings.ToLower(p)] = true
+	}
+	for _, p := range rules.MaskParams {
+		e.mask[strings.ToLower(p)] = true
+	}
+	for _, r := range rules.TitleRules {
+		tr := compiledTitleRule{domain: normaliseDomain(r.Domain), title: r.Title}
+		if r.Pattern != "" {
+			re, err := regexp.Compile(r.Pattern)
+			if err != nil {
+				logger.Warn("Ignoring title rule %q: %v", r.Pattern, err)
+				continue
+			}
+			tr.pattern = re
+		}
+		e.titles = append(e.titles, tr)
+	}
+
+	privacyMu.Lock()
+	privacyCur = e
+	privacyMu.Unlock()
+}
+
+// privacy returns the current compiled rules.
+func privacy() *privacyEngine {
+	privacyMu.RLock()
+	defer privacyMu.RUnlock()
+	return privacyCur
+}
+
+func normaliseDomain(d string) string {
+	return strings.TrimPrefix(strings.ToLower(strings.TrimSpace(d)), ".")
+}
+
+// domainMatches reports whether host is domain or one of its subdomains.
+func domainMatches(host, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// Blocked reports whether a URL must never leave its browser.
+func (e *privacyEngine) Blocked(rawURL string) bool {
+	if u, err := url.Parse(rawURL); err == nil {
+		host := strings.ToLower(u.Hostname())
+		for _, d := range e.blockDomains {
+			if domainMatches(host, d) {
+				return true
+			}
+		}
+	}
+	for _, re := range e.blockPatterns {
+		if re.MatchString(rawURL) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactURL strips and masks configured query parameters.
+func (e *privacyEngine) RedactURL(rawURL string) string {
+	if len(e.strip) == 0 && len(e.mask) == 0 {
+		return rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.RawQuery == "" {
+		return rawURL
+	}
+	q := u.Query()
+	changed := false
+	for name := range q {
+		lower := strings.ToLower(name)
+		if e.strip[lower] {
+			q.Del(name)
+			changed = true
+		} else if e.mask[lower] {
+			for i := range q[name] {
+				q[name][i] = MaskedValue
+			}
+			changed = true
+		}
+	}
+	if !changed {
+		return rawURL
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// Title returns the replacement title for a URL, or title unchanged.
+func (e *privacyEngine) Title(rawURL, title string) string {
+	if len(e.titles) == 0 {
+		return title
+	}
+	host := ""
+	if u, err := url.Parse(rawURL); err == nil {
+		host = strings.ToLower(u.Hostname())
+	}
+	for _, r := range e.titles {
+		if r.domain != "" && !domainMatches(host, r.domain) {
+			continue
+		}
+		if r.pattern != nil && !r.pattern.MatchString(rawURL) {
+			continue
+		}
+		return r.title
+	}
+	return title
+}
+
+// ApplyTabs drops blocked tabs and redacts the rest. Matching runs against
+// the original URL so rules can target the parameters they remove.
+// Returns the filtered tabs and whether anything changed.
+func (e *privacyEngine) ApplyTabs(tabs []Tab) ([]Tab, bool) {
+	out := make([]Tab, 0, len(tabs))
+	changed := false
+	for _, t := range tabs {
+		if e.Blocked(t.URL) {
+			changed = true
+			continue
+		}
+		orig, origTitle := t.URL, t.Title
+		t.Title = e.Title(orig, t.Title)
+		t.URL = e.RedactURL(orig)
+		changed = changed || t.URL != orig || t.Title != origTitle
+		out = append(out, t)
+	}
+	return out, changed
+}
+
+// ApplyPending drops blocked pending tabs and redacts the rest, including
+// the tabs of collapsed entries.
+func (e *privacyEngine) ApplyPending(tabs []PendingTab) ([]PendingTab, bool) {
+	out := make([]PendingTab, 0, len(tabs))
+	changed := false
+	for _, t := range tabs {
+		if t.Bundle != nil {
+			inner, ok := e.ApplyPending(t.Bundle)
+			if ok {
+				changed = true
+				if len(inner) == 0 {
+					continue
+				}
+				t.Bundle, t.Title = inner, bundleTitle(len(inner))
+			}
+			out = append(out, t)
+			continue
+		}
+		if e.Blocked(t.URL) {
+			changed = true
+			continue
+		}
+		orig, origTitle := t.URL, t.Title
+		t.Title = e.Title(orig, t.Title)
+		t.URL = e.RedactURL(orig)
+		changed = changed || t.URL != orig || t.Title != origTitle
+		out = append(out, t)
+	}
+	return out, changed
+}
+
+// LogURL returns a URL safe to write to the log: blocked URLs are reduced
+// to a placeholder and configured parameters are redacted.
+func LogURL(rawURL string) string {
+	e := privacy()
+	if e.Blocked(rawURL) {
+		return "[blocked URL]"
+	}
+	return e.RedactURL(rawURL)
+}
+
+// ApplyPrivacy rewrites held tabs under the current rules (e.g. after they
+// change). Returns the IDs of browsers whose tabs changed.
+func (s *StateStore) ApplyPrivacy() []string {
+	e := privacy()
+	s.mu.Lock()
+	var changed []string
+	for id, entry := range s.data {
+		if tabs, ok := e.ApplyTabs(entry.Tabs); ok {
+			entry.Tabs = tabs
+			changed = append(changed, id)
+		}
+	}
+	s.mu.Unlock()
+	if len(changed) > 0 {
+		s.DebouncedSave()
+	}
+	return changed
+}
+
+// ApplyPrivacy rewrites queued tabs under the current rules.
+// Returns true if anything changed.
+func (p *PendingStore) ApplyPrivacy() bool {
+	e := privacy()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	changed := false
+	for id, tabs := range p.data {
+		out, ok := e.ApplyPending(tabs)
+		if !ok {
+			continue
+		}
+		changed = true
+		if len(out) == 0 {
+			delete(p.data, id)
+		} else {
+			p.data[id] = out
+		}
+	}
+	if changed {
+		p.DebouncedSave()
+	}
+	return changed
+}
+
+// ApplyPrivacyRules compiles the configured rules and applies them to
+// everything the companion already holds.
+func (s *Server) ApplyPrivacyRules() {
+	SetPrivacyRules(config.Get().PrivacyRules)
+	for _, id := range s.state.ApplyPrivacy() {
+		if data, ok := s.state.Get(id); ok {
+			broadcastBrowserTabs(s.reg, s.aliases, id, data)
+		}
+	}
+	if s.pending.ApplyPrivacy() {
+		logger.Info("[Privacy] Rewrote pending tabs under new rules")
+	}
+	for _, id := range s.stashes.ApplyPrivacy() {
+		if stash, ok := s.stashes.Get(id); ok {
+			s.broadcastStash(stash)
+		}
+	}
+	for _, id := range s.workspaces.ApplyPrivacy() {
+		if ws, ok := s.workspaces.Get(id); ok {
+			s.broadcastWorkspace(ws)
+		}
+	}
+}
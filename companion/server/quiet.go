@@ -0,0 +1,101 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/harshvasudeva/synctabs-companion/config"
+	"github.com/harshvasudeva/synctabs-companion/logger"
+)
+
+const QuietCheckInterval = 30 * time.Second
+
+// StatusHeld is the send status of a tab held until the target's quiet
+// hours end.
+const StatusHeld = "held"
+
+// ErrQuietHours is returned when tabs cannot be pushed to a browser now
+// because it is in its quiet hours.
+var ErrQuietHours = errors.New("browser is in its quiet hours")
+
+// quietUntil reports whether browserId is in one of its quiet windows at
+// now and, if so, when the window ends.
+func quietUntil(browserId string, now time.Time) (time.Time, bool) {
+	var until time.Time
+	for _, w := range config.Get().QuietHours[browserId] {
+		if end, ok := w.Active(now); ok && end.After(until) {
+			until = end
+		}
+	}
+	return until, !until.IsZero()
+}
+
+// quietError wraps ErrQuietHours with the end of the window.
+func quietError(until time.Time) error {
+	return fmt.Errorf("%w until %s", ErrQuietHours, until.Format("15:04"))
+}
+
+// holdIfQuiet marks a target's queue as held if it is in its quiet hours
+// and reports whether it is. Every push to a browser checks this first.
+func (s *Server) holdIfQuiet(targetBrowserId string) (time.Time, bool) {
+	until, quiet := quietUntil(targetBrowserId, time.Now())
+	if quiet {
+		s.pending.Hold(targetBrowserId, until)
+	}
+	return until, quiet
+}
+
+// holdQuiet queues tab for a target in its quiet hours. Returns false,
+// without queueing, if the target is not in quiet hours.
+func (s *Server) holdQuiet(targetBrowserId string, tab PendingTab) (bool, EnqueueResult, error) {
+	until, quiet := quietUntil(targetBrowserId, time.Now())
+	if !quiet {
+		return false, EnqueueResult{}, nil
+	}
+	res, err := s.pending.Enqueue(targetBrowserId, tab)
+	if err == nil {
+		s.pending.Hold(targetBrowserId, until)
+	}
+	return true, res, err
+}
+
+// startQuietWorker releases held tabs every QuietCheckInterval.
+func (s *Server) startQuietWorker() {
+	ticker := time.NewTicker(QuietCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.ReleaseQuietHolds(time.Now())
+	}
+}
+
+// ReleaseQuietHolds delivers, as one batch per browser, the tabs held for
+// every connected browser whose quiet hours are over. Browsers that are
+// offline get their queue when they next connect.
+func (s *Server) ReleaseQuietHolds(now time.Time) {
+	for _, id := range s.pending.HeldTargets() {
+		if _, quiet := quietUntil(id, now); quiet {
+			continue
+		}
+		s.pending.Unhold(id)
+		conn, ok := s.reg.get(id)
+		if !ok {
+			continue
+		}
+		if tabs := s.pending.Deliver(id); len(tabs) > 0 {
+			if err := conn.sendJSON(map[string]interface{}{
+				"type": "pending-tabs",
+				"tabs": tabs,
+			}); err != nil {
+				if rerr := s.pending.Requeue(id, tabs); rerr != nil {
+					logger.Error("Pending save failed: %v", rerr)
+				}
+				continue
+			}
+			logger.Info("[Quiet] Quiet hours over, delivered %d held tab(s) → %s", len(flattenPending(tabs)), id)
+		}
+		if held := s.pending.Awaiting(id); len(held) > 0 {
+			sendConfirmRequest(conn, held)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/harshvasudeva/synctabs-companion/crypt"
+	"github.com/harshvasudeva/synctabs-companion/logger"
+	"github.com/harshvasudeva/synctabs-companion/schema"
+)
+
+// DataFileBackups is how many previous generations of each data file are
+// kept as <file>.1.bak (newest) … <file>.N.bak.
+const DataFileBackups = 3
+
+// ErrWrongKey is returned when a data file fails authentication and no
+// encrypted backup of it opens either: the files are most likely intact
+// and were written with another key or passphrase.
+var ErrWrongKey = errors.New("wrong encryption key or passphrase")
+
+// RecoveryEvent records a data file that was unreadable at load.
+type RecoveryEvent struct {
+	File         string `json:"file"`
+	Time         string `json:"time"`
+	Reason       string `json:"reason"`
+	Quarantined  string `json:"quarantined,omitempty"`  // where the damaged file was moved
+	RestoredFrom string `json:"restoredFrom,omitempty"` // backup generation used, if any
+	Salvaged     int    `json:"salvaged"`               // entries rescued from the damaged file
+	Recovered    int    `json:"recovered"`              // entries loaded in total
+}
+
+// Summary is a one-line description for notifications.
+func (e RecoveryEvent) Summary() string {
+	msg := fmt.Sprintf("%s was damaged; recovered %d entr", e.File, e.Recovered)
+	if e.Recovered == 1 {
+		msg += "y"
+	} else {
+		msg += "ies"
+	}
+	if e.RestoredFrom != "" {
+		msg += " from " + e.RestoredFrom
+	}
+	if e.Quarantined != "" {
+		msg += " (damaged copy kept as " + e.Quarantined + ")"
+	}
+	return msg
+}
+
+var (
+	recoveryMu sync.Mutex
+	recoveries []RecoveryEvent
+)
+
+func recordRecovery(e RecoveryEvent) {
+	recoveryMu.Lock()
+	recoveries = append(recoveries, e)
+	recoveryMu.Unlock()
+	logger.Warn("[Recovery] %s", e.Summary())
+}
+
+// Recoveries returns the recovery events since startup.
+func Recoveries() []RecoveryEvent {
+	recoveryMu.Lock()
+	defer recoveryMu.Unlock()
+	out := make([]RecoveryEvent, len(recoveries))
+	copy(out, recoveries)
+	return out
+}
+
+// RecoveryNotes returns one-line summaries of the recovery events since
+// startup (for the tray).
+func (s *Server) RecoveryNotes() []string {
+	var notes []string
+	for _, e := range Recoveries() {
+		notes = append(notes, e.Summary())
+	}
+	return notes
+}
+
+func backupPath(path string, gen int) string {
+	return fmt.Sprintf("%s.%d.bak", path, gen)
+}
+
+// rotateBackups shifts the backup generations of path and copies the
+// current file into generation 1. A missing file is not an error.
+func rotateBackups(path string) error {
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for gen := DataFileBackups; gen > 1; gen-- {
+		if err := os.Rename(backupPath(path, gen-1), backupPath(path, gen)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.WriteFile(backupPath(path, 1), raw, 0600)
+}
+
+// loadEntries reads a data file whose payload is a JSON object of
+// independent entries (every store's file). If the payload cannot be parsed,
+// or an encrypted file fails authentication while one of its encrypted
+// backups opens with the same key, the file is moved aside as
+// <file>.corrupt-<timestamp>, entries are restored from the newest readable
+// backup, and any individually intact entries in the damaged file are
+// salvaged on top. recovered reports that this happened so the caller can
+// write a clean file. A missing or wrong key (no generation decrypts) or a
+// file from a newer companion is still an error and nothing is touched:
+// the file is fine, this companion just cannot read it.
+func loadEntries(path, kind string) (entries map[string]json.RawMessage, recovered bool, err error) {
+	data, err := readDataFile(path, kind)
+	var damage error
+	switch {
+	case errors.Is(err, crypt.ErrDecrypt):
+		if !keyOpensBackup(path) {
+			return nil, false, fmt.Errorf("%s: %w; the file and its backups were left as they are", filepath.Base(path), ErrWrongKey)
+		}
+		damage = err
+	case err != nil:
+		return nil, false, err
+	default:
+		entries = make(map[string]json.RawMessage)
+		if damage = json.Unmarshal(data, &entries); damage == nil {
+			return entries, false, nil
+		}
+	}
+
+	ev := RecoveryEvent{
+		File:   filepath.Base(path),
+		Time:   time.Now().Format(time.RFC3339),
+		Reason: damage.Error(),
+	}
+
+	quarantine := fmt.Sprintf("%s.corrupt-%s", path, time.Now().Format("20060102-150405"))
+	if err := os.Rename(path, quarantine); err != nil {
+		logger.Error("Could not quarantine %s: %v", ev.File, err)
+	} else {
+		ev.Quarantined = filepath.Base(quarantine)
+	}
+
+	entries = make(map[string]json.RawMessage)
+	for gen := 1; gen <= DataFileBackups; gen++ {
+		b := backupPath(path, gen)
+		if restored, ok := readBackup(b, kind); ok {
+			entries = restored
+			ev.RestoredFrom = filepath.Base(b)
+			break
+		}
+	}
+
+	for id, entry := range salvageEntries(data) {
+		entries[id] = entry
+		ev.Salvaged++
+	}
+	ev.Recovered = len(entries)
+	recordRecovery(ev)
+	return entries, true, nil
+}
+
+// keyOpensBackup reports whether the current key opens any encrypted
+// backup generation of path, i.e. whether a file that fails to decrypt is
+// damaged rather than written with another key. Plaintext generations
+// prove nothing about the key and are skipped.
+func keyOpensBackup(path string) bool {
+	for gen := 1; gen <= DataFileBackups; gen++ {
+		raw, err := os.ReadFile(backupPath(path, gen))
+		if err != nil || !crypt.IsEncrypted(raw) {
+			continue
+		}
+		if _, err := crypt.Decrypt(raw); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// readBackup reads a backup generation, reporting whether it parsed cleanly.
+func readBackup(path, kind string) (map[string]json.RawMessage, bool) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	plain, err := crypt.Decrypt(raw)
+	if err != nil {
+		return nil, false
+	}
+	data, _, err := schema.Decode(kind, plain)
+	if err != nil {
+		return nil, false
+	}
+	entries := make(map[string]json.RawMessage)
+	if json.Unmarshal(data, &entries) != nil {
+		return nil, false
+	}
+	return entries, true
+}
+
+// salvageEntries streams a damaged JSON object and returns every entry that
+// decodes completely before the first error. It understands both the
+// versioned envelope ({"kind", "schemaVersion", "data": {...}}) and bare
+// pre-versioning files.
+func salvageEntries(data []byte) map[string]json.RawMessage {
+	out := make(map[string]json.RawMessage)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return out
+	}
+
+	envelope := false
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return out
+		}
+		key, _ := tok.(string)
+
+		if envelope && key == "data" {
+			if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+				return out
+			}
+			for dec.More() {
+				tok, err := dec.Token()
+				if err != nil {
+					return out
+				}
+				var entry json.RawMessage
+				if err := dec.Decode(&entry); err != nil {
+					return out
+				}
+				out[tok.(string)] = entry
+			}
+			return out
+		}
+
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return out
+		}
+		if key == "schemaVersion" || key == "kind" {
+			if v := bytes.TrimSpace(value); len(v) > 0 && v[0] != '{' && v[0] != '[' {
+				envelope = true
+				continue
+			}
+		}
+		out[key] = value
+	}
+	return out
+}
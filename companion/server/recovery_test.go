@@ -0,0 +1,183 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/harshvasudeva/synctabs-companion/crypt"
+	"github.com/harshvasudeva/synctabs-companion/schema"
+)
+
+// useKey switches encryption on with a fresh key file, or off. It is
+// switched off again when the test ends.
+func useKey(t *testing.T, enable bool) {
+	t.Helper()
+	if err := crypt.Init(t.TempDir(), enable, false); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = crypt.Init(t.TempDir(), false, false) })
+}
+
+// writeGenerations saves each payload in turn, leaving the last as the
+// file and the earlier ones as its backups.
+func writeGenerations(t *testing.T, path string, payloads ...map[string]int) {
+	t.Helper()
+	for _, p := range payloads {
+		if err := writeDataFile(path, schema.Tabs, p, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// snapshotDir returns the name and contents of every file in dir.
+func snapshotDir(t *testing.T, dir string) map[string][]byte {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(map[string][]byte)
+	for _, e := range entries {
+		raw, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		out[e.Name()] = raw
+	}
+	return out
+}
+
+func TestLoadEntriesWrongKeyLeavesFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tabs.json")
+	useKey(t, true) // key A
+	writeGenerations(t, path, map[string]int{"a": 1}, map[string]int{"a": 1, "b": 2}, map[string]int{"a": 1, "b": 2, "c": 3})
+
+	// Two more keys, so key A is no longer even kept for reading
+	useKey(t, true)
+	useKey(t, true) // key B
+	before := snapshotDir(t, dir)
+	events := len(Recoveries())
+
+	entries, recovered, err := loadEntries(path, schema.Tabs)
+	if !errors.Is(err, ErrWrongKey) {
+		t.Fatalf("loadEntries error = %v, want ErrWrongKey", err)
+	}
+	if entries != nil || recovered {
+		t.Errorf("loadEntries = %v, %v; want nothing loaded", entries, recovered)
+	}
+	if after := snapshotDir(t, dir); !reflect.DeepEqual(after, before) {
+		t.Errorf("files changed: before %v, after %v", keys(before), keys(after))
+	}
+	if len(Recoveries()) != events {
+		t.Errorf("a recovery was recorded for a wrong key")
+	}
+}
+
+func TestLoadEntriesRecovery(t *testing.T) {
+	flipLast := func(raw []byte) []byte {
+		raw[len(raw)-1] ^= 1
+		return raw
+	}
+	truncated := func([]byte) []byte {
+		return []byte(`{"kind":"tabs","schemaVersion":1,"data":{"a":1,"c":3,"d":`)
+	}
+
+	tests := []struct {
+		name        string
+		encrypt     bool
+		damage      func([]byte) []byte // nil: the file is intact
+		want        []string
+		recovered   bool
+		restoredGen string
+	}{
+		{"intact", false, nil, []string{"a", "b"}, false, ""},
+		{"intact encrypted", true, nil, []string{"a", "b"}, false, ""},
+		{"unparseable, salvaged over backup", false, truncated, []string{"a", "c"}, true, "tabs.json.1.bak"},
+		{"tampered, key opens a backup", true, flipLast, []string{"a"}, true, "tabs.json.1.bak"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useKey(t, tt.encrypt)
+			dir := t.TempDir()
+			path := filepath.Join(dir, "tabs.json")
+			writeGenerations(t, path, map[string]int{"a": 1}, map[string]int{"a": 1, "b": 2})
+			if tt.damage != nil {
+				raw, _ := os.ReadFile(path)
+				if err := os.WriteFile(path, tt.damage(raw), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			damaged, _ := os.ReadFile(path)
+
+			entries, recovered, err := loadEntries(path, schema.Tabs)
+			if err != nil {
+				t.Fatalf("loadEntries: %v", err)
+			}
+			if got := keys(entries); !reflect.DeepEqual(got, tt.want) || recovered != tt.recovered {
+				t.Fatalf("loadEntries = %v, %v; want %v, %v", got, recovered, tt.want, tt.recovered)
+			}
+			if !tt.recovered {
+				return
+			}
+
+			// The damaged file is kept aside byte for byte, backups untouched
+			var quarantined string
+			for name, raw := range snapshotDir(t, dir) {
+				if strings.HasPrefix(name, "tabs.json.corrupt-") {
+					quarantined = name
+					if !bytes.Equal(raw, damaged) {
+						t.Errorf("quarantined copy differs from the damaged file")
+					}
+				}
+			}
+			if quarantined == "" {
+				t.Errorf("damaged file was not quarantined")
+			}
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Errorf("damaged file still in place")
+			}
+			ev := Recoveries()[len(Recoveries())-1]
+			if ev.RestoredFrom != tt.restoredGen || ev.Quarantined != quarantined {
+				t.Errorf("recovery event = %+v", ev)
+			}
+		})
+	}
+}
+
+func TestSalvageEntries(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{"envelope cut mid-entry", `{"kind":"tabs","schemaVersion":1,"data":{"a":{"x":1},"b":{"x":`, []string{"a"}},
+		{"bare file cut mid-entry", `{"a":1,"b":[1,2],"c":`, []string{"a", "b"}},
+		{"bare object entries", `{"kind":{"v":1},"a":1`, []string{"a", "kind"}},
+		{"not an object", `[1,2,3]`, []string{}},
+		{"garbage", `\x00\x01`, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := keys(salvageEntries([]byte(tt.data))); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("salvageEntries = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// keys returns the sorted keys of m.
+func keys[V any](m map[string]V) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
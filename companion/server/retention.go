@@ -0,0 +1,163 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/harshvasudeva/synctabs-companion/config"
+	"github.com/harshvasudeva/synctabs-companion/logger"
+)
+
+const RetentionCheckInterval = time.Hour
+
+var (
+	ErrUnknownBrowser   = errors.New("unknown browser")
+	ErrBrowserConnected = errors.New("browser is connected")
+)
+
+// startRetentionWorker expires stale browsers and pending tabs every
+// RetentionCheckInterval, using the current retentionDays setting.
+func (s *Server) startRetentionWorker() {
+	ticker := time.NewTicker(RetentionCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.EnforceRetention()
+		s.CollectFavicons()
+	}
+}
+
+// EnforceRetention removes offline browsers and pending tabs older than the
+// configured retention. Browsers marked neverForget are kept, along with
+// tabs queued for them.
+func (s *Server) EnforceRetention() {
+	cutoff := config.RetentionCutoff()
+
+	removed := s.state.ExpireStale(cutoff)
+	for _, id := range removed {
+		s.reg.broadcast("", map[string]interface{}{
+			"type":      "browser-removed",
+			"browserId": id,
+		})
+	}
+
+	keep := make(map[string]bool)
+	for id, data := range s.state.GetAll() {
+		if data.NeverForget {
+			keep[id] = true
+		}
+	}
+	expiredTabs := s.pending.ExpireStale(cutoff, keep)
+
+	if len(removed) > 0 || expiredTabs > 0 {
+		logger.Info("[Retention] Expired %d browser(s) and %d pending tab(s)", len(removed), expiredTabs)
+	}
+}
+
+// ForgetBrowser removes a browser's state, pending tabs (queued for it or
+// sent by it) and recorded history. Connected browsers cannot be forgotten.
+func (s *Server) ForgetBrowser(browserId string) error {
+	if _, ok := s.reg.get(browserId); ok {
+		return ErrBrowserConnected
+	}
+	if !s.state.Forget(browserId) {
+		return ErrUnknownBrowser
+	}
+	dropped := s.pending.Forget(browserId)
+	s.usage.Forget(browserId)
+	if err := s.aliases.Forget(browserId); err != nil {
+		logger.Error("Alias save failed: %v", err)
+	}
+	s.stats.Forget(browserId)
+	if err := s.stats.Save(); err != nil {
+		logger.Error("Stats save failed: %v", err)
+	}
+
+	s.reg.broadcast("", map[string]interface{}{
+		"type":      "browser-removed",
+		"browserId": browserId,
+	})
+	logger.Info("[Forget] Removed browser %s (%d pending tab(s))", browserId, dropped)
+	return nil
+}
+
+// handleBrowsers responds to GET /browsers
+func (s *Server) handleBrowsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	all := s.state.GetAll()
+	summary := make(map[string]interface{}, len(all))
+	for id, data := range all {
+		summary[id] = map[string]interface{}{
+			"browserName":  data.BrowserName,
+			"alias":        s.aliases.Get(id),
+			"tabCount":     len(data.Tabs),
+			"online":       data.Online,
+			"lastSeen":     data.LastSeen,
+			"neverForget":  data.NeverForget,
+			"profileLabel": data.ProfileLabel,
+			"mergedIds":    data.MergedIDs,
+			"distinctFrom": data.DistinctFrom,
+			"sharing":      data.sharingMode(),
+			"hiddenFrom":   data.HiddenFrom,
+		}
+	}
+	writeJSON(w, map[string]interface{}{
+		"retentionDays": config.Get().RetentionDays,
+		"browsers":      summary,
+	})
+}
+
+// handleBrowser responds to DELETE /browsers/{id} (forget),
+// POST /browsers/{id}/pin with {"neverForget": bool},
+// POST /browsers/{id}/merge|split (see identity.go) and
+// POST /browsers/{id}/sharing (see sharing.go).
+func (s *Server) handleBrowser(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/browsers/"), "/")
+	parts := strings.Split(rest, "/")
+	browserId := parts[0]
+	if browserId == "" {
+		http.Error(w, "Missing browser ID", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		if err := s.ForgetBrowser(browserId); err != nil {
+			status := http.StatusConflict
+			if errors.Is(err, ErrUnknownBrowser) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"ok": true})
+
+	case len(parts) == 2 && (parts[1] == "merge" || parts[1] == "split") && r.Method == http.MethodPost:
+		s.handleBrowserIdentity(w, r, browserId, parts[1])
+
+	case len(parts) == 2 && parts[1] == "sharing" && r.Method == http.MethodPost:
+		s.handleBrowserSharing(w, r, browserId)
+
+	case len(parts) == 2 && parts[1] == "pin" && r.Method == http.MethodPost:
+		var req struct {
+			NeverForget bool `json:"neverForget"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !s.state.SetNeverForget(browserId, req.NeverForget) {
+			http.Error(w, ErrUnknownBrowser.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"ok": true, "neverForget": req.NeverForget})
+
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
@@ -0,0 +1,305 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/harshvasudeva/synctabs-companion/config"
+	"github.com/harshvasudeva/synctabs-companion/logger"
+)
+
+const (
+	// RouteAuto as a send-tab target picks the browser from the routing rules.
+	RouteAuto = "auto"
+	// LocalSenderID marks tabs submitted over HTTP (e.g. from the command line).
+	LocalSenderID = "local"
+)
+
+var ErrNoRoute = errors.New("no routing rule matches this URL")
+
+// compiledRoute is the compiled form of a config.RouteRule.
+type compiledRoute struct {
+	domain  string
+	pattern *regexp.Regexp
+	target  string
+}
+
+var (
+	routesMu  sync.RWMutex
+	routesCur []compiledRoute
+)
+
+// SetRoutingRules compiles rules and makes them current. Rules are
+// validated by config, so compile errors only skip the offending entry.
+func SetRoutingRules(rules []config.RouteRule) {
+	compiled := make([]compiledRoute, 0, len(rules))
+	for _, r := range rules {
+		cr := compiledRoute{domain: normaliseDomain(r.Domain), target: strings.TrimSpace(r.Target)}
+		if r.Pattern != "" {
+			re, err := regexp.Compile(r.Pattern)
+			if err != nil {
+				logger.Warn("Ignoring routing rule %q: %v", r.Pattern, err)
+				continue
+			}
+			cr.pattern = re
+		}
+		compiled = append(compiled, cr)
+	}
+	routesMu.Lock()
+	routesCur = compiled
+	routesMu.Unlock()
+}
+
+// matchRoute returns the index and target of the first rule matching rawURL.
+func matchRoute(rawURL string) (int, string, bool) {
+	routesMu.RLock()
+	rules := routesCur
+	routesMu.RUnlock()
+
+	host := ""
+	if u, err := url.Parse(rawURL); err == nil {
+		host = strings.ToLower(u.Hostname())
+	}
+	for i, r := range rules {
+		if r.domain != "" && !domainMatches(host, r.domain) {
+			continue
+		}
+		if r.pattern != nil && !r.pattern.MatchString(rawURL) {
+			continue
+		}
+		return i, r.target, true
+	}
+	return -1, "", false
+}
+
+// resolveBrowser finds a browser by ID, alias or browser name (case-
+// insensitive). Among several with the same name, a connected one wins.
+func (s *Server) resolveBrowser(ref string) (string, bool) {
+	all := s.state.GetAll()
+	if _, ok := all[ref]; ok {
+		return ref, true
+	}
+	found := ""
+	for id, data := range all {
+		if data.Virtual {
+			continue
+		}
+		if !strings.EqualFold(s.aliases.DisplayName(id, data.BrowserName), ref) && !strings.EqualFold(data.BrowserName, ref) {
+			continue
+		}
+		if _, online := s.reg.get(id); online {
+			return id, true
+		}
+		if found == "" || id < found {
+			found = id
+		}
+	}
+	return found, found != ""
+}
+
+// Route is the outcome of routing a URL.
+type Route struct {
+	URL             string `json:"url"`
+	Matched         bool   `json:"matched"`
+	Rule            int    `json:"rule"` // index into routingRules, -1 if none
+	TargetBrowserID string `json:"targetBrowserId,omitempty"`
+	BrowserName     string `json:"browserName,omitempty"`
+	Online          bool   `json:"online"`
+	Delivery        string `json:"delivery,omitempty"` // "delivered" or "queued"
+	Error           string `json:"error,omitempty"`
+}
+
+// RouteURL picks the target browser for rawURL from the routing rules.
+func (s *Server) RouteURL(rawURL string) (Route, error) {
+	route := Route{URL: LogURL(rawURL), Rule: -1}
+	i, ref, ok := matchRoute(rawURL)
+	if !ok {
+		return route, ErrNoRoute
+	}
+	route.Matched, route.Rule = true, i
+	id, ok := s.resolveBrowser(ref)
+	if !ok {
+		return route, fmt.Errorf("routing rule %d: %w %q", i, ErrUnknownBrowser, ref)
+	}
+	route.TargetBrowserID = id
+	if data, ok := s.state.Get(id); ok {
+		route.BrowserName = s.aliases.DisplayName(id, data.BrowserName)
+	}
+	_, route.Online = s.reg.get(id)
+	route.Delivery = "queued"
+	if route.Online {
+		route.Delivery = "delivered"
+	}
+	return route, nil
+}
+
+// prepareSend validates and sanitises a tab being sent to another browser.
+func (s *Server) prepareSend(senderId, senderName, rawURL, title, favIconURL string) (PendingTab, error) {
+	if !isValidURL(rawURL) {
+		return PendingTab{}, errors.New("Invalid URL")
+	}
+	rules := privacy()
+	if rules.Blocked(rawURL) {
+		return PendingTab{}, errors.New("URL blocked by privacy rules")
+	}
+	title = rules.Title(rawURL, title)
+	if favIconURL = s.favicons.Intern(favIconURL); len(favIconURL) > 2048 {
+		favIconURL = ""
+	}
+	return PendingTab{
+		URL:               truncate(rules.RedactURL(rawURL), 2048),
+		Title:             truncate(title, 500),
+		FavIconURL:        favIconURL,
+		SenderBrowserID:   senderId,
+		SenderBrowserName: senderName,
+		SentAt:            time.Now().Format(time.RFC3339),
+	}, nil
+}
+
+// sendTab delivers one tab to a connected target or queues it. Returns
+// "delivered", "queued", StatusAwaitingConfirm when the target's incoming
+// rules want it confirmed or StatusHeld during its quiet hours; and how
+// the queue took it.
+func (s *Server) sendTab(targetBrowserId string, tab PendingTab) (string, EnqueueResult, error) {
+	if err := s.state.checkSend(tab.SenderBrowserID, targetBrowserId); err != nil {
+		return "", EnqueueResult{}, err
+	}
+	confirm, err := checkIncoming(targetBrowserId, tab.SenderBrowserID, tab.URL)
+	if err != nil {
+		return "", EnqueueResult{}, err
+	}
+	if confirm {
+		tab.AwaitingConfirm = true
+		res, err := s.pending.Enqueue(targetBrowserId, tab)
+		if err != nil {
+			return "", res, err
+		}
+		if _, quiet := s.holdIfQuiet(targetBrowserId); !quiet {
+			if target, ok := s.reg.get(targetBrowserId); ok {
+				sendConfirmRequest(target, s.pending.Awaiting(targetBrowserId))
+			}
+		}
+		return StatusAwaitingConfirm, res, nil
+	}
+	if held, res, err := s.holdQuiet(targetBrowserId, tab); held {
+		return StatusHeld, res, err
+	}
+	if target, ok := s.reg.get(targetBrowserId); ok {
+		_ = target.sendJSON(map[string]interface{}{
+			"type": "pending-tabs",
+			"tabs": []PendingTab{tab},
+		})
+		return "delivered", EnqueueResult{}, nil
+	}
+	res, err := s.pending.Enqueue(targetBrowserId, tab)
+	return "queued", res, err
+}
+
+// handleRoute responds to GET /route?url=... with the browser the routing
+// rules would send the URL to, without sending it.
+func (s *Server) handleRoute(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rawURL := r.URL.Query().Get("url")
+	if !isValidURL(rawURL) {
+		http.Error(w, "Invalid URL", http.StatusBadRequest)
+		return
+	}
+	route, err := s.RouteURL(rawURL)
+	if err != nil {
+		route.Error = err.Error()
+	}
+	writeJSON(w, route)
+}
+
+// handleSend responds to POST /send {"url", "title", "target"}: sends a
+// URL to a browser, or by the routing rules when target is "auto" (the
+// default). Used by the "send" command line. Web pages are refused: their
+// requests always carry an Origin, and a plain form cannot post JSON.
+func (s *Server) handleSend(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if origin := r.Header.Get("Origin"); origin != "" && !extensionOrigin(origin) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" {
+		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	var req struct {
+		URL    string `json:"url"`
+		Title  string `json:"title"`
+		Target string `json:"target"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Target == "" {
+		req.Target = RouteAuto
+	}
+	if req.Title == "" {
+		req.Title = req.URL
+	}
+
+	route := Route{URL: LogURL(req.URL), Rule: -1}
+	target := req.Target
+	if target == RouteAuto {
+		var err error
+		if route, err = s.RouteURL(req.URL); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		target = route.TargetBrowserID
+	} else if id, ok := s.resolveBrowser(target); ok {
+		target = id
+	} else {
+		http.Error(w, ErrUnknownBrowser.Error(), http.StatusNotFound)
+		return
+	}
+
+	tab, err := s.prepareSend(LocalSenderID, "SyncTabs Companion", req.URL, req.Title, "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	status, res, err := s.sendTab(target, tab)
+	var rejected *RejectedError
+	if errors.As(err, &rejected) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"ok": false, "reason": rejected.Reason, "error": rejected.Error(),
+		})
+		return
+	}
+	if errors.Is(err, ErrSendNotAllowed) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	logger.Info("[Send] %s → %s (%s): %s", LocalSenderID, target, status, LogURL(tab.URL))
+	writeJSON(w, map[string]interface{}{
+		"ok":              true,
+		"status":          status,
+		"targetBrowserId": target,
+		"rule":            route.Rule,
+		"queue":           res,
+	})
+}
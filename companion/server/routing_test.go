@@ -0,0 +1,89 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/harshvasudeva/synctabs-companion/config"
+)
+
+func TestMatchRoute(t *testing.T) {
+	SetRoutingRules([]config.RouteRule{
+		{Domain: "github.com", Target: "work"},
+		{Pattern: `^https://docs\.google\.com/.*/edit`, Target: "docs"},
+		{Domain: ".YouTube.com", Target: "home"},
+		{Domain: "example.com", Pattern: `/private/`, Target: "private"},
+		{Pattern: `(`, Target: "broken"}, // skipped, does not shift later rules
+		{Domain: "example.com", Target: "example"},
+	})
+	defer SetRoutingRules(nil)
+
+	tests := []struct {
+		name   string
+		url    string
+		index  int
+		target string
+		ok     bool
+	}{
+		{"domain", "https://github.com/a/b", 0, "work", true},
+		{"subdomain", "https://gist.github.com/x", 0, "work", true},
+		{"host case", "https://GitHub.COM/", 0, "work", true},
+		{"lookalike host", "https://notgithub.com/", -1, "", false},
+		{"pattern", "https://docs.google.com/document/d/1/edit", 1, "docs", true},
+		{"pattern miss", "https://docs.google.com/document/d/1/view", -1, "", false},
+		{"normalised domain", "https://www.youtube.com/watch?v=1", 2, "home", true},
+		{"domain and pattern", "https://example.com/private/1", 3, "private", true},
+		{"first match wins", "https://example.com/public", 4, "example", true},
+		{"unparseable URL", "://", -1, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i, target, ok := matchRoute(tt.url)
+			if i != tt.index || target != tt.target || ok != tt.ok {
+				t.Errorf("matchRoute(%q) = %d, %q, %v; want %d, %q, %v", tt.url, i, target, ok, tt.index, tt.target, tt.ok)
+			}
+		})
+	}
+}
+
+func TestHandleSendCallers(t *testing.T) {
+	tests := []struct {
+		name        string
+		origin      string
+		contentType string
+		want        int
+	}{
+		{"send command", "", "application/json", http.StatusOK},
+		{"extension", "chrome-extension://abcdef", "application/json; charset=utf-8", http.StatusOK},
+		{"web page", "https://evil.example", "application/json", http.StatusForbidden},
+		{"bare extension scheme", "chrome-extension://", "application/json", http.StatusForbidden},
+		{"form post", "", "text/plain", http.StatusUnsupportedMediaType},
+		{"no content type", "", "", http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{
+				state:   newTestState(map[string]*BrowserData{"laptop": {BrowserName: "Google Chrome"}}),
+				pending: &PendingStore{data: map[string][]PendingTab{}, saveCh: make(chan struct{}, 1)},
+				reg:     newConnectionRegistry(),
+			}
+			req := httptest.NewRequest(http.MethodPost, "/send", strings.NewReader(`{"url":"https://example.com/","target":"laptop"}`))
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			s.handleSend(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if queued := len(s.pending.data["laptop"]); (queued == 1) != (tt.want == http.StatusOK) {
+				t.Errorf("queued %d tab(s)", queued)
+			}
+		})
+	}
+}
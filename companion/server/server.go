@@ -11,35 +11,46 @@ import (
 
 	"github.com/gorilla/websocket"
 	"github.com/harshvasudeva/synctabs-companion/config"
+	"github.com/harshvasudeva/synctabs-companion/folderlock"
 	"github.com/harshvasudeva/synctabs-companion/logger"
 )
 
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  4096,
 	WriteBufferSize: 4096,
+	// Routes already require authorized (see auth.go); this keeps web
+	// pages out even if the upgrade is reached some other way
 	CheckOrigin: func(r *http.Request) bool {
-		host, _, err := net.SplitHostPort(r.RemoteAddr)
-		if err != nil {
-			return false
-		}
-		return host == "127.0.0.1" || host == "::1"
+		origin := r.Header.Get("Origin")
+		return loopback(r) && (origin == "" || extensionOrigin(origin))
 	},
 }
 
 // Server holds all server state.
 type Server struct {
-	mu        sync.Mutex
-	state     *StateStore
-	pending   *PendingStore
-	reg       *connectionRegistry
-	httpSrv   *http.Server
-	listener  net.Listener
-	cfg       config.Config
-	startTime time.Time
-}
-
-// New creates a Server with the given config.
-func New(cfg config.Config) (*Server, error) {
+	mu         sync.Mutex
+	state      *StateStore
+	pending    *PendingStore
+	stats      *StatsStore
+	usage      *UsageTracker
+	aliases    *AliasStore
+	favicons   *FaviconStore
+	stashes    *StashStore
+	workspaces *WorkspaceStore
+	reg        *connectionRegistry
+	mirror     mirrorEngine
+	handoffs   handoffTable
+	httpSrv    *http.Server
+	listener   net.Listener
+	cfg        config.Config
+	startTime  time.Time
+	lock       *folderlock.Lock // held on cfg.DataFolder
+	token      string           // API token for clients without an extension origin
+}
+
+// New creates a Server with the given config. lock must be held on
+// cfg.DataFolder; the server takes it over when the data folder moves.
+func New(cfg config.Config, lock *folderlock.Lock) (*Server, error) {
 	state, err := NewStateStore(cfg.DataFolder)
 	if err != nil {
 		return nil, fmt.Errorf("state store: %w", err)
@@ -50,12 +61,66 @@ func New(cfg config.Config) (*Server, error) {
 		return nil, fmt.Errorf("pending store: %w", err)
 	}
 
+	stats, err := NewStatsStore(cfg.DataFolder, state)
+	if err != nil {
+		return nil, fmt.Errorf("stats store: %w", err)
+	}
+
+	usage, err := NewUsageTracker(cfg.DataFolder)
+	if err != nil {
+		return nil, fmt.Errorf("usage tracker: %w", err)
+	}
+
+	aliases, err := NewAliasStore(cfg.DataFolder)
+	if err != nil {
+		return nil, fmt.Errorf("alias store: %w", err)
+	}
+
+	favicons, err := NewFaviconStore(cfg.DataFolder)
+	if err != nil {
+		return nil, fmt.Errorf("favicon store: %w", err)
+	}
+
+	stashes, err := NewStashStore(cfg.DataFolder)
+	if err != nil {
+		return nil, fmt.Errorf("stash store: %w", err)
+	}
+
+	workspaces, err := NewWorkspaceStore(cfg.DataFolder)
+	if err != nil {
+		return nil, fmt.Errorf("workspace store: %w", err)
+	}
+
+	token, err := config.APIToken()
+	if err != nil {
+		return nil, fmt.Errorf("api token: %w", err)
+	}
+
 	s := &Server{
-		state:   state,
-		pending: pending,
-		reg:     newConnectionRegistry(),
-		cfg:     cfg,
+		state:      state,
+		pending:    pending,
+		stats:      stats,
+		usage:      usage,
+		aliases:    aliases,
+		favicons:   favicons,
+		stashes:    stashes,
+		workspaces: workspaces,
+		reg:        newConnectionRegistry(),
+		cfg:        cfg,
+		lock:       lock,
+		token:      token,
 	}
+	s.reg.visibility = state.visibility
+	// Apply privacy rules to data saved before they were configured
+	s.ApplyPrivacyRules()
+	// Move inline favicons saved by older versions into the cache
+	s.InternFavicons()
+	SetRoutingRules(cfg.RoutingRules)
+	SetIncomingRules(cfg.IncomingRules)
+	s.SetMirrorRules(cfg.MirrorRules)
+
+	go s.startRetentionWorker()
+	go s.startQuietWorker()
 	return s, nil
 }
 
@@ -108,23 +173,35 @@ func (s *Server) Stop() {
 	}
 }
 
+// Close releases the data folder lock. Call once on exit, after Stop.
+func (s *Server) Close() {
+	s.mu.Lock()
+	lock := s.lock
+	s.lock = nil
+	s.mu.Unlock()
+	if lock != nil {
+		_ = lock.Release()
+	}
+}
+
 // Restart stops and starts with a new config.
 func (s *Server) Restart(newCfg config.Config) error {
 	logger.Info("Restarting server on port %d", newCfg.Port)
 	s.Stop()
 
+	// Move data if the folder changed (normally already done by /config)
+	if report, err := s.MoveDataFolder(newCfg.DataFolder, false); err != nil {
+		newCfg.DataFolder = report.From
+	}
+
 	s.mu.Lock()
-	oldDataFolder := s.cfg.DataFolder
 	s.cfg = newCfg
+	lock := s.lock
 	s.mu.Unlock()
 
-	// Update data stores if folder changed
-	if newCfg.DataFolder != oldDataFolder {
-		if err := s.state.UpdateDataFolder(newCfg.DataFolder); err != nil {
-			logger.Error("Failed to update state folder: %v", err)
-		}
-		if err := s.pending.UpdateDataFolder(newCfg.DataFolder); err != nil {
-			logger.Error("Failed to update pending folder: %v", err)
+	if lock != nil {
+		if err := lock.SetPort(newCfg.Port); err != nil {
+			logger.Warn("Could not update lock file: %v", err)
 		}
 	}
 
@@ -141,9 +218,9 @@ func (s *Server) ConnectedCount() int {
 	return s.reg.count()
 }
 
-// BrowserNames returns names of currently connected browsers.
+// BrowserNames returns display names (alias if set) of currently connected browsers.
 func (s *Server) BrowserNames() []string {
-	return s.reg.names(s.state)
+	return s.reg.names(s.state, s.aliases)
 }
 
 // StartTime returns when the server started.
@@ -171,29 +248,27 @@ func (s *Server) GetPending() *PendingStore {
 }
 
 func (s *Server) registerRoutes(mux *http.ServeMux) {
-	mux.HandleFunc("/", s.requireLocalhost(s.handleWebSocket))
+	mux.HandleFunc("/", s.requireClient(s.handleWebSocket))
 	mux.HandleFunc("/health", s.requireLocalhost(s.handleHealth))
-	mux.HandleFunc("/config", s.requireLocalhost(s.handleConfig))
-	mux.HandleFunc("/status", s.requireLocalhost(s.handleStatus))
-}
-
-// requireLocalhost rejects non-loopback connections.
-func (s *Server) requireLocalhost(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		host, _, err := net.SplitHostPort(r.RemoteAddr)
-		if err != nil || (host != "127.0.0.1" && host != "::1") {
-			http.Error(w, "Forbidden", http.StatusForbidden)
-			return
-		}
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusNoContent)
-			return
-		}
-		next(w, r)
-	}
+	mux.HandleFunc("/config", s.requireClient(s.handleConfig))
+	mux.HandleFunc("/status", s.requireClient(s.handleStatus))
+	mux.HandleFunc("/duplicates", s.requireClient(s.handleDuplicates))
+	mux.HandleFunc("/stats", s.requireClient(s.handleStats))
+	mux.HandleFunc("/usage", s.requireClient(s.handleUsage))
+	mux.HandleFunc("/browsers", s.requireClient(s.handleBrowsers))
+	mux.HandleFunc("/browsers/", s.requireClient(s.handleBrowser))
+	mux.HandleFunc("/aliases", s.requireClient(s.handleAliases))
+	mux.HandleFunc("/pending", s.requireClient(s.handlePending))
+	mux.HandleFunc("/pending/", s.requireClient(s.handlePendingTarget))
+	mux.HandleFunc("/stashes", s.requireClient(s.handleStashes))
+	mux.HandleFunc("/stashes/", s.requireClient(s.handleStash))
+	mux.HandleFunc("/workspaces", s.requireClient(s.handleWorkspaces))
+	mux.HandleFunc("/workspaces/", s.requireClient(s.handleWorkspace))
+	mux.HandleFunc("/favicon/", s.requireClient(s.handleFavicon))
+	mux.HandleFunc("/route", s.requireClient(s.handleRoute))
+	mux.HandleFunc("/send", s.requireClient(s.handleSend))
+	mux.HandleFunc("/export", s.requireClient(s.handleExport))
+	mux.HandleFunc("/import", s.requireClient(s.handleImport, "application/octet-stream"))
 }
 
 func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
@@ -208,7 +283,7 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	cfg := s.cfg
 	s.mu.Unlock()
 
-	go HandleConnection(ws, s.state, s.pending, s.reg, cfg)
+	go HandleConnection(ws, s, cfg)
 }
 
 // writeJSON writes v as JSON to w.
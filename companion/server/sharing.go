@@ -0,0 +1,176 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/harshvasudeva/synctabs-companion/logger"
+)
+
+// Sharing modes: which way a browser's tabs flow
+const (
+	SharingShared      = "shared"       // default: publishes its tabs and receives others'
+	SharingPrivate     = "private"      // invisible to others; sees nothing and cannot send or be sent tabs
+	SharingPublishOnly = "publish-only" // others see its tabs; it sees no tabs and is not a send-tab target
+	SharingReceiveOnly = "receive-only" // sees others' tabs and accepts sent tabs; its own tabs stay hidden
+)
+
+var ErrSendNotAllowed = errors.New("sharing settings do not allow sending this tab")
+
+func validSharing(mode string) bool {
+	return mode == "" || mode == SharingShared || mode == SharingPrivate ||
+		mode == SharingPublishOnly || mode == SharingReceiveOnly
+}
+
+// publishes reports whether other browsers may see b's tabs.
+func (b *BrowserData) publishes() bool {
+	return b.Sharing == "" || b.Sharing == SharingShared || b.Sharing == SharingPublishOnly
+}
+
+// receives reports whether b sees other browsers' tabs and accepts sent tabs.
+func (b *BrowserData) receives() bool {
+	return b.Sharing == "" || b.Sharing == SharingShared || b.Sharing == SharingReceiveOnly
+}
+
+func (b *BrowserData) hiddenFrom(viewerId string) bool {
+	for _, id := range b.HiddenFrom {
+		if id == viewerId {
+			return true
+		}
+	}
+	return false
+}
+
+// shareLevel is how much of one browser another may see.
+type shareLevel int
+
+const (
+	shareNone     shareLevel = iota // not even listed
+	sharePresence                   // listed (e.g. as a send target), without tabs
+	shareFull
+)
+
+// shareLevelFor returns how much of entry viewer may see. viewer is nil
+// for connections that have not registered.
+func shareLevelFor(entry, viewer *BrowserData, viewerId string) shareLevel {
+	if entry.Sharing == SharingPrivate || entry.hiddenFrom(viewerId) {
+		return shareNone
+	}
+	if viewer != nil && viewer.Sharing == SharingPrivate {
+		return shareNone
+	}
+	if !entry.publishes() || (viewer != nil && !viewer.receives()) {
+		return sharePresence
+	}
+	return shareFull
+}
+
+// visibility returns how much of browser aboutId the browser viewerId may see.
+func (s *StateStore) visibility(aboutId, viewerId string) shareLevel {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entry, ok := s.data[aboutId]
+	if !ok {
+		return shareFull
+	}
+	return shareLevelFor(entry, s.data[viewerId], viewerId)
+}
+
+// checkSend returns an error unless sharing settings let senderId send a
+// tab to targetId. Senders that are not browsers (e.g. the command line)
+// are only checked against the target.
+func (s *StateStore) checkSend(senderId, targetId string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if sender, ok := s.data[senderId]; ok && sender.Sharing == SharingPrivate {
+		return fmt.Errorf("%w: this browser is private", ErrSendNotAllowed)
+	}
+	target, ok := s.data[targetId]
+	if !ok {
+		return nil
+	}
+	if !target.receives() || target.hiddenFrom(senderId) {
+		return fmt.Errorf("%w: target does not receive tabs from this browser", ErrSendNotAllowed)
+	}
+	return nil
+}
+
+// SetSharing changes a browser's sharing mode and the browsers it is
+// hidden from. Returns false if the browser is unknown.
+func (s *StateStore) SetSharing(browserId, mode string, hiddenFrom []string) bool {
+	if mode == SharingShared {
+		mode = ""
+	}
+	s.mu.Lock()
+	entry, ok := s.data[browserId]
+	if ok {
+		entry.Sharing = mode
+		entry.HiddenFrom = nil
+		for _, id := range hiddenFrom {
+			if id != "" && id != browserId {
+				entry.HiddenFrom = appendUnique(entry.HiddenFrom, id)
+			}
+		}
+	}
+	s.mu.Unlock()
+	if ok {
+		s.DebouncedSave()
+	}
+	return ok
+}
+
+// SetSharing updates a browser's sharing settings and resends every
+// connected browser the state it may now see.
+func (s *Server) SetSharing(browserId, mode string, hiddenFrom []string) error {
+	if !validSharing(mode) {
+		return fmt.Errorf("unknown sharing mode %q", mode)
+	}
+	if !s.state.SetSharing(browserId, mode, hiddenFrom) {
+		return ErrUnknownBrowser
+	}
+	for id, conn := range s.reg.all() {
+		_ = conn.sendJSON(map[string]interface{}{
+			"type":     "full-state",
+			"browsers": s.state.BuildStateForClient(id),
+			"aliases":  s.aliases.All(),
+		})
+	}
+	if mode == "" {
+		mode = SharingShared
+	}
+	logger.Info("[Sharing] %s is now %s (hidden from %d browser(s))", browserId, mode, len(hiddenFrom))
+	return nil
+}
+
+// handleBrowserSharing serves POST /browsers/{id}/sharing
+// {"sharing": mode, "hiddenFrom": [ids]}.
+func (s *Server) handleBrowserSharing(w http.ResponseWriter, r *http.Request, browserId string) {
+	var req struct {
+		Sharing    string   `json:"sharing"`
+		HiddenFrom []string `json:"hiddenFrom"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.SetSharing(browserId, req.Sharing, req.HiddenFrom); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, ErrUnknownBrowser) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	data, _ := s.state.Get(browserId)
+	writeJSON(w, map[string]interface{}{"ok": true, "sharing": data.sharingMode(), "hiddenFrom": data.HiddenFrom})
+}
+
+// sharingMode returns b's sharing mode, spelling out the default.
+func (b *BrowserData) sharingMode() string {
+	if b.Sharing == "" {
+		return SharingShared
+	}
+	return b.Sharing
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestVisibleToViewer(t *testing.T) {
+	s := &Server{state: newTestState(map[string]*BrowserData{
+		"a":    {BrowserName: "Google Chrome"},
+		"b":    {BrowserName: "Firefox"},
+		"priv": {BrowserName: "Brave", Sharing: SharingPrivate},
+		"recv": {BrowserName: "Edge", Sharing: SharingReceiveOnly},
+		"hide": {BrowserName: "Vivaldi", HiddenFrom: []string{"a"}},
+	})}
+	stash := Stash{ID: "s", Name: "Reading"}
+	for _, from := range []string{"a", "b", "priv", "recv", "hide", ""} {
+		stash.Tabs = append(stash.Tabs, StashedTab{URL: "https://example.com/" + from, FromBrowserID: from})
+	}
+	workspace := func(ids ...string) Workspace {
+		ws := Workspace{ID: "w", Browsers: map[string]*WorkspaceBrowser{}}
+		for _, id := range ids {
+			ws.Browsers[id] = &WorkspaceBrowser{}
+		}
+		return ws
+	}
+
+	tests := []struct {
+		name      string
+		viewer    string
+		stashFrom []string
+		ws        Workspace
+		wsKept    []string // nil: the workspace is not shown
+	}{
+		{"shared browser", "a", []string{"", "a", "b"}, workspace("a", "priv"), []string{"a"}},
+		{"hidden-from sees the rest", "b", []string{"", "a", "b", "hide"}, workspace("hide", "recv"), []string{"hide"}},
+		{"receive-only sees its own", "recv", []string{"", "a", "b", "hide", "recv"}, workspace("recv"), []string{"recv"}},
+		{"private sees only its own", "priv", []string{"", "priv"}, workspace("a", "b"), nil},
+		{"unregistered", "", []string{"", "a", "b", "hide"}, workspace("priv", "recv"), nil},
+		{"empty workspace", "a", []string{"", "a", "b"}, workspace(), []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var from []string
+			for _, tab := range s.stashFor(stash, tt.viewer).Tabs {
+				from = append(from, tab.FromBrowserID)
+			}
+			sort.Strings(from)
+			if !reflect.DeepEqual(from, tt.stashFrom) {
+				t.Errorf("stash tabs from %v, want %v", from, tt.stashFrom)
+			}
+			if len(stash.Tabs) != 6 {
+				t.Fatalf("stashFor changed the stash")
+			}
+
+			ws, ok := s.workspaceFor(tt.ws, tt.viewer)
+			if ok != (tt.wsKept != nil) {
+				t.Fatalf("workspace shown = %v, want %v", ok, tt.wsKept != nil)
+			}
+			if ok {
+				if got := keys(ws.Browsers); !reflect.DeepEqual(got, tt.wsKept) {
+					t.Errorf("workspace browsers = %v, want %v", got, tt.wsKept)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,774 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/harshvasudeva/synctabs-companion/config"
+	"github.com/harshvasudeva/synctabs-companion/logger"
+	"github.com/harshvasudeva/synctabs-companion/schema"
+)
+
+const (
+	MaxStashNameLength = 100
+	MaxStashTabs       = 1000
+)
+
+var (
+	ErrUnknownStash   = errors.New("unknown stash")
+	ErrStashExists    = errors.New("a stash with that name already exists")
+	ErrBrowserOffline = errors.New("browser is not connected")
+)
+
+// StashedTab is a tab saved in a stash.
+type StashedTab struct {
+	URL           string `json:"url"`
+	Title         string `json:"title"`
+	FavIconURL    string `json:"favIconUrl,omitempty"`
+	Pinned        bool   `json:"pinned,omitempty"`
+	FromBrowserID string `json:"fromBrowserId,omitempty"`
+	AddedAt       string `json:"addedAt"`
+}
+
+// Stash is a named collection of tabs kept by the companion, independent
+// of any browser.
+type Stash struct {
+	ID        string       `json:"id"`
+	Name      string       `json:"name"`
+	Tabs      []StashedTab `json:"tabs"`
+	CreatedAt string       `json:"createdAt"`
+	UpdatedAt string       `json:"updatedAt"`
+}
+
+func (st *Stash) clone() Stash {
+	cp := *st
+	cp.Tabs = append([]StashedTab(nil), st.Tabs...)
+	return cp
+}
+
+// newID returns a random 16-character hex identifier.
+func newID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// StashStore holds stashes backed by stashes.json
+type StashStore struct {
+	mu     sync.RWMutex
+	saveMu sync.Mutex        // held across snapshot and write so saves land in order
+	data   map[string]*Stash // stashId -> stash
+	folder string
+	saveCh chan struct{}
+}
+
+// NewStashStore creates a StashStore and loads from disk.
+func NewStashStore(dataFolder string) (*StashStore, error) {
+	ss := &StashStore{
+		data:   make(map[string]*Stash),
+		folder: dataFolder,
+		saveCh: make(chan struct{}, 1),
+	}
+	if err := ss.Load(); err != nil {
+		return nil, err
+	}
+	go ss.startSaveWorker()
+	return ss, nil
+}
+
+func (ss *StashStore) stashesPath() string {
+	return filepath.Join(ss.folder, "stashes.json")
+}
+
+// Load reads stashes.json.
+func (ss *StashStore) Load() error {
+	if err := os.MkdirAll(ss.folder, 0755); err != nil {
+		return err
+	}
+
+	// A damaged file is quarantined and rebuilt from backups (see recovery.go)
+	entries, recovered, err := loadEntries(ss.stashesPath(), schema.Stashes)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if recovered {
+		ss.DebouncedSave()
+	}
+
+	raw := make(map[string]*Stash, len(entries))
+	for id, entry := range entries {
+		var v *Stash
+		if json.Unmarshal(entry, &v) == nil {
+			raw[id] = v
+		}
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	for id, stash := range raw {
+		if id == "" || stash == nil || stash.Name == "" {
+			continue
+		}
+		stash.ID = id
+		ss.data[id] = stash
+	}
+	return nil
+}
+
+// Save writes stashes.json atomically.
+func (ss *StashStore) Save() error {
+	ss.saveMu.Lock()
+	defer ss.saveMu.Unlock()
+
+	ss.mu.RLock()
+	snapshot := make(map[string]Stash, len(ss.data))
+	for id, stash := range ss.data {
+		snapshot[id] = stash.clone()
+	}
+	path := ss.stashesPath()
+	ss.mu.RUnlock()
+
+	return writeDataFile(path, schema.Stashes, snapshot, true)
+}
+
+// DebouncedSave triggers a save after 500ms.
+func (ss *StashStore) DebouncedSave() {
+	select {
+	case ss.saveCh <- struct{}{}:
+	default:
+	}
+}
+
+func (ss *StashStore) startSaveWorker() {
+	for range ss.saveCh {
+		time.Sleep(500 * time.Millisecond)
+		for {
+			select {
+			case <-ss.saveCh:
+			default:
+				goto save
+			}
+		}
+	save:
+		if err := ss.Save(); err != nil {
+			logger.Error("Stash save failed: %v", err)
+		}
+	}
+}
+
+// UpdateDataFolder points the store at a new folder and saves to it.
+// Existing files are copied beforehand by Server.MoveDataFolder.
+func (ss *StashStore) UpdateDataFolder(newFolder string) error {
+	ss.mu.Lock()
+	ss.folder = newFolder
+	ss.mu.Unlock()
+	return ss.Save()
+}
+
+// List returns every stash, most recently updated first.
+func (ss *StashStore) List() []Stash {
+	ss.mu.RLock()
+	out := make([]Stash, 0, len(ss.data))
+	for _, stash := range ss.data {
+		out = append(out, stash.clone())
+	}
+	ss.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].UpdatedAt > out[j].UpdatedAt })
+	return out
+}
+
+// Get returns a stash by ID.
+func (ss *StashStore) Get(id string) (Stash, bool) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	stash, ok := ss.data[id]
+	if !ok {
+		return Stash{}, false
+	}
+	return stash.clone(), true
+}
+
+// findByName returns the stash named name (case-insensitive). Caller holds mu.
+func (ss *StashStore) findByName(name string) *Stash {
+	for _, stash := range ss.data {
+		if strings.EqualFold(stash.Name, name) {
+			return stash
+		}
+	}
+	return nil
+}
+
+func validStashName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return name, errors.New("stash name required")
+	}
+	if utf8.RuneCountInString(name) > MaxStashNameLength {
+		return name, fmt.Errorf("stash name longer than %d characters", MaxStashNameLength)
+	}
+	return name, nil
+}
+
+// Create adds an empty stash.
+func (ss *StashStore) Create(name string) (Stash, error) {
+	name, err := validStashName(name)
+	if err != nil {
+		return Stash{}, err
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.findByName(name) != nil {
+		return Stash{}, ErrStashExists
+	}
+	now := time.Now().Format(time.RFC3339)
+	stash := &Stash{ID: newID(), Name: name, Tabs: []StashedTab{}, CreatedAt: now, UpdatedAt: now}
+	ss.data[stash.ID] = stash
+	ss.DebouncedSave()
+	return stash.clone(), nil
+}
+
+// Resolve returns the ID of the stash with the given ID or name, creating
+// a stash named name if neither exists.
+func (ss *StashStore) Resolve(id, name string) (string, error) {
+	if id != "" {
+		if _, ok := ss.Get(id); !ok {
+			return "", ErrUnknownStash
+		}
+		return id, nil
+	}
+	ss.mu.RLock()
+	existing := ss.findByName(strings.TrimSpace(name))
+	ss.mu.RUnlock()
+	if existing != nil {
+		return existing.ID, nil
+	}
+	stash, err := ss.Create(name)
+	return stash.ID, err
+}
+
+// Rename changes a stash's name.
+func (ss *StashStore) Rename(id, name string) (Stash, error) {
+	name, err := validStashName(name)
+	if err != nil {
+		return Stash{}, err
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	stash, ok := ss.data[id]
+	if !ok {
+		return Stash{}, ErrUnknownStash
+	}
+	if other := ss.findByName(name); other != nil && other.ID != id {
+		return Stash{}, ErrStashExists
+	}
+	stash.Name = name
+	stash.UpdatedAt = time.Now().Format(time.RFC3339)
+	ss.DebouncedSave()
+	return stash.clone(), nil
+}
+
+// Add appends tabs to a stash, skipping URLs it already holds.
+// Returns the updated stash and the number of tabs added.
+func (ss *StashStore) Add(id string, tabs []StashedTab) (Stash, int, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	stash, ok := ss.data[id]
+	if !ok {
+		return Stash{}, 0, ErrUnknownStash
+	}
+	have := make(map[string]bool, len(stash.Tabs))
+	for _, t := range stash.Tabs {
+		have[t.URL] = true
+	}
+	added := 0
+	for _, t := range tabs {
+		if have[t.URL] {
+			continue
+		}
+		if len(stash.Tabs) >= MaxStashTabs {
+			break
+		}
+		stash.Tabs = append(stash.Tabs, t)
+		have[t.URL] = true
+		added++
+	}
+	if added > 0 {
+		stash.UpdatedAt = time.Now().Format(time.RFC3339)
+		ss.DebouncedSave()
+	}
+	return stash.clone(), added, nil
+}
+
+// RemoveURL removes a tab from a stash by URL.
+func (ss *StashStore) RemoveURL(id, rawURL string) (Stash, bool, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	stash, ok := ss.data[id]
+	if !ok {
+		return Stash{}, false, ErrUnknownStash
+	}
+	kept := stash.Tabs[:0]
+	for _, t := range stash.Tabs {
+		if t.URL != rawURL {
+			kept = append(kept, t)
+		}
+	}
+	removed := len(kept) != len(stash.Tabs)
+	stash.Tabs = kept
+	if removed {
+		stash.UpdatedAt = time.Now().Format(time.RFC3339)
+		ss.DebouncedSave()
+	}
+	return stash.clone(), removed, nil
+}
+
+// Delete removes a stash.
+func (ss *StashStore) Delete(id string) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if _, ok := ss.data[id]; !ok {
+		return ErrUnknownStash
+	}
+	delete(ss.data, id)
+	ss.DebouncedSave()
+	return nil
+}
+
+// Search returns stashes whose name matches query (with all their tabs)
+// or that hold tabs whose title or URL matches (with only those tabs).
+// Matching is case-insensitive substring.
+func (ss *StashStore) Search(query string) []Stash {
+	q := strings.ToLower(strings.TrimSpace(query))
+	if q == "" {
+		return ss.List()
+	}
+	var out []Stash
+	for _, stash := range ss.List() {
+		if strings.Contains(strings.ToLower(stash.Name), q) {
+			out = append(out, stash)
+			continue
+		}
+		var hits []StashedTab
+		for _, t := range stash.Tabs {
+			if strings.Contains(strings.ToLower(t.Title), q) || strings.Contains(strings.ToLower(t.URL), q) {
+				hits = append(hits, t)
+			}
+		}
+		if len(hits) > 0 {
+			stash.Tabs = hits
+			out = append(out, stash)
+		}
+	}
+	return out
+}
+
+// MapFavicons rewrites every stashed tab's favicon URL with fn.
+func (ss *StashStore) MapFavicons(fn func(string) string) {
+	ss.mu.Lock()
+	changed := false
+	for _, stash := range ss.data {
+		for i := range stash.Tabs {
+			if u := fn(stash.Tabs[i].FavIconURL); u != stash.Tabs[i].FavIconURL {
+				stash.Tabs[i].FavIconURL = u
+				changed = true
+			}
+		}
+	}
+	ss.mu.Unlock()
+	if changed {
+		ss.DebouncedSave()
+	}
+}
+
+// ApplyPrivacy rewrites stashed tabs under the current rules.
+// Returns the IDs of stashes that changed.
+func (ss *StashStore) ApplyPrivacy() []string {
+	e := privacy()
+	ss.mu.Lock()
+	var changed []string
+	for id, stash := range ss.data {
+		kept := make([]StashedTab, 0, len(stash.Tabs))
+		diff := false
+		for _, t := range stash.Tabs {
+			if e.Blocked(t.URL) {
+				diff = true
+				continue
+			}
+			orig, origTitle := t.URL, t.Title
+			t.Title = e.Title(orig, t.Title)
+			t.URL = e.RedactURL(orig)
+			diff = diff || t.URL != orig || t.Title != origTitle
+			kept = append(kept, t)
+		}
+		if diff {
+			stash.Tabs = kept
+			changed = append(changed, id)
+		}
+	}
+	ss.mu.Unlock()
+	if len(changed) > 0 {
+		ss.DebouncedSave()
+	}
+	return changed
+}
+
+// ─── Server operations ────────────────────────────────────────────────
+
+// stashTabsFrom converts tabs to stash entries, dropping blocked URLs and
+// redacting the rest.
+func stashTabsFrom(browserId string, tabs []Tab) []StashedTab {
+	tabs, _ = privacy().ApplyTabs(tabs)
+	now := time.Now().Format(time.RFC3339)
+	out := make([]StashedTab, 0, len(tabs))
+	for _, t := range tabs {
+		out = append(out, StashedTab{
+			URL:           t.URL,
+			Title:         t.Title,
+			FavIconURL:    t.FavIconURL,
+			Pinned:        t.Pinned,
+			FromBrowserID: browserId,
+			AddedAt:       now,
+		})
+	}
+	return out
+}
+
+// broadcastStash sends stash to every browser as it may see it.
+func (s *Server) broadcastStash(stash Stash) {
+	s.reg.broadcastEach(func(viewerId string) interface{} {
+		return map[string]interface{}{
+			"type":  "stash-updated",
+			"stash": s.stashFor(stash, viewerId),
+		}
+	})
+}
+
+// stashFor returns stash as browser viewerId may see it: tabs stashed
+// from a browser whose tabs it may not see are left out.
+func (s *Server) stashFor(stash Stash, viewerId string) Stash {
+	visible := stash.clone()
+	visible.Tabs = visible.Tabs[:0]
+	for _, t := range stash.Tabs {
+		if t.FromBrowserID == "" || t.FromBrowserID == viewerId || s.state.visibility(t.FromBrowserID, viewerId) == shareFull {
+			visible.Tabs = append(visible.Tabs, t)
+		}
+	}
+	return visible
+}
+
+// visibleStashes applies stashFor to each of stashes.
+func (s *Server) visibleStashes(stashes []Stash, viewerId string) []Stash {
+	out := make([]Stash, len(stashes))
+	for i, st := range stashes {
+		out[i] = s.stashFor(st, viewerId)
+	}
+	return out
+}
+
+// StashBrowserTabs stashes tabs (by tab ID) from a browser's current state.
+// With move set the browser is told to close them, so it must be online;
+// only tabs the stash then holds are closed, so tabs refused by privacy
+// rules or beyond MaxStashTabs stay open.
+func (s *Server) StashBrowserTabs(stashId, stashName, browserId string, tabIds []int, move bool) (Stash, int, error) {
+	data, ok := s.state.Get(browserId)
+	if !ok {
+		return Stash{}, 0, ErrUnknownBrowser
+	}
+	conn, online := s.reg.get(browserId)
+	if move && !online {
+		return Stash{}, 0, ErrBrowserOffline
+	}
+
+	want := make(map[int]bool, len(tabIds))
+	for _, id := range tabIds {
+		want[id] = true
+	}
+	matched := 0
+	var picked []StashedTab
+	var pickedIds []int
+	for _, t := range data.Tabs {
+		if !want[t.ID] {
+			continue
+		}
+		matched++
+		// One at a time, so each stashed entry keeps its tab ID. A tab
+		// whose URL was redacted is stashed but never closed: its full URL
+		// would be lost.
+		for _, st := range stashTabsFrom(browserId, []Tab{t}) {
+			picked = append(picked, st)
+			if st.URL == t.URL {
+				pickedIds = append(pickedIds, t.ID)
+			} else {
+				pickedIds = append(pickedIds, -1)
+			}
+		}
+	}
+	if matched == 0 {
+		return Stash{}, 0, errors.New("no matching tabs")
+	}
+
+	id, err := s.stashes.Resolve(stashId, stashName)
+	if err != nil {
+		return Stash{}, 0, err
+	}
+	stash, added, err := s.stashes.Add(id, picked)
+	if err != nil {
+		return Stash{}, 0, err
+	}
+
+	mode := "copy"
+	if move {
+		// Close a tab only if its URL is in the stash now, added or already there
+		stored := make(map[string]bool, len(stash.Tabs))
+		for _, t := range stash.Tabs {
+			stored[t.URL] = true
+		}
+		var closing []int
+		for i, t := range picked {
+			if stored[t.URL] && pickedIds[i] >= 0 {
+				closing = append(closing, pickedIds[i])
+			}
+		}
+		if len(closing) > 0 {
+			_ = conn.sendJSON(map[string]interface{}{
+				"type":   "close-tabs",
+				"tabIds": closing,
+				"reason": "stashed",
+			})
+		}
+		mode = fmt.Sprintf("move, %d closed", len(closing))
+	}
+	s.broadcastStash(stash)
+	logger.Info("[Stash] %d tab(s) from %s → %q (%s)", added, browserId, stash.Name, mode)
+	return stash, added, nil
+}
+
+// StashURLs adds tabs given directly (e.g. over HTTP) to a stash.
+func (s *Server) StashURLs(stashId string, tabs []StashedTab) (Stash, int, error) {
+	var valid []Tab
+	for _, t := range tabs {
+		if isValidURL(t.URL) {
+			valid = append(valid, Tab{URL: truncate(t.URL, 2048), Title: truncate(t.Title, 500), FavIconURL: t.FavIconURL, Pinned: t.Pinned})
+		}
+	}
+	stashed := stashTabsFrom("", validateTabArray(valid, MaxStashTabs, config.IncognitoDrop))
+	for i := range stashed {
+		stashed[i].FavIconURL = s.favicons.Intern(stashed[i].FavIconURL)
+	}
+	stash, added, err := s.stashes.Add(stashId, stashed)
+	if err != nil {
+		return stash, added, err
+	}
+	s.broadcastStash(stash)
+	return stash, added, nil
+}
+
+// OpenStash sends a stash's tabs to a browser (queued if it is offline).
+// With remove set the stash is deleted afterwards.
+func (s *Server) OpenStash(stashId, browserId string, remove bool) (string, error) {
+	stash, ok := s.stashes.Get(stashId)
+	if !ok {
+		return "", ErrUnknownStash
+	}
+	if _, ok := s.state.Get(browserId); !ok {
+		return "", ErrUnknownBrowser
+	}
+	now := time.Now().Format(time.RFC3339)
+	tabs := make([]PendingTab, 0, len(stash.Tabs))
+	for _, t := range stash.Tabs {
+		tabs = append(tabs, PendingTab{
+			URL:               t.URL,
+			Title:             t.Title,
+			FavIconURL:        t.FavIconURL,
+			SenderBrowserID:   "stash:" + stash.ID,
+			SenderBrowserName: stash.Name,
+			SentAt:            now,
+			Pinned:            t.Pinned,
+		})
+	}
+	res := s.DeliverTabs(browserId, tabs)
+	status := res.Status
+	if res.Refused > 0 {
+		return status, fmt.Errorf("%d tab(s) refused by the browser's incoming rules", res.Refused)
+	}
+	if res.Rejected > 0 {
+		return status, fmt.Errorf("%d tab(s) could not be queued", res.Rejected)
+	}
+	if remove {
+		if err := s.DeleteStash(stashId); err != nil {
+			return status, err
+		}
+	}
+	logger.Info("[Stash] Opened %q (%d tab(s)) in %s (%s)", stash.Name, len(tabs), browserId, status)
+	return status, nil
+}
+
+// DeleteStash removes a stash and tells every browser.
+func (s *Server) DeleteStash(stashId string) error {
+	if err := s.stashes.Delete(stashId); err != nil {
+		return err
+	}
+	s.reg.broadcast("", map[string]interface{}{
+		"type":    "stash-deleted",
+		"stashId": stashId,
+	})
+	return nil
+}
+
+// stashError maps stash errors to HTTP status codes.
+func stashError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrUnknownStash), errors.Is(err, ErrUnknownBrowser):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrStashExists), errors.Is(err, ErrBrowserOffline):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// handleStashes responds to GET /stashes[?q=] and POST /stashes {"name"}.
+func (s *Server) handleStashes(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, s.stashes.Search(r.URL.Query().Get("q")))
+
+	case http.MethodPost:
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		stash, err := s.stashes.Create(req.Name)
+		if err != nil {
+			stashError(w, err)
+			return
+		}
+		s.broadcastStash(stash)
+		writeJSON(w, map[string]interface{}{"ok": true, "stash": stash})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleStash serves /stashes/{id}[/tabs|/open]:
+//
+//	GET    /stashes/{id}
+//	POST   /stashes/{id}         {"name"} — rename
+//	DELETE /stashes/{id}
+//	POST   /stashes/{id}/tabs    {"browserId", "tabIds", "mode": "copy"|"move"} or {"tabs": [{url, title}]}
+//	DELETE /stashes/{id}/tabs?url=...
+//	POST   /stashes/{id}/open    {"browserId", "remove"}
+func (s *Server) handleStash(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/stashes/"), "/"), "/")
+	id := parts[0]
+	action := ""
+	if len(parts) > 1 {
+		action = parts[1]
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		stash, ok := s.stashes.Get(id)
+		if !ok {
+			http.Error(w, ErrUnknownStash.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, stash)
+
+	case action == "" && r.Method == http.MethodPost:
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		stash, err := s.stashes.Rename(id, req.Name)
+		if err != nil {
+			stashError(w, err)
+			return
+		}
+		s.broadcastStash(stash)
+		writeJSON(w, map[string]interface{}{"ok": true, "stash": stash})
+
+	case action == "" && r.Method == http.MethodDelete:
+		if err := s.DeleteStash(id); err != nil {
+			stashError(w, err)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"ok": true, "stashId": id})
+
+	case action == "tabs" && r.Method == http.MethodPost:
+		var req struct {
+			BrowserID string       `json:"browserId"`
+			TabIDs    []int        `json:"tabIds"`
+			Mode      string       `json:"mode"`
+			Tabs      []StashedTab `json:"tabs"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		var stash Stash
+		var added int
+		var err error
+		if req.BrowserID != "" {
+			stash, added, err = s.StashBrowserTabs(id, "", req.BrowserID, req.TabIDs, req.Mode == "move")
+		} else {
+			stash, added, err = s.StashURLs(id, req.Tabs)
+		}
+		if err != nil {
+			stashError(w, err)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"ok": true, "added": added, "stash": stash})
+
+	case action == "tabs" && r.Method == http.MethodDelete:
+		stash, removed, err := s.stashes.RemoveURL(id, r.URL.Query().Get("url"))
+		if err != nil {
+			stashError(w, err)
+			return
+		}
+		if removed {
+			s.broadcastStash(stash)
+		}
+		writeJSON(w, map[string]interface{}{"ok": true, "removed": removed, "stash": stash})
+
+	case action == "open" && r.Method == http.MethodPost:
+		var req struct {
+			BrowserID string `json:"browserId"`
+			Remove    bool   `json:"remove"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		status, err := s.OpenStash(id, req.BrowserID, req.Remove)
+		if err != nil {
+			stashError(w, err)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"ok": true, "status": status})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -4,11 +4,13 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/harshvasudeva/synctabs-companion/config"
 	"github.com/harshvasudeva/synctabs-companion/logger"
+	"github.com/harshvasudeva/synctabs-companion/schema"
 )
 
 // Tab mirrors the validated tab shape from server.js
@@ -19,6 +21,7 @@ type Tab struct {
 	FavIconURL   string `json:"favIconUrl"`
 	Pinned       bool   `json:"pinned"`
 	WindowID     int    `json:"windowId"`
+	WindowName   string `json:"windowName,omitempty"` // set by the user in the extension (see mirror.go)
 	Active       bool   `json:"active"`
 	LastAccessed float64 `json:"lastAccessed"`
 	Incognito    bool   `json:"incognito"`
@@ -30,11 +33,35 @@ type BrowserData struct {
 	Tabs        []Tab  `json:"tabs"`
 	LastSeen    string `json:"lastSeen"`
 	Online      bool   `json:"online"`
+	NeverForget bool   `json:"neverForget,omitempty"`
+	Virtual     bool   `json:"virtual,omitempty"` // created by import, never connects
+
+	// Sync direction and visibility (see sharing.go)
+	Sharing    string   `json:"sharing,omitempty"`
+	HiddenFrom []string `json:"hiddenFrom,omitempty"`
+
+	// Identity (see identity.go)
+	ProfileLabel string   `json:"profileLabel,omitempty"`
+	Fingerprint  string   `json:"installFingerprint,omitempty"` // never sent to other browsers, see public
+	MergedIDs    []string `json:"mergedIds,omitempty"`
+	DistinctFrom []string `json:"distinctFrom,omitempty"`
+	// Identity keys (see identityKey) of installs split from this one
+	DistinctIdentities []string `json:"distinctIdentities,omitempty"`
+}
+
+// isExpired reports whether an offline, unpinned entry was last seen before cutoff.
+func (b *BrowserData) isExpired(cutoff time.Time) bool {
+	if b.Online || b.NeverForget || b.LastSeen == "" {
+		return false
+	}
+	t, err := time.Parse(time.RFC3339, b.LastSeen)
+	return err == nil && t.Before(cutoff)
 }
 
 // StateStore holds in-memory browser state backed by tabs.json
 type StateStore struct {
 	mu     sync.RWMutex
+	saveMu sync.Mutex // held across snapshot and write so saves land in order
 	data   map[string]*BrowserData
 	folder string
 	saveCh chan struct{}
@@ -64,25 +91,24 @@ func (s *StateStore) Load() error {
 		return err
 	}
 
-	data, err := os.ReadFile(s.tabsPath())
+	// Parse as map[string]BrowserData; a damaged file is quarantined and
+	// rebuilt from backups (see recovery.go)
+	raw, recovered, err := loadEntries(s.tabsPath(), schema.Tabs)
 	if os.IsNotExist(err) {
 		return nil // empty store on first run
 	}
 	if err != nil {
 		return err
 	}
-
-	// Parse as map[string]BrowserData
-	raw := make(map[string]json.RawMessage)
-	if err := json.Unmarshal(data, &raw); err != nil {
-		logger.Warn("tabs.json corrupt, starting fresh: %v", err)
-		return nil
+	if recovered {
+		s.DebouncedSave()
 	}
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	cutoff := time.Now().AddDate(0, 0, -config.StaleDays)
+	cutoff := config.RetentionCutoff()
+	policy := config.Get().IncognitoPolicy
 
 	for id, rawEntry := range raw {
 		// Skip null/invalid IDs
@@ -100,18 +126,20 @@ func (s *StateStore) Load() error {
 			continue
 		}
 
+		// Mark all as offline on load
+		entry.Online = false
+
+		// Incognito tabs only survive a restart under the "sync" policy
+		if !persistsIncognito(policy) {
+			entry.Tabs, _ = withoutIncognito(entry.Tabs)
+		}
+
 		// Skip stale entries
-		if entry.LastSeen != "" {
-			if t, err := time.Parse(time.RFC3339, entry.LastSeen); err == nil {
-				if t.Before(cutoff) {
-					logger.Debug("Removing stale entry: %s (%s)", entry.BrowserName, id)
-					continue
-				}
-			}
+		if entry.isExpired(cutoff) {
+			logger.Debug("Removing stale entry: %s (%s)", entry.BrowserName, id)
+			continue
 		}
 
-		// Mark all as offline on load
-		entry.Online = false
 		s.data[id] = &entry
 	}
 
@@ -119,30 +147,26 @@ func (s *StateStore) Load() error {
 	return nil
 }
 
-// Save writes tabs.json atomically.
+// Save writes tabs.json atomically. Incognito tabs are left out unless
+// the incognito policy is "sync".
 func (s *StateStore) Save() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	persistIncognito := persistsIncognito(config.Get().IncognitoPolicy)
+
 	s.mu.RLock()
 	snapshot := make(map[string]*BrowserData, len(s.data))
 	for k, v := range s.data {
 		cp := *v
+		if !persistIncognito {
+			cp.Tabs, _ = withoutIncognito(cp.Tabs)
+		}
 		snapshot[k] = &cp
 	}
 	s.mu.RUnlock()
 
-	data, err := json.MarshalIndent(snapshot, "", "  ")
-	if err != nil {
-		return err
-	}
-
-	if err := os.MkdirAll(s.folder, 0755); err != nil {
-		return err
-	}
-
-	tmp := s.tabsPath() + ".tmp"
-	if err := os.WriteFile(tmp, data, 0644); err != nil {
-		return err
-	}
-	return os.Rename(tmp, s.tabsPath())
+	return writeDataFile(s.tabsPath(), schema.Tabs, snapshot, true)
 }
 
 // DebouncedSave triggers a save after 500ms.
@@ -172,17 +196,21 @@ func (s *StateStore) startSaveWorker() {
 }
 
 // Register handles the 'register' WS message:
-//  1. Deduplicates stale entries with same browserName (exact port of server.js lines 214-225)
-//  2. Creates or updates the entry
-//  3. Returns BuildStateForClient(browserId) for the full-state response
-func (s *StateStore) Register(browserId, browserName string) map[string]BrowserData {
+//  1. Replaces an offline entry only on a proven reinstall (see findReinstall)
+//  2. Creates or updates the entry with its identity
+//  3. Returns BuildStateForClient(browserId) for the full-state response,
+//     plus the ID of the entry it replaced ("" if none)
+func (s *StateStore) Register(browserId, browserName string, ident BrowserIdentity) (map[string]BrowserData, string) {
 	s.mu.Lock()
 
-	// Dedup: find offline entries with same browserName, remove them
-	for id, entry := range s.data {
-		if id != browserId && entry.BrowserName == browserName && !entry.Online {
-			logger.Debug("Deduplicating stale entry for %s (old id: %s)", browserName, id)
-			delete(s.data, id)
+	replaced := ""
+	var inherited *BrowserData
+	if _, known := s.data[browserId]; !known {
+		if oldId := s.findReinstall(browserId, browserName, ident); oldId != "" {
+			logger.Info("Reinstall detected for %s (old id: %s → %s)", browserName, oldId, browserId)
+			inherited = s.data[oldId]
+			delete(s.data, oldId)
+			replaced = oldId
 		}
 	}
 
@@ -191,18 +219,24 @@ func (s *StateStore) Register(browserId, browserName string) map[string]BrowserD
 		existing.BrowserName = browserName
 		existing.Online = true
 		existing.LastSeen = now
+		existing.applyIdentity(ident)
 	} else {
-		s.data[browserId] = &BrowserData{
+		entry := &BrowserData{
 			BrowserName: browserName,
 			Tabs:        []Tab{},
 			LastSeen:    now,
 			Online:      true,
 		}
+		if inherited != nil {
+			entry.inheritFrom(replaced, inherited)
+		}
+		entry.applyIdentity(ident)
+		s.data[browserId] = entry
 	}
 
 	s.mu.Unlock()
 	s.DebouncedSave()
-	return s.BuildStateForClient(browserId)
+	return s.BuildStateForClient(browserId), replaced
 }
 
 // UpdateTabs updates the tab list for a browser.
@@ -216,28 +250,94 @@ func (s *StateStore) UpdateTabs(browserId string, tabs []Tab) {
 	s.DebouncedSave()
 }
 
-// SetOffline marks a browser as offline.
-func (s *StateStore) SetOffline(browserId string) {
+// SetOffline marks a browser as offline. Unless the incognito policy is
+// "sync", its incognito tabs are dropped; returns how many were removed.
+func (s *StateStore) SetOffline(browserId string) int {
+	persistIncognito := persistsIncognito(config.Get().IncognitoPolicy)
+
 	s.mu.Lock()
+	removed := 0
 	if entry, ok := s.data[browserId]; ok {
 		entry.Online = false
 		entry.LastSeen = time.Now().Format(time.RFC3339)
+		if !persistIncognito {
+			entry.Tabs, removed = withoutIncognito(entry.Tabs)
+		}
 	}
 	s.mu.Unlock()
 	s.DebouncedSave()
+	return removed
 }
 
-// BuildStateForClient returns all entries except excludeId, filtering nulls.
+// ExpireStale removes offline, unpinned entries last seen before cutoff
+// and returns their IDs.
+func (s *StateStore) ExpireStale(cutoff time.Time) []string {
+	s.mu.Lock()
+	var removed []string
+	for id, entry := range s.data {
+		if entry.isExpired(cutoff) {
+			logger.Debug("Removing stale entry: %s (%s)", entry.BrowserName, id)
+			delete(s.data, id)
+			removed = append(removed, id)
+		}
+	}
+	s.mu.Unlock()
+	if len(removed) > 0 {
+		s.DebouncedSave()
+	}
+	return removed
+}
+
+// SetNeverForget pins or unpins a browser entry against expiry.
+// Returns false if the browser is unknown.
+func (s *StateStore) SetNeverForget(browserId string, neverForget bool) bool {
+	s.mu.Lock()
+	entry, ok := s.data[browserId]
+	if ok {
+		entry.NeverForget = neverForget
+	}
+	s.mu.Unlock()
+	if ok {
+		s.DebouncedSave()
+	}
+	return ok
+}
+
+// Forget removes a browser entry. Returns false if the browser is unknown.
+func (s *StateStore) Forget(browserId string) bool {
+	s.mu.Lock()
+	_, ok := s.data[browserId]
+	delete(s.data, browserId)
+	s.mu.Unlock()
+	if ok {
+		s.DebouncedSave()
+	}
+	return ok
+}
+
+// BuildStateForClient returns all entries except excludeId, filtering nulls
+// and applying sharing settings: entries hidden from excludeId are left
+// out and entries it may not see the tabs of are sent without tabs.
 func (s *StateStore) BuildStateForClient(excludeId string) map[string]BrowserData {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	viewer := s.data[excludeId]
 	result := make(map[string]BrowserData)
 	for id, entry := range s.data {
 		if id == "" || id == "null" || id == excludeId {
 			continue
 		}
-		result[id] = *entry
+		switch shareLevelFor(entry, viewer, excludeId) {
+		case shareNone:
+			continue
+		case sharePresence:
+			cp := entry.public()
+			cp.Tabs = []Tab{}
+			result[id] = cp
+		default:
+			result[id] = entry.public()
+		}
 	}
 	return result
 }
@@ -265,33 +365,21 @@ func (s *StateStore) GetAll() map[string]BrowserData {
 	return result
 }
 
-// UpdateDataFolder moves the store to a new folder path.
+// UpdateDataFolder points the store at a new folder and saves to it.
+// Existing files are copied beforehand by Server.MoveDataFolder.
 func (s *StateStore) UpdateDataFolder(newFolder string) error {
 	s.mu.Lock()
-	oldFolder := s.folder
 	s.folder = newFolder
 	s.mu.Unlock()
-
-	if err := os.MkdirAll(newFolder, 0755); err != nil {
-		return err
-	}
-
-	// Move tabs.json to new location
-	oldPath := filepath.Join(oldFolder, "tabs.json")
-	newPath := filepath.Join(newFolder, "tabs.json")
-	if _, err := os.Stat(oldPath); err == nil {
-		if err := os.Rename(oldPath, newPath); err != nil {
-			// If rename fails (cross-device), copy then delete
-			logger.Warn("Could not move tabs.json: %v (will save fresh)", err)
-		}
-	}
-
-	// Save current state to new location
 	return s.Save()
 }
 
-// validateTabArray mirrors server.js validateTabArray()
-func validateTabArray(tabs []Tab, maxTabs int) []Tab {
+// validateTabArray mirrors server.js validateTabArray(), and drops incognito
+// tabs when the incognito policy is "drop".
+func validateTabArray(tabs []Tab, maxTabs int, incognitoPolicy string) []Tab {
+	if !keepsIncognito(incognitoPolicy) {
+		tabs, _ = withoutIncognito(tabs)
+	}
 	if len(tabs) > maxTabs {
 		tabs = tabs[:maxTabs]
 	}
@@ -303,8 +391,11 @@ func validateTabArray(tabs []Tab, maxTabs int) []Tab {
 		if len(tabs[i].Title) > 500 {
 			tabs[i].Title = tabs[i].Title[:500]
 		}
-		if len(tabs[i].FavIconURL) > 2048 {
-			tabs[i].FavIconURL = tabs[i].FavIconURL[:2048]
+		tabs[i].WindowName = truncate(tabs[i].WindowName, 100)
+		// A truncated URL would be useless, so drop anything too long.
+		// Inline favicons are moved to the favicon cache after this.
+		if len(tabs[i].FavIconURL) > 2048 && !strings.HasPrefix(tabs[i].FavIconURL, "data:") {
+			tabs[i].FavIconURL = ""
 		}
 		if tabs[i].Title == "" {
 			tabs[i].Title = "New Tab"
@@ -0,0 +1,505 @@
+package server
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/harshvasudeva/synctabs-companion/config"
+	"github.com/harshvasudeva/synctabs-companion/logger"
+	"github.com/harshvasudeva/synctabs-companion/schema"
+)
+
+const (
+	StatsSampleInterval = 5 * time.Minute
+	StatsTopDomains     = 20
+)
+
+// statsTier describes one resolution level of the time series.
+// Samples older than Keep are averaged into buckets of the next tier.
+type statsTier struct {
+	Name       string
+	Resolution time.Duration
+	Keep       time.Duration
+}
+
+var statsTiers = []statsTier{
+	{Name: "raw", Resolution: StatsSampleInterval, Keep: 48 * time.Hour},
+	{Name: "hour", Resolution: time.Hour, Keep: 30 * 24 * time.Hour},
+	{Name: "day", Resolution: 24 * time.Hour, Keep: 2 * 365 * 24 * time.Hour},
+}
+
+// StatsSample is one point of the tab-count time series.
+// Values are averages when the sample was produced by downsampling.
+type StatsSample struct {
+	T        int64              `json:"t"` // unix seconds (bucket start)
+	N        int                `json:"n"` // raw samples folded into this point
+	Total    float64            `json:"total"`
+	Browsers map[string]float64 `json:"browsers"`
+	Windows  map[string]float64 `json:"windows"` // "browserId/windowId"
+	Domains  map[string]float64 `json:"domains"` // top StatsTopDomains only
+
+	// Last-known tab counts of browsers that were offline when sampled;
+	// not included in Total, Browsers, Windows or Domains
+	Offline map[string]float64 `json:"offline,omitempty"`
+}
+
+// copy returns s with its own maps, safe to hand out while the store
+// keeps changing.
+func (s StatsSample) copy() StatsSample {
+	out := s
+	out.Browsers = copyCounts(s.Browsers)
+	out.Windows = copyCounts(s.Windows)
+	out.Domains = copyCounts(s.Domains)
+	out.Offline = copyCounts(s.Offline)
+	return out
+}
+
+func copyCounts(m map[string]float64) map[string]float64 {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]float64, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+// StatsStore records periodic tab-count samples backed by stats.json.
+type StatsStore struct {
+	mu     sync.RWMutex
+	saveMu sync.Mutex               // held across snapshot and write so saves land in order
+	tiers  map[string][]StatsSample // tier name -> samples, oldest first
+	folder string
+}
+
+// NewStatsStore creates a StatsStore, loads from disk and starts sampling state.
+func NewStatsStore(dataFolder string, state *StateStore) (*StatsStore, error) {
+	st := &StatsStore{
+		tiers:  make(map[string][]StatsSample),
+		folder: dataFolder,
+	}
+	if err := st.Load(); err != nil {
+		return nil, err
+	}
+	go st.startSampler(state)
+	return st, nil
+}
+
+func (st *StatsStore) statsPath() string {
+	return filepath.Join(st.folder, "stats.json")
+}
+
+// Load reads stats.json.
+func (st *StatsStore) Load() error {
+	if err := os.MkdirAll(st.folder, 0755); err != nil {
+		return err
+	}
+
+	// A damaged file is quarantined and rebuilt from backups (see recovery.go)
+	entries, recovered, err := loadEntries(st.statsPath(), schema.Stats)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	raw := make(map[string][]StatsSample, len(entries))
+	for id, entry := range entries {
+		var v []StatsSample
+		if json.Unmarshal(entry, &v) == nil {
+			raw[id] = v
+		}
+	}
+
+	st.mu.Lock()
+	for _, tier := range statsTiers {
+		st.tiers[tier.Name] = raw[tier.Name]
+	}
+	st.mu.Unlock()
+
+	if recovered {
+		if err := st.Save(); err != nil {
+			logger.Error("Stats save failed: %v", err)
+		}
+	}
+	return nil
+}
+
+// Save writes stats.json atomically. The file is written without
+// indentation to keep the time series compact.
+func (st *StatsStore) Save() error {
+	st.saveMu.Lock()
+	defer st.saveMu.Unlock()
+
+	st.mu.RLock()
+	data, err := json.Marshal(st.tiers)
+	folder := st.folder
+	st.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	return writeDataFile(filepath.Join(folder, "stats.json"), schema.Stats, json.RawMessage(data), false)
+}
+
+// startSampler records and saves a sample every StatsSampleInterval.
+func (st *StatsStore) startSampler(state *StateStore) {
+	ticker := time.NewTicker(StatsSampleInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		st.Record(state, time.Now())
+		if err := st.Save(); err != nil {
+			logger.Error("Stats save failed: %v", err)
+		}
+	}
+}
+
+// Record takes one sample of the current state and compacts older tiers.
+func (st *StatsStore) Record(state *StateStore, now time.Time) {
+	sample := takeSample(state.GetAll(), now, persistsIncognito(config.Get().IncognitoPolicy))
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.tiers[statsTiers[0].Name] = append(st.tiers[statsTiers[0].Name], sample)
+	st.compact(now)
+}
+
+// takeSample counts the tabs of connected browsers per browser, per window
+// and per domain; offline browsers' last-known counts go to Offline.
+// Incognito tabs are counted but their domains are only recorded if
+// withIncognito is set.
+func takeSample(all map[string]BrowserData, now time.Time, withIncognito bool) StatsSample {
+	sample := StatsSample{
+		T:        now.Unix(),
+		N:        1,
+		Browsers: make(map[string]float64, len(all)),
+		Windows:  make(map[string]float64),
+		Domains:  make(map[string]float64),
+	}
+	for id, data := range all {
+		if !data.Online {
+			if len(data.Tabs) > 0 {
+				if sample.Offline == nil {
+					sample.Offline = make(map[string]float64)
+				}
+				sample.Offline[id] = float64(len(data.Tabs))
+			}
+			continue
+		}
+		sample.Browsers[id] = float64(len(data.Tabs))
+		sample.Total += float64(len(data.Tabs))
+		for _, tab := range data.Tabs {
+			sample.Windows[id+"/"+strconv.Itoa(tab.WindowID)]++
+			if tab.Incognito && !withIncognito {
+				continue
+			}
+			if d := domainOf(tab.URL); d != "" {
+				sample.Domains[d]++
+			}
+		}
+	}
+	sample.Domains = topN(sample.Domains, StatsTopDomains)
+	return sample
+}
+
+// compact moves samples past each tier's retention into the next tier,
+// averaging them into buckets of the next tier's resolution. Caller holds st.mu.
+func (st *StatsStore) compact(now time.Time) {
+	for i, tier := range statsTiers {
+		cutoff := now.Add(-tier.Keep).Unix()
+		samples := st.tiers[tier.Name]
+
+		split := sort.Search(len(samples), func(j int) bool { return samples[j].T >= cutoff })
+		if split == 0 {
+			continue
+		}
+		expired := samples[:split]
+		st.tiers[tier.Name] = append([]StatsSample(nil), samples[split:]...)
+
+		if i+1 >= len(statsTiers) {
+			continue // oldest tier: drop
+		}
+		next := statsTiers[i+1]
+		st.tiers[next.Name] = mergeSamples(st.tiers[next.Name], downsample(expired, next.Resolution))
+	}
+}
+
+// downsample averages samples into buckets of the given resolution.
+func downsample(samples []StatsSample, res time.Duration) []StatsSample {
+	step := int64(res / time.Second)
+	buckets := make(map[int64][]StatsSample)
+	for _, s := range samples {
+		key := s.T - s.T%step
+		buckets[key] = append(buckets[key], s)
+	}
+	out := make([]StatsSample, 0, len(buckets))
+	for key, group := range buckets {
+		avg := averageSamples(group)
+		avg.T = key
+		out = append(out, avg)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].T < out[j].T })
+	return out
+}
+
+// mergeSamples folds incoming buckets into existing, combining points that
+// share a timestamp (weighted by N).
+func mergeSamples(existing, incoming []StatsSample) []StatsSample {
+	byT := make(map[int64]int, len(existing))
+	for i, s := range existing {
+		byT[s.T] = i
+	}
+	for _, s := range incoming {
+		if i, ok := byT[s.T]; ok {
+			merged := averageSamples([]StatsSample{existing[i], s})
+			merged.T = s.T
+			existing[i] = merged
+			continue
+		}
+		byT[s.T] = len(existing)
+		existing = append(existing, s)
+	}
+	sort.Slice(existing, func(i, j int) bool { return existing[i].T < existing[j].T })
+	return existing
+}
+
+// averageSamples returns the N-weighted mean of a group of samples.
+func averageSamples(group []StatsSample) StatsSample {
+	out := StatsSample{
+		Browsers: make(map[string]float64),
+		Windows:  make(map[string]float64),
+		Domains:  make(map[string]float64),
+		Offline:  make(map[string]float64),
+	}
+	for _, s := range group {
+		w := float64(s.N)
+		if w == 0 {
+			w = 1
+		}
+		out.N += int(w)
+		out.Total += s.Total * w
+		for k, v := range s.Browsers {
+			out.Browsers[k] += v * w
+		}
+		for k, v := range s.Windows {
+			out.Windows[k] += v * w
+		}
+		for k, v := range s.Domains {
+			out.Domains[k] += v * w
+		}
+		for k, v := range s.Offline {
+			out.Offline[k] += v * w
+		}
+	}
+	n := float64(out.N)
+	out.Total = round1(out.Total / n)
+	for _, m := range []map[string]float64{out.Browsers, out.Windows, out.Domains, out.Offline} {
+		for k, v := range m {
+			m[k] = round1(v / n)
+		}
+	}
+	out.Domains = topN(out.Domains, StatsTopDomains)
+	if len(out.Offline) == 0 {
+		out.Offline = nil
+	}
+	return out
+}
+
+// Query returns copies of all points newer than since across tiers,
+// oldest first, optionally restricted to a single tier.
+func (st *StatsStore) Query(since time.Time, tierName string) []StatsSample {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	cutoff := since.Unix()
+	out := make([]StatsSample, 0)
+	for i := len(statsTiers) - 1; i >= 0; i-- {
+		tier := statsTiers[i]
+		if tierName != "" && tierName != tier.Name {
+			continue
+		}
+		for _, s := range st.tiers[tier.Name] {
+			if s.T >= cutoff {
+				out = append(out, s.copy())
+			}
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].T < out[j].T })
+	return out
+}
+
+// TopDomains returns the average tab count per domain over the given points.
+func TopDomains(samples []StatsSample, n int) []DomainCount {
+	sum := make(map[string]float64)
+	weight := 0.0
+	for _, s := range samples {
+		w := float64(s.N)
+		weight += w
+		for d, v := range s.Domains {
+			sum[d] += v * w
+		}
+	}
+	out := make([]DomainCount, 0, len(sum))
+	for d, v := range sum {
+		out = append(out, DomainCount{Domain: d, Tabs: round1(v / weight)})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Tabs != out[j].Tabs {
+			return out[i].Tabs > out[j].Tabs
+		}
+		return out[i].Domain < out[j].Domain
+	})
+	if len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+// DomainCount is one entry of a domain leaderboard.
+type DomainCount struct {
+	Domain string  `json:"domain"`
+	Tabs   float64 `json:"tabs"`
+}
+
+// Forget removes a browser's series from every stored sample.
+// Domain counts are not tracked per browser and are kept.
+func (st *StatsStore) Forget(browserId string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, samples := range st.tiers {
+		for i := range samples {
+			s := &samples[i]
+			s.Total = round1(s.Total - s.Browsers[browserId])
+			delete(s.Browsers, browserId)
+			delete(s.Offline, browserId)
+			for k := range s.Windows {
+				if strings.HasPrefix(k, browserId+"/") {
+					delete(s.Windows, k)
+				}
+			}
+		}
+	}
+}
+
+// UpdateDataFolder points the store at a new folder and saves to it.
+// Existing files are copied beforehand by Server.MoveDataFolder.
+func (st *StatsStore) UpdateDataFolder(newFolder string) error {
+	st.mu.Lock()
+	st.folder = newFolder
+	st.mu.Unlock()
+	return st.Save()
+}
+
+// domainOf returns the lowercased host of rawURL without a leading "www.".
+func domainOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// topN keeps the n largest entries of m.
+func topN(m map[string]float64, n int) map[string]float64 {
+	if len(m) <= n {
+		return m
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if m[keys[i]] != m[keys[j]] {
+			return m[keys[i]] > m[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	out := make(map[string]float64, n)
+	for _, k := range keys[:n] {
+		out[k] = m[k]
+	}
+	return out
+}
+
+func round1(v float64) float64 {
+	return math.Round(v*10) / 10
+}
+
+// handleStats responds to GET /stats?days=N[&tier=raw|hour|day][&browser=id]
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	days := 7
+	if v := q.Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid days", http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+	tier := q.Get("tier")
+	if tier != "" {
+		known := false
+		for _, t := range statsTiers {
+			known = known || t.Name == tier
+		}
+		if !known {
+			http.Error(w, "Unknown tier", http.StatusBadRequest)
+			return
+		}
+	}
+
+	points := s.stats.Query(time.Now().AddDate(0, 0, -days), tier)
+	if browserId := q.Get("browser"); browserId != "" {
+		for i := range points {
+			points[i] = filterSampleByBrowser(points[i], browserId)
+		}
+	}
+
+	names := make(map[string]string)
+	for id, data := range s.state.GetAll() {
+		names[id] = data.BrowserName
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"intervalSeconds": int(StatsSampleInterval / time.Second),
+		"points":          points,
+		"topDomains":      TopDomains(points, StatsTopDomains),
+		"browserNames":    names,
+	})
+}
+
+// filterSampleByBrowser narrows a sample's browser and window series to one browser.
+// Domain counts are not tracked per browser and are left unchanged.
+func filterSampleByBrowser(s StatsSample, browserId string) StatsSample {
+	out := s
+	out.Browsers = map[string]float64{browserId: s.Browsers[browserId]}
+	out.Total = s.Browsers[browserId]
+	out.Offline = nil
+	if v, ok := s.Offline[browserId]; ok {
+		out.Offline = map[string]float64{browserId: v}
+	}
+	out.Windows = make(map[string]float64)
+	for k, v := range s.Windows {
+		if strings.HasPrefix(k, browserId+"/") {
+			out.Windows[k] = v
+		}
+	}
+	return out
+}
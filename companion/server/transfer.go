@@ -0,0 +1,513 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/harshvasudeva/synctabs-companion/config"
+	"github.com/harshvasudeva/synctabs-companion/logger"
+)
+
+// Export/import formats
+const (
+	FormatHTML     = "html"     // Netscape bookmark file
+	FormatMarkdown = "markdown" // "- [title](url)" lists under headings
+	FormatURLs     = "urls"     // one URL per line
+	FormatCSV      = "csv"
+	FormatJSON     = "json"   // versioned SyncTabs archive
+	FormatOneTab   = "onetab" // "url | title" lines, blank line between groups (import only)
+)
+
+const (
+	ArchiveFormat  = "synctabs-export"
+	ArchiveVersion = 1
+	MaxImportSize  = 5 * 1024 * 1024
+	ImportSenderID = "import"
+)
+
+// Archive is the versioned JSON export.
+type Archive struct {
+	Format     string                 `json:"format"`
+	Version    int                    `json:"version"`
+	App        string                 `json:"app"`
+	AppVersion string                 `json:"appVersion"`
+	ExportedAt string                 `json:"exportedAt"`
+	Browsers   map[string]BrowserData `json:"browsers"`
+}
+
+// importGroup is a named group of parsed tabs (a window, folder or heading).
+type importGroup struct {
+	Name string
+	Tabs []Tab
+}
+
+// ─── Export ───────────────────────────────────────────────────────────
+
+// sortedBrowserIds returns the IDs of browsers ordered by display name.
+func sortedBrowserIds(browsers map[string]BrowserData) []string {
+	ids := make([]string, 0, len(browsers))
+	for id := range browsers {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := browsers[ids[i]], browsers[ids[j]]
+		if a.BrowserName != b.BrowserName {
+			return a.BrowserName < b.BrowserName
+		}
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+// windowsOf groups a browser's tabs by window, in window order.
+func windowsOf(tabs []Tab) ([]int, map[int][]Tab) {
+	byWindow := make(map[int][]Tab)
+	var order []int
+	for _, t := range tabs {
+		if _, ok := byWindow[t.WindowID]; !ok {
+			order = append(order, t.WindowID)
+		}
+		byWindow[t.WindowID] = append(byWindow[t.WindowID], t)
+	}
+	return order, byWindow
+}
+
+// exportBrowsers renders browsers in the given format.
+// names maps browserId -> display name (alias or browser name).
+func exportBrowsers(format string, browsers map[string]BrowserData, names map[string]string) ([]byte, error) {
+	var buf bytes.Buffer
+	ids := sortedBrowserIds(browsers)
+
+	switch format {
+	case FormatHTML:
+		buf.WriteString("<!DOCTYPE NETSCAPE-Bookmark-file-1>\n")
+		buf.WriteString(`<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">` + "\n")
+		buf.WriteString("<TITLE>SyncTabs</TITLE>\n<H1>SyncTabs</H1>\n<DL><p>\n")
+		for _, id := range ids {
+			fmt.Fprintf(&buf, "    <DT><H3>%s</H3>\n    <DL><p>\n", html.EscapeString(names[id]))
+			order, byWindow := windowsOf(browsers[id].Tabs)
+			for i, w := range order {
+				fmt.Fprintf(&buf, "        <DT><H3>Window %d</H3>\n        <DL><p>\n", i+1)
+				for _, t := range byWindow[w] {
+					fmt.Fprintf(&buf, "            <DT><A HREF=\"%s\" ADD_DATE=\"%d\">%s</A>\n",
+						html.EscapeString(t.URL), int64(t.LastAccessed)/1000, html.EscapeString(t.Title))
+				}
+				buf.WriteString("        </DL><p>\n")
+			}
+			buf.WriteString("    </DL><p>\n")
+		}
+		buf.WriteString("</DL><p>\n")
+
+	case FormatMarkdown:
+		buf.WriteString("# SyncTabs\n")
+		for _, id := range ids {
+			fmt.Fprintf(&buf, "\n## %s\n", names[id])
+			order, byWindow := windowsOf(browsers[id].Tabs)
+			for i, w := range order {
+				fmt.Fprintf(&buf, "\n### Window %d\n\n", i+1)
+				for _, t := range byWindow[w] {
+					fmt.Fprintf(&buf, "- [%s](%s)\n", markdownEscape(t.Title), t.URL)
+				}
+			}
+		}
+
+	case FormatURLs:
+		for _, id := range ids {
+			for _, t := range browsers[id].Tabs {
+				buf.WriteString(t.URL + "\n")
+			}
+		}
+
+	case FormatCSV:
+		cw := csv.NewWriter(&buf)
+		_ = cw.Write([]string{"browserId", "browserName", "windowId", "title", "url", "pinned", "lastAccessed"})
+		for _, id := range ids {
+			for _, t := range browsers[id].Tabs {
+				_ = cw.Write([]string{
+					id, names[id], strconv.Itoa(t.WindowID), t.Title, t.URL,
+					strconv.FormatBool(t.Pinned), strconv.FormatFloat(t.LastAccessed, 'f', 0, 64),
+				})
+			}
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return nil, err
+		}
+
+	case FormatJSON:
+		data, err := json.MarshalIndent(Archive{
+			Format:     ArchiveFormat,
+			Version:    ArchiveVersion,
+			App:        "synctabs-companion",
+			AppVersion: config.AppVersion,
+			ExportedAt: time.Now().Format(time.RFC3339),
+			Browsers:   browsers,
+		}, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(data)
+
+	default:
+		return nil, fmt.Errorf("unknown export format %q", format)
+	}
+	return buf.Bytes(), nil
+}
+
+func markdownEscape(s string) string {
+	return strings.NewReplacer("[", `\[`, "]", `\]`).Replace(s)
+}
+
+// exportMeta returns the content type and file extension for a format.
+func exportMeta(format string) (string, string) {
+	switch format {
+	case FormatHTML:
+		return "text/html; charset=utf-8", "html"
+	case FormatMarkdown:
+		return "text/markdown; charset=utf-8", "md"
+	case FormatCSV:
+		return "text/csv; charset=utf-8", "csv"
+	case FormatJSON:
+		return "application/json", "json"
+	default:
+		return "text/plain; charset=utf-8", "txt"
+	}
+}
+
+// ─── Import ───────────────────────────────────────────────────────────
+
+var (
+	bookmarkLinkRe   = regexp.MustCompile(`(?i)<A\s[^>]*HREF="([^"]*)"[^>]*>(.*?)</A>`)
+	bookmarkFolderRe = regexp.MustCompile(`(?i)<H3[^>]*>(.*?)</H3>`)
+	markdownLinkRe   = regexp.MustCompile(`\[((?:[^\]\\]|\\.)*)\]\(([^)\s]+)\)`)
+	markdownHeadRe   = regexp.MustCompile(`^#{1,6}\s+(.*)$`)
+	htmlTagRe        = regexp.MustCompile(`<[^>]*>`)
+)
+
+// parseImport parses data in the given format into groups of tabs.
+// Invalid or non-web URLs are skipped.
+func parseImport(format string, data []byte) ([]importGroup, error) {
+	var groups []importGroup
+	cur := importGroup{}
+	flush := func(next string) {
+		if len(cur.Tabs) > 0 {
+			groups = append(groups, cur)
+		}
+		cur = importGroup{Name: next}
+	}
+	add := func(rawURL, title string) {
+		rawURL = strings.TrimSpace(rawURL)
+		if !isValidURL(rawURL) {
+			return
+		}
+		title = strings.TrimSpace(title)
+		if title == "" {
+			title = rawURL
+		}
+		cur.Tabs = append(cur.Tabs, Tab{URL: rawURL, Title: title})
+	}
+
+	switch format {
+	case FormatHTML:
+		sc := bufio.NewScanner(bytes.NewReader(data))
+		sc.Buffer(make([]byte, 64*1024), MaxImportSize)
+		for sc.Scan() {
+			line := sc.Text()
+			if m := bookmarkFolderRe.FindStringSubmatch(line); m != nil {
+				flush(html.UnescapeString(m[1]))
+				continue
+			}
+			for _, m := range bookmarkLinkRe.FindAllStringSubmatch(line, -1) {
+				add(html.UnescapeString(m[1]), html.UnescapeString(htmlTagRe.ReplaceAllString(m[2], "")))
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+
+	case FormatMarkdown:
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if m := markdownHeadRe.FindStringSubmatch(line); m != nil {
+				flush(m[1])
+				continue
+			}
+			links := markdownLinkRe.FindAllStringSubmatch(line, -1)
+			for _, m := range links {
+				add(m[2], strings.NewReplacer(`\[`, "[", `\]`, "]").Replace(m[1]))
+			}
+			if len(links) == 0 {
+				add(strings.TrimLeft(line, "-*+ "), "")
+			}
+		}
+
+	case FormatURLs:
+		for _, line := range strings.Split(string(data), "\n") {
+			add(line, "")
+		}
+
+	case FormatOneTab:
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" {
+				flush("")
+				continue
+			}
+			u, title, _ := strings.Cut(line, " | ")
+			add(u, title)
+		}
+
+	case FormatCSV:
+		r := csv.NewReader(bytes.NewReader(data))
+		r.FieldsPerRecord = -1
+		rows, err := r.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) == 0 {
+			break
+		}
+		col := make(map[string]int)
+		for i, h := range rows[0] {
+			col[strings.ToLower(strings.TrimSpace(h))] = i
+		}
+		urlCol, ok := col["url"]
+		if !ok {
+			return nil, errors.New("CSV needs a 'url' column")
+		}
+		field := func(row []string, name string) string {
+			if i, ok := col[name]; ok && i < len(row) {
+				return row[i]
+			}
+			return ""
+		}
+		groupKey := ""
+		for _, row := range rows[1:] {
+			if urlCol >= len(row) {
+				continue
+			}
+			key := field(row, "browsername") + "/" + field(row, "windowid")
+			if key != groupKey {
+				flush(strings.Trim(key, "/"))
+				groupKey = key
+			}
+			add(row[urlCol], field(row, "title"))
+			if n := len(cur.Tabs); n > 0 && field(row, "pinned") == "true" {
+				cur.Tabs[n-1].Pinned = true
+			}
+		}
+
+	case FormatJSON:
+		var archive Archive
+		if err := json.Unmarshal(data, &archive); err != nil {
+			return nil, fmt.Errorf("invalid archive: %w", err)
+		}
+		if archive.Format != ArchiveFormat {
+			return nil, errors.New("not a SyncTabs archive")
+		}
+		if archive.Version > ArchiveVersion {
+			return nil, fmt.Errorf("archive version %d is newer than supported (%d)", archive.Version, ArchiveVersion)
+		}
+		for _, id := range sortedBrowserIds(archive.Browsers) {
+			b := archive.Browsers[id]
+			order, byWindow := windowsOf(b.Tabs)
+			for i, w := range order {
+				flush(fmt.Sprintf("%s — Window %d", b.BrowserName, i+1))
+				for _, t := range byWindow[w] {
+					add(t.URL, t.Title)
+					if n := len(cur.Tabs); n > 0 {
+						cur.Tabs[n-1].Pinned = t.Pinned
+					}
+				}
+			}
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown import format %q", format)
+	}
+
+	flush("")
+	return groups, nil
+}
+
+// ImportResult summarises an import.
+type ImportResult struct {
+	Format    string `json:"format"`
+	Parsed    int    `json:"parsed"`
+	Blocked   int    `json:"blocked"`
+	BrowserID string `json:"browserId,omitempty"` // virtual browser created
+	Status    string `json:"status,omitempty"`    // delivery status for a target browser (see DeliverTabs)
+	Delivered int    `json:"delivered,omitempty"` // tabs sent straight to the connected browser
+	Queued    int    `json:"queued,omitempty"`    // tabs queued as pending
+	Awaiting  int    `json:"awaiting,omitempty"`  // tabs held for the browser to confirm
+	Refused   int    `json:"refused,omitempty"`   // refused by the browser's incoming rules
+	Rejected  int    `json:"rejected,omitempty"`  // over the imported browser's tab limit, or the pending queue was full
+}
+
+// AddVirtual stores a companion-created (never online) browser entry.
+func (s *StateStore) AddVirtual(browserId, browserName string, tabs []Tab) {
+	s.mu.Lock()
+	s.data[browserId] = &BrowserData{
+		BrowserName: browserName,
+		Tabs:        tabs,
+		LastSeen:    time.Now().Format(time.RFC3339),
+		Online:      false,
+		NeverForget: true,
+		Virtual:     true,
+	}
+	s.mu.Unlock()
+	s.DebouncedSave()
+}
+
+// Import parses data and either creates a virtual "imported" browser
+// (targetBrowserId empty) or delivers the tabs to targetBrowserId through
+// DeliverTabs, with ImportSenderID as the sender. Privacy rules apply to
+// imported tabs like to any other; the per-browser tab limit applies only
+// to a virtual browser, since delivered tabs are bounded by the queue.
+func (s *Server) Import(format string, data []byte, name, targetBrowserId string) (ImportResult, error) {
+	groups, err := parseImport(format, data)
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	res := ImportResult{Format: format}
+	var tabs []Tab
+	for gi, g := range groups {
+		for _, t := range g.Tabs {
+			res.Parsed++
+			t.ID = len(tabs) + 1
+			t.WindowID = gi + 1
+			tabs = append(tabs, t)
+		}
+	}
+	maxTabs := len(tabs)
+	if targetBrowserId == "" {
+		maxTabs = config.Get().MaxTabsPerBrowser
+	}
+	valid := validateTabArray(tabs, maxTabs, config.IncognitoDrop)
+	res.Rejected = len(tabs) - len(valid)
+	tabs, _ = privacy().ApplyTabs(valid)
+	res.Blocked = len(valid) - len(tabs)
+
+	if targetBrowserId == "" {
+		if name == "" {
+			name = "Imported " + time.Now().Format("2006-01-02 15:04")
+		}
+		res.BrowserID = fmt.Sprintf("imported-%d", time.Now().UnixNano())
+		s.state.AddVirtual(res.BrowserID, truncate(name, 100), tabs)
+		if data, ok := s.state.Get(res.BrowserID); ok {
+			broadcastBrowserTabs(s.reg, s.aliases, res.BrowserID, data)
+		}
+		logger.Info("[Import] %d tab(s) from %s → virtual browser %s", len(tabs), format, res.BrowserID)
+		return res, nil
+	}
+
+	if _, ok := s.state.Get(targetBrowserId); !ok {
+		return res, ErrUnknownBrowser
+	}
+	now := time.Now().Format(time.RFC3339)
+	pts := make([]PendingTab, 0, len(tabs))
+	for _, t := range tabs {
+		pts = append(pts, PendingTab{
+			URL:               t.URL,
+			Title:             t.Title,
+			SenderBrowserID:   ImportSenderID,
+			SenderBrowserName: "Import",
+			SentAt:            now,
+		})
+	}
+	dr := s.DeliverTabs(targetBrowserId, pts)
+	res.Status, res.Delivered, res.Queued, res.Awaiting = dr.Status, dr.Delivered, dr.Queued, dr.Awaiting
+	res.Refused, res.Rejected = dr.Refused, dr.Rejected
+	logger.Info("[Import] %d tab(s) from %s → %s (%s)", len(pts), format, targetBrowserId, dr.Status)
+	return res, nil
+}
+
+// ─── HTTP ─────────────────────────────────────────────────────────────
+
+// handleExport responds to GET /export?format=...[&browsers=id1,id2]
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = FormatJSON
+	}
+
+	all := s.state.GetAll()
+	selected := all
+	if list := q.Get("browsers"); list != "" {
+		selected = make(map[string]BrowserData)
+		for _, id := range strings.Split(list, ",") {
+			if data, ok := all[strings.TrimSpace(id)]; ok {
+				selected[strings.TrimSpace(id)] = data
+			}
+		}
+	}
+	names := make(map[string]string, len(selected))
+	for id, data := range selected {
+		names[id] = s.aliases.DisplayName(id, data.BrowserName)
+		selected[id] = data.public()
+	}
+
+	body, err := exportBrowsers(format, selected, names)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	contentType, ext := exportMeta(format)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="synctabs-%s.%s"`, time.Now().Format("2006-01-02"), ext))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+// handleImport responds to POST /import?format=...[&name=...][&target=browserId]
+// with the file contents as the request body.
+func (s *Server) handleImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		http.Error(w, "Missing format", http.StatusBadRequest)
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxImportSize))
+	if err != nil {
+		http.Error(w, "Read failed: "+err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	res, err := s.Import(format, data, q.Get("name"), q.Get("target"))
+	if errors.Is(err, ErrUnknownBrowser) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"ok":     true,
+		"result": res,
+	})
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/harshvasudeva/synctabs-companion/config"
+)
+
+func TestImportIntoBrowser(t *testing.T) {
+	updateConfig(t, map[string]interface{}{
+		"maxTabsPerBrowser": 2,
+		"pendingQueue":      map[string]interface{}{"limit": 3, "overflow": config.OverflowReject},
+	})
+	urls := func(n int) []byte {
+		var b strings.Builder
+		for i := 0; i < n; i++ {
+			b.WriteString("https://example.com/" + string(rune('a'+i)) + "\n")
+		}
+		return []byte(b.String())
+	}
+
+	tests := []struct {
+		name    string
+		target  string
+		tabs    int
+		want    ImportResult
+		wantErr error
+	}{
+		{"beyond the browser tab limit", "laptop", 3, ImportResult{Format: FormatURLs, Parsed: 3, Status: "queued", Queued: 3}, nil},
+		{"beyond the queue limit", "laptop", 5, ImportResult{Format: FormatURLs, Parsed: 5, Status: "queued", Queued: 3, Rejected: 2}, nil},
+		{"unknown browser", "nowhere", 1, ImportResult{Format: FormatURLs, Parsed: 1}, ErrUnknownBrowser},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{
+				state:   newTestState(map[string]*BrowserData{"laptop": {BrowserName: "Google Chrome"}}),
+				pending: &PendingStore{data: map[string][]PendingTab{}, saveCh: make(chan struct{}, 1)},
+				reg:     newConnectionRegistry(),
+			}
+			res, err := s.Import(FormatURLs, urls(tt.tabs), "", tt.target)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Import error = %v, want %v", err, tt.wantErr)
+			}
+			if res != tt.want {
+				t.Errorf("Import = %+v, want %+v", res, tt.want)
+			}
+			if got := len(s.pending.data[tt.target]); got != tt.want.Queued {
+				t.Errorf("queued %d tab(s), want %d", got, tt.want.Queued)
+			}
+			for _, q := range s.pending.data[tt.target] {
+				if q.SenderBrowserID != ImportSenderID {
+					t.Errorf("queued tab sender = %q, want %q", q.SenderBrowserID, ImportSenderID)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,515 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/harshvasudeva/synctabs-companion/config"
+	"github.com/harshvasudeva/synctabs-companion/logger"
+	"github.com/harshvasudeva/synctabs-companion/schema"
+)
+
+const (
+	// UsageIdleTimeout caps how long a tab is credited without any
+	// tabs-update from its browser; the remainder counts as idle.
+	UsageIdleTimeout = 15 * time.Minute
+	UsageKeepDays    = 90
+	usageDayLayout   = "2006-01-02"
+)
+
+// UsageRecord is the focused time accumulated for one URL in one browser on one day.
+type UsageRecord struct {
+	BrowserID string  `json:"browserId"`
+	URL       string  `json:"url"`
+	Domain    string  `json:"domain"`
+	Title     string  `json:"title"`
+	Seconds   float64 `json:"seconds"`
+}
+
+// activeTab is the tab currently focused in a browser and since when.
+type activeTab struct {
+	url      string
+	title    string
+	accessed float64 // the tab's lastAccessed, as reported by the browser
+	since    time.Time
+	lastSeen time.Time // last tabs-update from the browser
+}
+
+// UsageTracker accumulates active-tab time backed by usage.json. Only one
+// browser is credited at a time: the one that reported focus last or, for
+// browsers that don't report focus, whose active tab was accessed last.
+type UsageTracker struct {
+	mu      sync.Mutex
+	saveMu  sync.Mutex                         // held across snapshot and write so saves land in order
+	days    map[string]map[string]*UsageRecord // day -> browserId+"\n"+url -> record
+	active  map[string]*activeTab              // browserId -> focused tab
+	focused string                             // browser being credited, "" if none
+	folder  string
+	saveCh  chan struct{}
+}
+
+// NewUsageTracker creates a UsageTracker and loads from disk.
+func NewUsageTracker(dataFolder string) (*UsageTracker, error) {
+	u := &UsageTracker{
+		days:   make(map[string]map[string]*UsageRecord),
+		active: make(map[string]*activeTab),
+		folder: dataFolder,
+		saveCh: make(chan struct{}, 1),
+	}
+	if err := u.Load(); err != nil {
+		return nil, err
+	}
+	go u.startSaveWorker()
+	return u, nil
+}
+
+func (u *UsageTracker) usagePath() string {
+	return filepath.Join(u.folder, "usage.json")
+}
+
+// Load reads usage.json and drops days older than UsageKeepDays.
+func (u *UsageTracker) Load() error {
+	if err := os.MkdirAll(u.folder, 0755); err != nil {
+		return err
+	}
+
+	// A damaged file is quarantined and rebuilt from backups (see recovery.go)
+	entries, recovered, err := loadEntries(u.usagePath(), schema.Usage)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if recovered {
+		u.DebouncedSave()
+	}
+
+	raw := make(map[string][]UsageRecord, len(entries))
+	for id, entry := range entries {
+		var v []UsageRecord
+		if json.Unmarshal(entry, &v) == nil {
+			raw[id] = v
+		}
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	cutoff := time.Now().AddDate(0, 0, -UsageKeepDays).Format(usageDayLayout)
+	for day, records := range raw {
+		if day < cutoff {
+			continue
+		}
+		m := make(map[string]*UsageRecord, len(records))
+		for i := range records {
+			rec := records[i]
+			m[rec.BrowserID+"\n"+rec.URL] = &rec
+		}
+		u.days[day] = m
+	}
+	return nil
+}
+
+// Save writes usage.json atomically.
+func (u *UsageTracker) Save() error {
+	u.saveMu.Lock()
+	defer u.saveMu.Unlock()
+
+	u.mu.Lock()
+	snapshot := make(map[string][]UsageRecord, len(u.days))
+	for day, m := range u.days {
+		records := make([]UsageRecord, 0, len(m))
+		for _, rec := range m {
+			records = append(records, *rec)
+		}
+		snapshot[day] = records
+	}
+	folder := u.folder
+	u.mu.Unlock()
+
+	return writeDataFile(filepath.Join(folder, "usage.json"), schema.Usage, snapshot, true)
+}
+
+// DebouncedSave triggers a save after 500ms.
+func (u *UsageTracker) DebouncedSave() {
+	select {
+	case u.saveCh <- struct{}{}:
+	default:
+	}
+}
+
+func (u *UsageTracker) startSaveWorker() {
+	for range u.saveCh {
+		time.Sleep(500 * time.Millisecond)
+		for {
+			select {
+			case <-u.saveCh:
+			default:
+				goto save
+			}
+		}
+	save:
+		if err := u.Save(); err != nil {
+			logger.Error("Usage save failed: %v", err)
+		}
+	}
+}
+
+// focusedTab picks the browser's active tab: among tabs flagged active
+// (one per window), the most recently accessed one. Incognito tabs are only
+// considered when the incognito policy allows persisting them.
+func focusedTab(tabs []Tab, withIncognito bool) (Tab, bool) {
+	var best Tab
+	found := false
+	for _, t := range tabs {
+		if !t.Active || !isValidURL(t.URL) || (t.Incognito && !withIncognito) {
+			continue
+		}
+		if !found || t.LastAccessed > best.LastAccessed {
+			best = t
+			found = true
+		}
+	}
+	return best, found
+}
+
+// Observe is called on every tabs-update. It records the browser's active
+// tab and, if that tab was accessed after the credited browser's, moves
+// the credit to this browser.
+func (u *UsageTracker) Observe(browserId string, tabs []Tab) {
+	now := time.Now()
+	tab, ok := focusedTab(tabs, persistsIncognito(config.Get().IncognitoPolicy))
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if browserId == u.focused {
+		u.settle(now)
+	}
+	if !ok {
+		delete(u.active, browserId)
+		if browserId == u.focused {
+			u.focused = ""
+		}
+		return
+	}
+	u.active[browserId] = &activeTab{url: tab.URL, title: tab.Title, accessed: tab.LastAccessed, since: now, lastSeen: now}
+
+	if browserId != u.focused {
+		current := u.active[u.focused]
+		if current == nil || tab.LastAccessed > current.accessed {
+			u.settle(now)
+			u.focused = browserId
+		}
+	}
+}
+
+// SetFocus records a browser reporting that it gained or lost focus.
+func (u *UsageTracker) SetFocus(browserId string, focused bool) {
+	now := time.Now()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.settle(now)
+	switch {
+	case focused:
+		u.focused = browserId
+		if a := u.active[browserId]; a != nil {
+			a.since = now
+			a.lastSeen = now
+		}
+	case u.focused == browserId:
+		u.focused = ""
+	}
+}
+
+// Stop credits and clears the focused tab for a browser (e.g. on disconnect).
+func (u *UsageTracker) Stop(browserId string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if browserId == u.focused {
+		u.settle(time.Now())
+		u.focused = ""
+	}
+	delete(u.active, browserId)
+}
+
+// settle credits the focused browser's tab up to now. Caller holds u.mu.
+func (u *UsageTracker) settle(now time.Time) {
+	if a := u.active[u.focused]; a != nil {
+		u.credit(u.focused, a, now)
+		a.since = now
+	}
+}
+
+// credit adds the focused interval [a.since, now] to the day buckets, capped
+// at UsageIdleTimeout after the browser's last update. Caller holds u.mu.
+func (u *UsageTracker) credit(browserId string, a *activeTab, now time.Time) {
+	end := now
+	if limit := a.lastSeen.Add(UsageIdleTimeout); end.After(limit) {
+		end = limit
+	}
+	start := a.since
+	if !end.After(start) {
+		return
+	}
+
+	key := browserId + "\n" + a.url
+	for start.Before(end) {
+		y, m, d := start.Date()
+		midnight := time.Date(y, m, d+1, 0, 0, 0, 0, start.Location())
+		segEnd := end
+		if midnight.Before(segEnd) {
+			segEnd = midnight
+		}
+
+		day := start.Format(usageDayLayout)
+		recs := u.days[day]
+		if recs == nil {
+			recs = make(map[string]*UsageRecord)
+			u.days[day] = recs
+			u.pruneBefore(start)
+		}
+		rec := recs[key]
+		if rec == nil {
+			rec = &UsageRecord{BrowserID: browserId, URL: a.url, Domain: domainOf(a.url)}
+			recs[key] = rec
+		}
+		rec.Title = a.title
+		rec.Seconds = round1(rec.Seconds + segEnd.Sub(start).Seconds())
+
+		start = segEnd
+	}
+	u.DebouncedSave()
+}
+
+// Records returns a copy of all records for days in [from, to] (inclusive,
+// "2006-01-02" format), crediting any in-progress intervals up to now.
+func (u *UsageTracker) Records(from, to string) map[string][]UsageRecord {
+	u.mu.Lock()
+	u.settle(time.Now())
+	result := make(map[string][]UsageRecord)
+	for day, m := range u.days {
+		if day < from || day > to {
+			continue
+		}
+		for _, rec := range m {
+			result[day] = append(result[day], *rec)
+		}
+	}
+	u.mu.Unlock()
+	return result
+}
+
+// pruneBefore drops days older than UsageKeepDays relative to now. Caller holds u.mu.
+func (u *UsageTracker) pruneBefore(now time.Time) {
+	cutoff := now.AddDate(0, 0, -UsageKeepDays).Format(usageDayLayout)
+	for day := range u.days {
+		if day < cutoff {
+			delete(u.days, day)
+		}
+	}
+}
+
+// Forget drops all usage recorded for a browser.
+func (u *UsageTracker) Forget(browserId string) {
+	u.mu.Lock()
+	delete(u.active, browserId)
+	if u.focused == browserId {
+		u.focused = ""
+	}
+	for day, m := range u.days {
+		for key, rec := range m {
+			if rec.BrowserID == browserId {
+				delete(m, key)
+			}
+		}
+		if len(m) == 0 {
+			delete(u.days, day)
+		}
+	}
+	u.mu.Unlock()
+	u.DebouncedSave()
+}
+
+// UpdateDataFolder points the store at a new folder and saves to it.
+// Existing files are copied beforehand by Server.MoveDataFolder.
+func (u *UsageTracker) UpdateDataFolder(newFolder string) error {
+	u.mu.Lock()
+	u.folder = newFolder
+	u.mu.Unlock()
+	return u.Save()
+}
+
+// UsageTotal is one row of an aggregated usage report.
+type UsageTotal struct {
+	Key     string  `json:"key"`
+	Label   string  `json:"label,omitempty"`
+	Seconds float64 `json:"seconds"`
+}
+
+// UsageReport aggregates focused time over a date range.
+type UsageReport struct {
+	From         string       `json:"from"`
+	To           string       `json:"to"`
+	TotalSeconds float64      `json:"totalSeconds"`
+	ByBrowser    []UsageTotal `json:"byBrowser"`
+	ByDomain     []UsageTotal `json:"byDomain"`
+	ByURL        []UsageTotal `json:"byUrl"`
+	ByDay        []UsageTotal `json:"byDay"`
+}
+
+// buildUsageReport totals records by browser, domain, URL and day.
+// names maps browserId -> display name; limit caps the domain and URL lists.
+func buildUsageReport(from, to string, records map[string][]UsageRecord, names map[string]string, limit int) UsageReport {
+	browsers := make(map[string]float64)
+	domains := make(map[string]float64)
+	urls := make(map[string]float64)
+	titles := make(map[string]string)
+	days := make(map[string]float64)
+	total := 0.0
+
+	for day, recs := range records {
+		for _, rec := range recs {
+			total += rec.Seconds
+			browsers[rec.BrowserID] += rec.Seconds
+			domains[rec.Domain] += rec.Seconds
+			urls[rec.URL] += rec.Seconds
+			titles[rec.URL] = rec.Title
+			days[day] += rec.Seconds
+		}
+	}
+
+	toTotals := func(m map[string]float64, label func(string) string) []UsageTotal {
+		out := make([]UsageTotal, 0, len(m))
+		for k, v := range m {
+			out = append(out, UsageTotal{Key: k, Label: label(k), Seconds: round1(v)})
+		}
+		sort.Slice(out, func(i, j int) bool {
+			if out[i].Seconds != out[j].Seconds {
+				return out[i].Seconds > out[j].Seconds
+			}
+			return out[i].Key < out[j].Key
+		})
+		return out
+	}
+	noLabel := func(string) string { return "" }
+
+	report := UsageReport{
+		From:         from,
+		To:           to,
+		TotalSeconds: round1(total),
+		ByBrowser:    toTotals(browsers, func(id string) string { return names[id] }),
+		ByDomain:     toTotals(domains, noLabel),
+		ByURL:        toTotals(urls, func(u string) string { return titles[u] }),
+		ByDay:        toTotals(days, noLabel),
+	}
+	sort.Slice(report.ByDay, func(i, j int) bool { return report.ByDay[i].Key < report.ByDay[j].Key })
+	if len(report.ByDomain) > limit {
+		report.ByDomain = report.ByDomain[:limit]
+	}
+	if len(report.ByURL) > limit {
+		report.ByURL = report.ByURL[:limit]
+	}
+	return report
+}
+
+// handleUsage responds to GET /usage?period=day|week[&date=YYYY-MM-DD][&format=csv]
+func (s *Server) handleUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	end := time.Now()
+	if v := q.Get("date"); v != "" {
+		t, err := time.ParseInLocation(usageDayLayout, v, time.Local)
+		if err != nil {
+			http.Error(w, "Invalid date", http.StatusBadRequest)
+			return
+		}
+		end = t
+	}
+	start := end
+	switch q.Get("period") {
+	case "", "day":
+	case "week":
+		start = end.AddDate(0, 0, -6)
+	default:
+		http.Error(w, "Unknown period", http.StatusBadRequest)
+		return
+	}
+	limit := 50
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	from, to := start.Format(usageDayLayout), end.Format(usageDayLayout)
+	records := s.usage.Records(from, to)
+
+	names := make(map[string]string)
+	for id, data := range s.state.GetAll() {
+		names[id] = data.BrowserName
+	}
+
+	if q.Get("format") == "csv" {
+		writeUsageCSV(w, from, to, records, names)
+		return
+	}
+	writeJSON(w, buildUsageReport(from, to, records, names, limit))
+}
+
+// writeUsageCSV writes one row per day/browser/URL.
+func writeUsageCSV(w http.ResponseWriter, from, to string, records map[string][]UsageRecord, names map[string]string) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="synctabs-usage-%s_%s.csv"`, from, to))
+	w.WriteHeader(http.StatusOK)
+
+	days := make([]string, 0, len(records))
+	for day := range records {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"date", "browserId", "browserName", "domain", "url", "title", "seconds"})
+	for _, day := range days {
+		recs := records[day]
+		sort.Slice(recs, func(i, j int) bool {
+			if recs[i].BrowserID != recs[j].BrowserID {
+				return recs[i].BrowserID < recs[j].BrowserID
+			}
+			return recs[i].Seconds > recs[j].Seconds
+		})
+		for _, rec := range recs {
+			_ = cw.Write([]string{
+				day,
+				rec.BrowserID,
+				names[rec.BrowserID],
+				rec.Domain,
+				rec.URL,
+				strings.TrimSpace(rec.Title),
+				strconv.FormatFloat(rec.Seconds, 'f', 1, 64),
+			})
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		logger.Error("CSV write error: %v", err)
+	}
+}
@@ -0,0 +1,768 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/harshvasudeva/synctabs-companion/logger"
+	"github.com/harshvasudeva/synctabs-companion/schema"
+)
+
+const MaxWorkspaceNameLength = 100
+
+var (
+	ErrUnknownWorkspace = errors.New("unknown workspace")
+	ErrWorkspaceExists  = errors.New("a workspace with that name already exists")
+)
+
+// WorkspaceTab is a tab recorded in a workspace.
+type WorkspaceTab struct {
+	URL        string `json:"url"`
+	Title      string `json:"title"`
+	FavIconURL string `json:"favIconUrl,omitempty"`
+	Pinned     bool   `json:"pinned,omitempty"`
+}
+
+// WorkspaceWindow is one browser window of a workspace, in tab order.
+type WorkspaceWindow struct {
+	Tabs []WorkspaceTab `json:"tabs"`
+}
+
+// WorkspaceBrowser is what a workspace holds for one browser.
+type WorkspaceBrowser struct {
+	BrowserName string            `json:"browserName"`
+	Windows     []WorkspaceWindow `json:"windows"`
+}
+
+// Workspace is a named definition of which tabs and windows belong in
+// which browser, restorable across all of them at once.
+type Workspace struct {
+	ID        string                       `json:"id"`
+	Name      string                       `json:"name"`
+	Browsers  map[string]*WorkspaceBrowser `json:"browsers"` // browserId -> windows
+	CreatedAt string                       `json:"createdAt"`
+	UpdatedAt string                       `json:"updatedAt"`
+}
+
+func (ws *Workspace) clone() Workspace {
+	cp := *ws
+	cp.Browsers = make(map[string]*WorkspaceBrowser, len(ws.Browsers))
+	for id, b := range ws.Browsers {
+		bc := *b
+		bc.Windows = make([]WorkspaceWindow, len(b.Windows))
+		for i, win := range b.Windows {
+			bc.Windows[i].Tabs = append([]WorkspaceTab(nil), win.Tabs...)
+		}
+		cp.Browsers[id] = &bc
+	}
+	return cp
+}
+
+// tabCount returns the number of tabs across every browser and window.
+func (ws *Workspace) tabCount() int {
+	n := 0
+	for _, b := range ws.Browsers {
+		for _, win := range b.Windows {
+			n += len(win.Tabs)
+		}
+	}
+	return n
+}
+
+// WorkspaceStore holds workspaces backed by workspaces.json
+type WorkspaceStore struct {
+	mu     sync.RWMutex
+	saveMu sync.Mutex            // held across snapshot and write so saves land in order
+	data   map[string]*Workspace // workspaceId -> workspace
+	folder string
+	saveCh chan struct{}
+}
+
+// NewWorkspaceStore creates a WorkspaceStore and loads from disk.
+func NewWorkspaceStore(dataFolder string) (*WorkspaceStore, error) {
+	w := &WorkspaceStore{
+		data:   make(map[string]*Workspace),
+		folder: dataFolder,
+		saveCh: make(chan struct{}, 1),
+	}
+	if err := w.Load(); err != nil {
+		return nil, err
+	}
+	go w.startSaveWorker()
+	return w, nil
+}
+
+func (w *WorkspaceStore) workspacesPath() string {
+	return filepath.Join(w.folder, "workspaces.json")
+}
+
+// Load reads workspaces.json.
+func (w *WorkspaceStore) Load() error {
+	if err := os.MkdirAll(w.folder, 0755); err != nil {
+		return err
+	}
+
+	// A damaged file is quarantined and rebuilt from backups (see recovery.go)
+	entries, recovered, err := loadEntries(w.workspacesPath(), schema.Workspaces)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if recovered {
+		w.DebouncedSave()
+	}
+
+	raw := make(map[string]*Workspace, len(entries))
+	for id, entry := range entries {
+		var v *Workspace
+		if json.Unmarshal(entry, &v) == nil {
+			raw[id] = v
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for id, ws := range raw {
+		if id == "" || ws == nil || ws.Name == "" {
+			continue
+		}
+		ws.ID = id
+		if ws.Browsers == nil {
+			ws.Browsers = make(map[string]*WorkspaceBrowser)
+		}
+		for bid, b := range ws.Browsers {
+			if b == nil {
+				delete(ws.Browsers, bid)
+			}
+		}
+		w.data[id] = ws
+	}
+	return nil
+}
+
+// Save writes workspaces.json atomically.
+func (w *WorkspaceStore) Save() error {
+	w.saveMu.Lock()
+	defer w.saveMu.Unlock()
+
+	w.mu.RLock()
+	snapshot := make(map[string]Workspace, len(w.data))
+	for id, ws := range w.data {
+		snapshot[id] = ws.clone()
+	}
+	path := w.workspacesPath()
+	w.mu.RUnlock()
+
+	return writeDataFile(path, schema.Workspaces, snapshot, true)
+}
+
+// DebouncedSave triggers a save after 500ms.
+func (w *WorkspaceStore) DebouncedSave() {
+	select {
+	case w.saveCh <- struct{}{}:
+	default:
+	}
+}
+
+func (w *WorkspaceStore) startSaveWorker() {
+	for range w.saveCh {
+		time.Sleep(500 * time.Millisecond)
+		for {
+			select {
+			case <-w.saveCh:
+			default:
+				goto save
+			}
+		}
+	save:
+		if err := w.Save(); err != nil {
+			logger.Error("Workspace save failed: %v", err)
+		}
+	}
+}
+
+// UpdateDataFolder points the store at a new folder and saves to it.
+// Existing files are copied beforehand by Server.MoveDataFolder.
+func (w *WorkspaceStore) UpdateDataFolder(newFolder string) error {
+	w.mu.Lock()
+	w.folder = newFolder
+	w.mu.Unlock()
+	return w.Save()
+}
+
+// List returns every workspace, sorted by name.
+func (w *WorkspaceStore) List() []Workspace {
+	w.mu.RLock()
+	out := make([]Workspace, 0, len(w.data))
+	for _, ws := range w.data {
+		out = append(out, ws.clone())
+	}
+	w.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return strings.ToLower(out[i].Name) < strings.ToLower(out[j].Name) })
+	return out
+}
+
+// Get returns a workspace by ID.
+func (w *WorkspaceStore) Get(id string) (Workspace, bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	ws, ok := w.data[id]
+	if !ok {
+		return Workspace{}, false
+	}
+	return ws.clone(), true
+}
+
+// findByName returns the workspace named name (case-insensitive). Caller holds mu.
+func (w *WorkspaceStore) findByName(name string) *Workspace {
+	for _, ws := range w.data {
+		if strings.EqualFold(ws.Name, name) {
+			return ws
+		}
+	}
+	return nil
+}
+
+func validWorkspaceName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return name, errors.New("workspace name required")
+	}
+	if utf8.RuneCountInString(name) > MaxWorkspaceNameLength {
+		return name, fmt.Errorf("workspace name longer than %d characters", MaxWorkspaceNameLength)
+	}
+	return name, nil
+}
+
+// Put creates a workspace, or replaces the browsers of the workspace with
+// the same name when overwrite is set.
+func (w *WorkspaceStore) Put(name string, browsers map[string]*WorkspaceBrowser, overwrite bool) (Workspace, error) {
+	name, err := validWorkspaceName(name)
+	if err != nil {
+		return Workspace{}, err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := time.Now().Format(time.RFC3339)
+	ws := w.findByName(name)
+	if ws != nil && !overwrite {
+		return Workspace{}, ErrWorkspaceExists
+	}
+	if ws == nil {
+		ws = &Workspace{ID: newID(), CreatedAt: now}
+		w.data[ws.ID] = ws
+	}
+	ws.Name = name
+	ws.Browsers = browsers
+	ws.UpdatedAt = now
+	w.DebouncedSave()
+	return ws.clone(), nil
+}
+
+// Update renames a workspace (if name is set) and replaces its browsers
+// (if browsers is non-nil).
+func (w *WorkspaceStore) Update(id, name string, browsers map[string]*WorkspaceBrowser) (Workspace, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ws, ok := w.data[id]
+	if !ok {
+		return Workspace{}, ErrUnknownWorkspace
+	}
+	if name != "" {
+		name, err := validWorkspaceName(name)
+		if err != nil {
+			return Workspace{}, err
+		}
+		if other := w.findByName(name); other != nil && other.ID != id {
+			return Workspace{}, ErrWorkspaceExists
+		}
+		ws.Name = name
+	}
+	if browsers != nil {
+		ws.Browsers = browsers
+	}
+	ws.UpdatedAt = time.Now().Format(time.RFC3339)
+	w.DebouncedSave()
+	return ws.clone(), nil
+}
+
+// Delete removes a workspace.
+func (w *WorkspaceStore) Delete(id string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.data[id]; !ok {
+		return ErrUnknownWorkspace
+	}
+	delete(w.data, id)
+	w.DebouncedSave()
+	return nil
+}
+
+// MoveBrowser re-keys every workspace's entry for fromId to toId, e.g.
+// after a reinstall or merge. An existing entry for toId is kept.
+func (w *WorkspaceStore) MoveBrowser(fromId, toId string) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	moved := 0
+	for _, ws := range w.data {
+		b, ok := ws.Browsers[fromId]
+		if !ok || fromId == toId {
+			continue
+		}
+		delete(ws.Browsers, fromId)
+		if _, exists := ws.Browsers[toId]; !exists {
+			ws.Browsers[toId] = b
+		}
+		moved++
+	}
+	if moved > 0 {
+		w.DebouncedSave()
+	}
+	return moved
+}
+
+// MapFavicons rewrites every workspace tab's favicon URL with fn.
+func (w *WorkspaceStore) MapFavicons(fn func(string) string) {
+	w.mu.Lock()
+	changed := false
+	for _, ws := range w.data {
+		for _, b := range ws.Browsers {
+			for _, win := range b.Windows {
+				for i := range win.Tabs {
+					if u := fn(win.Tabs[i].FavIconURL); u != win.Tabs[i].FavIconURL {
+						win.Tabs[i].FavIconURL = u
+						changed = true
+					}
+				}
+			}
+		}
+	}
+	w.mu.Unlock()
+	if changed {
+		w.DebouncedSave()
+	}
+}
+
+// ApplyPrivacy rewrites workspace tabs under the current rules.
+// Returns the IDs of workspaces that changed.
+func (w *WorkspaceStore) ApplyPrivacy() []string {
+	e := privacy()
+	w.mu.Lock()
+	var changed []string
+	for id, ws := range w.data {
+		diff := false
+		for _, b := range ws.Browsers {
+			for wi, win := range b.Windows {
+				kept := make([]WorkspaceTab, 0, len(win.Tabs))
+				for _, t := range win.Tabs {
+					if e.Blocked(t.URL) {
+						diff = true
+						continue
+					}
+					orig, origTitle := t.URL, t.Title
+					t.Title = e.Title(orig, t.Title)
+					t.URL = e.RedactURL(orig)
+					diff = diff || t.URL != orig || t.Title != origTitle
+					kept = append(kept, t)
+				}
+				b.Windows[wi].Tabs = kept
+			}
+		}
+		if diff {
+			changed = append(changed, id)
+		}
+	}
+	w.mu.Unlock()
+	if len(changed) > 0 {
+		w.DebouncedSave()
+	}
+	return changed
+}
+
+// ─── Server operations ────────────────────────────────────────────────
+
+// workspaceBrowserFrom groups a browser's tabs into windows, in the order
+// each window first appears. Incognito tabs are never recorded.
+func workspaceBrowserFrom(data BrowserData) *WorkspaceBrowser {
+	tabs, _ := withoutIncognito(data.Tabs)
+	tabs, _ = privacy().ApplyTabs(tabs)
+	b := &WorkspaceBrowser{BrowserName: data.BrowserName, Windows: []WorkspaceWindow{}}
+	index := make(map[int]int) // windowId -> position in b.Windows
+	for _, t := range tabs {
+		i, ok := index[t.WindowID]
+		if !ok {
+			i = len(b.Windows)
+			index[t.WindowID] = i
+			b.Windows = append(b.Windows, WorkspaceWindow{})
+		}
+		b.Windows[i].Tabs = append(b.Windows[i].Tabs, WorkspaceTab{
+			URL:        t.URL,
+			Title:      t.Title,
+			FavIconURL: t.FavIconURL,
+			Pinned:     t.Pinned,
+		})
+	}
+	return b
+}
+
+// CaptureWorkspace records the current tabs of the given browsers (all
+// real browsers if none are given). Imported browsers are skipped.
+func (s *Server) CaptureWorkspace(browserIds []string) (map[string]*WorkspaceBrowser, error) {
+	all := s.state.GetAll()
+	if len(browserIds) == 0 {
+		for id := range all {
+			browserIds = append(browserIds, id)
+		}
+	}
+	out := make(map[string]*WorkspaceBrowser, len(browserIds))
+	for _, id := range browserIds {
+		data, ok := all[id]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownBrowser, id)
+		}
+		if data.Virtual {
+			continue
+		}
+		if b := workspaceBrowserFrom(data); len(b.Windows) > 0 {
+			out[id] = b
+		}
+	}
+	if len(out) == 0 {
+		return nil, errors.New("no tabs to save")
+	}
+	return out, nil
+}
+
+// visibleWorkspaces lists the workspaces for browser viewerId (see
+// workspaceFor).
+func (s *Server) visibleWorkspaces(viewerId string) []Workspace {
+	all := s.workspaces.List()
+	out := make([]Workspace, 0, len(all))
+	for _, ws := range all {
+		if ws, ok := s.workspaceFor(ws, viewerId); ok {
+			out = append(out, ws)
+		}
+	}
+	return out
+}
+
+// workspaceFor returns ws as browser viewerId may see it, keeping only
+// the browsers whose tabs it may see. ok is false when none of its
+// browsers are left.
+func (s *Server) workspaceFor(ws Workspace, viewerId string) (Workspace, bool) {
+	ws = ws.clone()
+	had := len(ws.Browsers)
+	for id := range ws.Browsers {
+		if id != viewerId && s.state.visibility(id, viewerId) != shareFull {
+			delete(ws.Browsers, id)
+		}
+	}
+	return ws, had == 0 || len(ws.Browsers) > 0
+}
+
+// SaveWorkspace captures the given browsers (all if none) as a workspace
+// named name, replacing a same-named workspace when overwrite is set.
+func (s *Server) SaveWorkspace(name string, browserIds []string, overwrite bool) (Workspace, error) {
+	browsers, err := s.CaptureWorkspace(browserIds)
+	if err != nil {
+		return Workspace{}, err
+	}
+	ws, err := s.workspaces.Put(name, browsers, overwrite)
+	if err != nil {
+		return Workspace{}, err
+	}
+	s.broadcastWorkspace(ws)
+	logger.Info("[Workspace] Saved %q: %d tab(s) in %d browser(s)", ws.Name, ws.tabCount(), len(ws.Browsers))
+	return ws, nil
+}
+
+// RecaptureWorkspace replaces a workspace's contents with the current tabs
+// of the browsers it already covers (or of browserIds, if given).
+func (s *Server) RecaptureWorkspace(id string, browserIds []string) (Workspace, error) {
+	ws, ok := s.workspaces.Get(id)
+	if !ok {
+		return Workspace{}, ErrUnknownWorkspace
+	}
+	if len(browserIds) == 0 {
+		for bid := range ws.Browsers {
+			browserIds = append(browserIds, bid)
+		}
+	}
+	browsers, err := s.CaptureWorkspace(browserIds)
+	if err != nil {
+		return Workspace{}, err
+	}
+	return s.workspaces.Update(id, "", browsers)
+}
+
+// WorkspaceRestore is the outcome of restoring one browser of a workspace.
+type WorkspaceRestore struct {
+	BrowserID string `json:"browserId"`
+	Status    string `json:"status"` // a DeliverResult status, or "skipped"
+	Tabs      int    `json:"tabs"`
+	Awaiting  int    `json:"awaiting,omitempty"` // held for the browser to confirm
+	Refused   int    `json:"refused,omitempty"`  // refused by its incoming rules
+	Rejected  int    `json:"rejected,omitempty"` // not queued: pending queue full
+	Dropped   int    `json:"dropped,omitempty"`  // older pending tabs evicted
+	Reason    string `json:"reason,omitempty"`
+}
+
+// RestoreWorkspace sends every browser its tabs from a workspace: online
+// browsers are told to open them now, offline ones get them queued as
+// pending. Tabs carry their window number and pinned state, and the
+// browser opens each window as a new window. browserIds limits the
+// restore to some of the workspace's browsers.
+func (s *Server) RestoreWorkspace(id string, browserIds []string) ([]WorkspaceRestore, error) {
+	ws, ok := s.workspaces.Get(id)
+	if !ok {
+		return nil, ErrUnknownWorkspace
+	}
+	if len(browserIds) == 0 {
+		for bid := range ws.Browsers {
+			browserIds = append(browserIds, bid)
+		}
+		sort.Strings(browserIds)
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	results := make([]WorkspaceRestore, 0, len(browserIds))
+	for _, bid := range browserIds {
+		b, ok := ws.Browsers[bid]
+		if !ok {
+			results = append(results, WorkspaceRestore{BrowserID: bid, Status: "skipped", Reason: "not in workspace"})
+			continue
+		}
+		if _, known := s.state.Get(bid); !known {
+			results = append(results, WorkspaceRestore{BrowserID: bid, Status: "skipped", Reason: "unknown browser"})
+			continue
+		}
+		var tabs []PendingTab
+		for wi, win := range b.Windows {
+			for _, t := range win.Tabs {
+				tabs = append(tabs, PendingTab{
+					URL:               t.URL,
+					Title:             t.Title,
+					FavIconURL:        t.FavIconURL,
+					SenderBrowserID:   "workspace:" + ws.ID,
+					SenderBrowserName: ws.Name,
+					SentAt:            now,
+					Pinned:            t.Pinned,
+					Window:            wi + 1,
+				})
+			}
+		}
+		if len(tabs) == 0 {
+			results = append(results, WorkspaceRestore{BrowserID: bid, Status: "skipped", Reason: "no tabs"})
+			continue
+		}
+		res := s.DeliverTabs(bid, tabs)
+		r := WorkspaceRestore{
+			BrowserID: bid, Status: res.Status, Tabs: len(tabs),
+			Awaiting: res.Awaiting, Refused: res.Refused, Rejected: res.Rejected, Dropped: res.Dropped,
+		}
+		switch {
+		case res.Rejected > 0 || res.Dropped > 0:
+			r.Reason = "pending queue full"
+		case res.Refused > 0:
+			r.Reason = "refused by incoming rules"
+		}
+		results = append(results, r)
+	}
+	logger.Info("[Workspace] Restored %q to %d browser(s)", ws.Name, len(results))
+	return results, nil
+}
+
+// broadcastWorkspace sends ws to every browser as it may see it.
+func (s *Server) broadcastWorkspace(ws Workspace) {
+	s.reg.broadcastEach(func(viewerId string) interface{} {
+		visible, ok := s.workspaceFor(ws, viewerId)
+		if !ok {
+			return nil
+		}
+		return map[string]interface{}{
+			"type":      "workspace-updated",
+			"workspace": visible,
+		}
+	})
+}
+
+// DeleteWorkspace removes a workspace and tells every browser.
+func (s *Server) DeleteWorkspace(id string) error {
+	if err := s.workspaces.Delete(id); err != nil {
+		return err
+	}
+	s.reg.broadcast("", map[string]interface{}{
+		"type":        "workspace-deleted",
+		"workspaceId": id,
+	})
+	return nil
+}
+
+// workspaceError maps workspace errors to HTTP status codes.
+func workspaceError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrUnknownWorkspace), errors.Is(err, ErrUnknownBrowser):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrWorkspaceExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// handleWorkspaces responds to GET /workspaces and
+// POST /workspaces {"name", "browserIds", "overwrite"} (captures current tabs).
+func (s *Server) handleWorkspaces(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, s.workspaces.List())
+
+	case http.MethodPost:
+		var req struct {
+			Name       string   `json:"name"`
+			BrowserIDs []string `json:"browserIds"`
+			Overwrite  bool     `json:"overwrite"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		ws, err := s.SaveWorkspace(req.Name, req.BrowserIDs, req.Overwrite)
+		if err != nil {
+			workspaceError(w, err)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"ok": true, "workspace": ws})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleWorkspace serves /workspaces/{id}[/restore]:
+//
+//	GET    /workspaces/{id}
+//	POST   /workspaces/{id}          {"name", "browsers", "recapture", "browserIds"}
+//	DELETE /workspaces/{id}
+//	POST   /workspaces/{id}/restore  {"browserIds"}
+func (s *Server) handleWorkspace(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/workspaces/"), "/"), "/")
+	id := parts[0]
+	action := ""
+	if len(parts) > 1 {
+		action = parts[1]
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		ws, ok := s.workspaces.Get(id)
+		if !ok {
+			http.Error(w, ErrUnknownWorkspace.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, ws)
+
+	case action == "" && r.Method == http.MethodPost:
+		var req struct {
+			Name       string                       `json:"name"`
+			Browsers   map[string]*WorkspaceBrowser `json:"browsers"`
+			Recapture  bool                         `json:"recapture"`
+			BrowserIDs []string                     `json:"browserIds"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Browsers != nil {
+			req.Browsers = cleanWorkspaceBrowsers(req.Browsers)
+		}
+		if req.Recapture {
+			if _, err := s.RecaptureWorkspace(id, req.BrowserIDs); err != nil {
+				workspaceError(w, err)
+				return
+			}
+		}
+		ws, err := s.workspaces.Update(id, req.Name, req.Browsers)
+		if err != nil {
+			workspaceError(w, err)
+			return
+		}
+		s.broadcastWorkspace(ws)
+		writeJSON(w, map[string]interface{}{"ok": true, "workspace": ws})
+
+	case action == "" && r.Method == http.MethodDelete:
+		if err := s.DeleteWorkspace(id); err != nil {
+			workspaceError(w, err)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"ok": true, "workspaceId": id})
+
+	case action == "restore" && r.Method == http.MethodPost:
+		var req struct {
+			BrowserIDs []string `json:"browserIds"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		results, err := s.RestoreWorkspace(id, req.BrowserIDs)
+		if err != nil {
+			workspaceError(w, err)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"ok": true, "browsers": results})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// cleanWorkspaceBrowsers validates an edited workspace definition: invalid
+// URLs and blocked tabs are dropped, the rest redacted and truncated.
+func cleanWorkspaceBrowsers(in map[string]*WorkspaceBrowser) map[string]*WorkspaceBrowser {
+	e := privacy()
+	out := make(map[string]*WorkspaceBrowser, len(in))
+	for id, b := range in {
+		if id == "" || b == nil {
+			continue
+		}
+		clean := &WorkspaceBrowser{BrowserName: truncate(b.BrowserName, 200), Windows: []WorkspaceWindow{}}
+		for _, win := range b.Windows {
+			var tabs []WorkspaceTab
+			for _, t := range win.Tabs {
+				if !isValidURL(t.URL) || e.Blocked(t.URL) {
+					continue
+				}
+				title := t.Title
+				if title == "" {
+					title = "New Tab"
+				}
+				tabs = append(tabs, WorkspaceTab{
+					URL:    truncate(e.RedactURL(t.URL), 2048),
+					Title:  truncate(e.Title(t.URL, title), 500),
+					Pinned: t.Pinned,
+				})
+			}
+			if len(tabs) > 0 {
+				clean.Windows = append(clean.Windows, WorkspaceWindow{Tabs: tabs})
+			}
+		}
+		if len(clean.Windows) > 0 {
+			out[id] = clean
+		}
+	}
+	return out
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"net/url"
 	"strings"
 	"sync"
@@ -56,6 +57,10 @@ func (c *clientConn) sendJSON(msg interface{}) error {
 type connectionRegistry struct {
 	mu    sync.RWMutex
 	conns map[string]*clientConn
+
+	// visibility, if set, applies sharing settings to broadcasts about a
+	// browser (see sharing.go)
+	visibility func(aboutId, viewerId string) shareLevel
 }
 
 func newConnectionRegistry() *connectionRegistry {
@@ -77,30 +82,91 @@ func (r *connectionRegistry) get(browserId string) (*clientConn, bool) {
 	return c, ok
 }
 
-func (r *connectionRegistry) delete(browserId string) {
+// delete removes browserId's entry if it is still conn, and reports
+// whether it was. A browser that reconnected keeps its new connection
+// when the old one is torn down.
+func (r *connectionRegistry) delete(browserId string, conn *clientConn) bool {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	if r.conns[browserId] != conn {
+		return false
+	}
 	delete(r.conns, browserId)
+	return true
+}
+
+// all returns a snapshot of every live connection.
+func (r *connectionRegistry) all() map[string]*clientConn {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make(map[string]*clientConn, len(r.conns))
+	for id, conn := range r.conns {
+		out[id] = conn
+	}
+	return out
 }
 
-// broadcast sends msg to all connections except excludeId.
+// broadcast sends msg to all connections except excludeId. A message from
+// or about excludeId is withheld from browsers it is hidden from, and sent
+// without its tabs to browsers that may not see them.
 func (r *connectionRegistry) broadcast(excludeId string, msg interface{}) {
 	r.mu.RLock()
-	targets := make([]*clientConn, 0, len(r.conns))
+	targets := make(map[string]*clientConn, len(r.conns))
 	for id, conn := range r.conns {
 		if id != excludeId {
-			targets = append(targets, conn)
+			targets[id] = conn
 		}
 	}
 	r.mu.RUnlock()
 
-	for _, conn := range targets {
+	for id, conn := range targets {
+		out := msg
+		if excludeId != "" && r.visibility != nil {
+			switch r.visibility(excludeId, id) {
+			case shareNone:
+				continue
+			case sharePresence:
+				out = withoutTabs(msg)
+			}
+		}
+		if err := conn.sendJSON(out); err != nil {
+			logger.Debug("Broadcast send error: %v", err)
+		}
+	}
+}
+
+// broadcastEach sends every connection the message msgFor builds for it,
+// for messages carrying data from several browsers that must be filtered
+// per recipient. Connections msgFor returns nil for are skipped.
+func (r *connectionRegistry) broadcastEach(msgFor func(viewerId string) interface{}) {
+	for id, conn := range r.all() {
+		msg := msgFor(id)
+		if msg == nil {
+			continue
+		}
 		if err := conn.sendJSON(msg); err != nil {
 			logger.Debug("Broadcast send error: %v", err)
 		}
 	}
 }
 
+// withoutTabs returns a copy of a message with its tab list emptied.
+func withoutTabs(msg interface{}) interface{} {
+	m, ok := msg.(map[string]interface{})
+	if !ok {
+		return msg
+	}
+	if _, has := m["tabs"]; !has {
+		return msg
+	}
+	cp := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		cp[k] = v
+	}
+	cp["tabs"] = []Tab{}
+	return cp
+}
+
 // count returns the number of live connections.
 func (r *connectionRegistry) count() int {
 	r.mu.RLock()
@@ -108,8 +174,8 @@ func (r *connectionRegistry) count() int {
 	return len(r.conns)
 }
 
-// names returns a list of browser names for connected clients.
-func (r *connectionRegistry) names(state *StateStore) []string {
+// names returns a list of browser display names for connected clients.
+func (r *connectionRegistry) names(state *StateStore, aliases *AliasStore) []string {
 	r.mu.RLock()
 	ids := make([]string, 0, len(r.conns))
 	for id := range r.conns {
@@ -120,7 +186,7 @@ func (r *connectionRegistry) names(state *StateStore) []string {
 	names := make([]string, 0, len(ids))
 	for _, id := range ids {
 		if data, ok := state.Get(id); ok {
-			names = append(names, data.BrowserName)
+			names = append(names, aliases.DisplayName(id, data.BrowserName))
 		}
 	}
 	return names
@@ -134,21 +200,42 @@ type inboundMsg struct {
 	Tabs            json.RawMessage `json:"tabs"`
 	TargetBrowserID string          `json:"targetBrowserId"`
 	Tab             json.RawMessage `json:"tab"`
+	Policy          string          `json:"policy"`
+	URL             string          `json:"url"`
+	NeverForget     bool            `json:"neverForget"`
+	Alias           BrowserAlias    `json:"alias"`
+	SourceBrowserID string          `json:"sourceBrowserId"`
+	StashID         string          `json:"stashId"`
+	StashName       string          `json:"stashName"`
+	TabIDs          []int           `json:"tabIds"`
+	Mode            string          `json:"mode"`
+	Remove          bool            `json:"remove"`
+	Query           string          `json:"query"`
+	WorkspaceID     string          `json:"workspaceId"`
+	WorkspaceName   string          `json:"workspaceName"`
+	BrowserIDs      []string        `json:"browserIds"`
+	Overwrite       bool            `json:"overwrite"`
+	Sharing         string          `json:"sharing"`
+	HiddenFrom      []string        `json:"hiddenFrom"`
+	ItemID          string          `json:"itemId"`
+	Accept          bool            `json:"accept"`
+	HandoffID       string          `json:"handoffId"`
+	OK              bool            `json:"ok"`
+	Error           string          `json:"error"`
+	Focused         bool            `json:"focused"`
+	BrowserIdentity
 }
 
 // HandleConnection is called once per new WebSocket upgrade.
-func HandleConnection(
-	ws *websocket.Conn,
-	state *StateStore,
-	pending *PendingStore,
-	reg *connectionRegistry,
-	cfg config.Config,
-) {
+func HandleConnection(ws *websocket.Conn, srv *Server, cfg config.Config) {
+	state, pending, usage, aliases, reg := srv.state, srv.pending, srv.usage, srv.aliases, srv.reg
+	favicons := srv.favicons
 	conn := &clientConn{ws: ws}
 
 	defer func() {
 		ws.Close()
-		handleDisconnect(conn, state, reg)
+		handleDisconnect(conn, state, usage, aliases, reg)
+		srv.abortHandoffs(conn.browserId)
 	}()
 
 	ws.SetReadLimit(MaxMessageSize)
@@ -184,13 +271,55 @@ func HandleConnection(
 
 		switch msg.Type {
 		case "register":
-			handleRegister(conn, msg, state, pending, reg, cfg)
+			handleRegister(conn, msg, srv, state, pending, aliases, reg, cfg)
 		case "tabs-update":
-			handleTabsUpdate(conn, msg, state, reg, cfg)
+			handleTabsUpdate(conn, msg, srv, state, usage, aliases, favicons, reg, cfg)
 		case "request-state":
-			handleRequestState(conn, state)
+			handleRequestState(conn, state, aliases)
 		case "send-tab":
-			handleSendTab(conn, msg, state, pending, reg)
+			handleSendTab(conn, msg, srv)
+		case "request-duplicates":
+			handleRequestDuplicates(conn, state)
+		case "dedupe":
+			handleDedupe(conn, msg, state, reg)
+		case "pin-browser":
+			handlePinBrowser(conn, msg, srv)
+		case "forget-browser":
+			handleForgetBrowser(conn, msg, srv)
+		case "set-alias":
+			handleSetAlias(conn, msg, srv)
+		case "merge-browsers":
+			handleMergeBrowsers(conn, msg, srv)
+		case "split-browsers":
+			handleSplitBrowsers(conn, msg, srv)
+		case "stash-tabs":
+			handleStashTabs(conn, msg, srv)
+		case "list-stashes":
+			handleListStashes(conn, msg, srv)
+		case "open-stash":
+			handleOpenStash(conn, msg, srv)
+		case "delete-stash":
+			handleDeleteStash(conn, msg, srv)
+		case "set-sharing":
+			handleSetSharing(conn, msg, srv)
+		case "save-workspace":
+			handleSaveWorkspace(conn, msg, srv)
+		case "list-workspaces":
+			handleListWorkspaces(conn, srv)
+		case "restore-workspace":
+			handleRestoreWorkspace(conn, msg, srv)
+		case "delete-workspace":
+			handleDeleteWorkspace(conn, msg, srv)
+		case "confirm-tab":
+			handleConfirmTab(conn, msg, srv)
+		case "focus":
+			if conn.browserId != "" {
+				usage.SetFocus(conn.browserId, msg.Focused)
+			}
+		case "handoff-tab":
+			handleHandoffTab(conn, msg, srv)
+		case "handoff-opened":
+			handleHandoffOpened(conn, msg, srv)
 		}
 	}
 }
@@ -198,8 +327,10 @@ func HandleConnection(
 func handleRegister(
 	conn *clientConn,
 	msg inboundMsg,
+	srv *Server,
 	state *StateStore,
 	pending *PendingStore,
+	aliases *AliasStore,
 	reg *connectionRegistry,
 	cfg config.Config,
 ) {
@@ -216,18 +347,27 @@ func handleRegister(
 	conn.browserId = msg.BrowserID
 
 	// Close old connection for same browserId
-	if existing, ok := reg.get(msg.BrowserID); ok && existing != conn {
+	// Take over before closing an older connection, so its teardown
+	// leaves this one in place
+	existing, had := reg.get(msg.BrowserID)
+	reg.set(msg.BrowserID, conn)
+	if had && existing != conn {
 		existing.ws.Close()
 	}
-	reg.set(msg.BrowserID, conn)
 
-	// Register in state store (handles dedup internally)
-	fullState := state.Register(msg.BrowserID, msg.BrowserName)
+	// Register in state store (replaces an old entry only on a proven reinstall)
+	fullState, replaced := state.Register(msg.BrowserID, msg.BrowserName, msg.BrowserIdentity)
+	if replaced != "" {
+		srv.adoptReplaced(replaced, msg.BrowserID)
+	}
+	// Its first tabs update is reconciled with mirrored tabs
+	srv.mirrorReset(msg.BrowserID)
 
 	// Send full-state (excluding self)
 	_ = conn.sendJSON(map[string]interface{}{
 		"type":     "full-state",
 		"browsers": fullState,
+		"aliases":  aliases.All(),
 	})
 
 	// Broadcast presence to all others
@@ -235,26 +375,43 @@ func handleRegister(
 		"type":        "presence",
 		"browserId":   msg.BrowserID,
 		"browserName": msg.BrowserName,
+		"alias":       aliases.Get(msg.BrowserID),
 		"online":      true,
 		"lastSeen":    time.Now().Format(time.RFC3339),
 	})
 
 	logger.Info("[+] %s (%s) connected", msg.BrowserName, msg.BrowserID)
 
+	// Hold pending tabs during quiet hours; they are delivered as one
+	// batch when the window ends
+	if until, quiet := quietUntil(msg.BrowserID, time.Now()); quiet {
+		if n := pending.Hold(msg.BrowserID, until); n > 0 {
+			logger.Info("[Quiet] Holding %d pending item(s) for %s until %s", n, msg.BrowserName, until.Format("15:04"))
+		}
+		return
+	}
+
 	// Deliver pending tabs
 	if tabs := pending.Deliver(msg.BrowserID); len(tabs) > 0 {
 		_ = conn.sendJSON(map[string]interface{}{
 			"type": "pending-tabs",
 			"tabs": tabs,
 		})
-		logger.Info("[Deliver] %d pending tab(s) → %s", len(tabs), msg.BrowserName)
+		logger.Info("[Deliver] %d pending tab(s) → %s", len(flattenPending(tabs)), msg.BrowserName)
+	}
+	if held := pending.Awaiting(msg.BrowserID); len(held) > 0 {
+		sendConfirmRequest(conn, held)
 	}
 }
 
 func handleTabsUpdate(
 	conn *clientConn,
 	msg inboundMsg,
+	srv *Server,
 	state *StateStore,
+	usage *UsageTracker,
+	aliases *AliasStore,
+	favicons *FaviconStore,
 	reg *connectionRegistry,
 	cfg config.Config,
 ) {
@@ -270,15 +427,20 @@ func handleTabsUpdate(
 
 	var tabs []Tab
 	if err := json.Unmarshal(msg.Tabs, &tabs); err != nil {
-		logger.Warn("[tabs-update] Failed to parse tabs from %s: %v (raw: %.200s)", conn.browserId, err, string(msg.Tabs))
+		logger.Warn("[tabs-update] Failed to parse tabs from %s: %v (%d bytes)", conn.browserId, err, len(msg.Tabs))
 		return
 	}
 
 	logger.Debug("[tabs-update] %s sent %d tab(s)", conn.browserId, len(tabs))
-	tabs = validateTabArray(tabs, cfg.MaxTabsPerBrowser)
+	policy := config.Get().IncognitoPolicy
+	tabs = validateTabArray(tabs, cfg.MaxTabsPerBrowser, policy)
+	tabs, _ = privacy().ApplyTabs(tabs)
+	favicons.InternTabs(tabs, persistsIncognito(policy))
 
 	lastSeen := time.Now().Format(time.RFC3339)
 	state.UpdateTabs(conn.browserId, tabs)
+	usage.Observe(conn.browserId, tabs)
+	srv.mirrorTabs(conn.browserId, tabs)
 
 	data, ok := state.Get(conn.browserId)
 	if !ok {
@@ -289,29 +451,27 @@ func handleTabsUpdate(
 		"type":        "browser-tabs-updated",
 		"browserId":   conn.browserId,
 		"browserName": data.BrowserName,
+		"alias":       aliases.Get(conn.browserId),
 		"tabs":        tabs,
 		"lastSeen":    lastSeen,
 		"online":      true,
 	})
 }
 
-func handleRequestState(conn *clientConn, state *StateStore) {
+func handleRequestState(conn *clientConn, state *StateStore, aliases *AliasStore) {
 	if conn.browserId == "" {
 		return
 	}
 	_ = conn.sendJSON(map[string]interface{}{
 		"type":     "full-state",
 		"browsers": state.BuildStateForClient(conn.browserId),
+		"aliases":  aliases.All(),
 	})
 }
 
-func handleSendTab(
-	conn *clientConn,
-	msg inboundMsg,
-	state *StateStore,
-	pending *PendingStore,
-	reg *connectionRegistry,
-) {
+// handleSendTab sends a tab to targetBrowserId, or to the browser picked by
+// the routing rules when the target is "auto".
+func handleSendTab(conn *clientConn, msg inboundMsg, srv *Server) {
 	if conn.browserId == "" {
 		return
 	}
@@ -326,70 +486,419 @@ func handleSendTab(
 		_ = conn.sendJSON(map[string]string{"type": "error", "message": "Invalid send-tab payload"})
 		return
 	}
-	if !isValidURL(tab.URL) {
-		_ = conn.sendJSON(map[string]string{"type": "error", "message": "Invalid URL"})
+
+	target := msg.TargetBrowserID
+	route := Route{Rule: -1}
+	if target == RouteAuto && isValidURL(tab.URL) {
+		var err error
+		if route, err = srv.RouteURL(tab.URL); err != nil {
+			_ = conn.sendJSON(map[string]string{"type": "error", "message": err.Error()})
+			return
+		}
+		target = route.TargetBrowserID
+		if target == conn.browserId {
+			_ = conn.sendJSON(map[string]string{"type": "error", "message": "Routing rules send this URL to this browser"})
+			return
+		}
+	}
+
+	senderData, _ := srv.state.Get(conn.browserId)
+	pendingTab, err := srv.prepareSend(conn.browserId, senderData.BrowserName, tab.URL, tab.Title, tab.FavIconURL)
+	if err != nil {
+		_ = conn.sendJSON(map[string]string{"type": "error", "message": err.Error()})
+		if privacy().Blocked(tab.URL) {
+			logger.Info("[Send] %s → %s rejected: %s", conn.browserId, target, LogURL(tab.URL))
+		}
 		return
 	}
 
-	senderData, _ := state.Get(conn.browserId)
-	pendingTab := PendingTab{
-		URL:               truncate(tab.URL, 2048),
-		Title:             truncate(tab.Title, 500),
-		FavIconURL:        truncate(tab.FavIconURL, 2048),
-		SenderBrowserID:   conn.browserId,
-		SenderBrowserName: senderData.BrowserName,
-		SentAt:            time.Now().Format(time.RFC3339),
+	// Delivered now if the target is online, otherwise queued subject to
+	// its limit and overflow policy
+	status, res, err := srv.sendTab(target, pendingTab)
+	if res.Policy != "" {
+		sendOverflow(conn, target, res)
+		logger.Info("[Send] %s → %s queue full (limit %d), applied %s", conn.browserId, target, res.Limit, res.Policy)
+	}
+	var rejected *RejectedError
+	if errors.As(err, &rejected) {
+		srv.sendRejected(conn.browserId, target, pendingTab.URL, rejected.Reason)
+		logger.Info("[Send] %s → %s rejected (%s): %s", conn.browserId, target, rejected.Reason, LogURL(pendingTab.URL))
+		return
+	}
+	if err != nil {
+		_ = conn.sendJSON(map[string]string{"type": "error", "message": err.Error()})
+		return
+	}
+	ack := map[string]interface{}{
+		"type":            "send-tab-ack",
+		"status":          status,
+		"targetBrowserId": target,
+	}
+	if status == "queued" || status == StatusAwaitingConfirm || status == StatusHeld {
+		ack["queue"] = res
 	}
+	if status == StatusHeld {
+		if until, ok := quietUntil(target, time.Now()); ok {
+			ack["until"] = until.Format(time.RFC3339)
+		}
+	}
+	if route.Matched {
+		ack["rule"] = route.Rule
+	}
+	_ = conn.sendJSON(ack)
+	if res.Duplicate {
+		status = "already queued"
+	}
+	logger.Info("[Send] %s → %s (%s): %s", conn.browserId, target, status, LogURL(pendingTab.URL))
+}
 
-	if target, ok := reg.get(msg.TargetBrowserID); ok {
-		// Target online — deliver immediately
-		_ = target.sendJSON(map[string]interface{}{
-			"type": "pending-tabs",
-			"tabs": []PendingTab{pendingTab},
-		})
-		_ = conn.sendJSON(map[string]interface{}{
-			"type":            "send-tab-ack",
-			"status":          "delivered",
-			"targetBrowserId": msg.TargetBrowserID,
-		})
-		logger.Info("[Send] %s → %s (delivered): %s", conn.browserId, msg.TargetBrowserID, tab.URL)
-	} else {
-		// Target offline — queue
-		if err := pending.Enqueue(msg.TargetBrowserID, pendingTab); err != nil {
-			_ = conn.sendJSON(map[string]string{"type": "error", "message": err.Error()})
-			return
+// handleConfirmTab accepts or declines a tab held for this browser by its
+// incoming rules.
+func handleConfirmTab(conn *clientConn, msg inboundMsg, srv *Server) {
+	if conn.browserId == "" {
+		return
+	}
+	if _, err := srv.ConfirmPending(conn.browserId, msg.ItemID, msg.Accept); err != nil {
+		_ = conn.sendJSON(map[string]string{"type": "error", "message": err.Error()})
+	}
+}
+
+// handleHandoffTab moves a tab from this browser to targetBrowserId (see
+// StartHandoff). tab carries the source tab's id and url.
+func handleHandoffTab(conn *clientConn, msg inboundMsg, srv *Server) {
+	if conn.browserId == "" {
+		return
+	}
+	var tab struct {
+		ID         int    `json:"id"`
+		URL        string `json:"url"`
+		Title      string `json:"title"`
+		FavIconURL string `json:"favIconUrl"`
+	}
+	if err := json.Unmarshal(msg.Tab, &tab); err != nil || tab.URL == "" {
+		_ = conn.sendJSON(map[string]string{"type": "error", "message": "Invalid handoff-tab payload"})
+		return
+	}
+	target, ok := srv.resolveBrowser(msg.TargetBrowserID)
+	if !ok {
+		_ = conn.sendJSON(map[string]string{"type": "error", "message": ErrUnknownBrowser.Error()})
+		return
+	}
+	senderData, _ := srv.state.Get(conn.browserId)
+	pendingTab, err := srv.prepareSend(conn.browserId, senderData.BrowserName, tab.URL, tab.Title, tab.FavIconURL)
+	if err == nil {
+		pendingTab.ID = newID()
+		var handoffId string
+		if handoffId, err = srv.StartHandoff(conn.browserId, tab.ID, target, pendingTab); err == nil {
+			_ = conn.sendJSON(map[string]interface{}{
+				"type":            "handoff-result",
+				"handoffId":       handoffId,
+				"status":          "pending",
+				"targetBrowserId": target,
+				"tabId":           tab.ID,
+			})
 		}
-		_ = conn.sendJSON(map[string]interface{}{
-			"type":            "send-tab-ack",
-			"status":          "queued",
-			"targetBrowserId": msg.TargetBrowserID,
-		})
-		logger.Info("[Send] %s → %s (queued): %s", conn.browserId, msg.TargetBrowserID, tab.URL)
 	}
+	if err != nil {
+		result := map[string]interface{}{
+			"type":            "handoff-result",
+			"status":          "failed",
+			"targetBrowserId": target,
+			"tabId":           tab.ID,
+			"message":         err.Error(),
+		}
+		var rejected *RejectedError
+		if errors.As(err, &rejected) {
+			result["reason"] = rejected.Reason
+		}
+		_ = conn.sendJSON(result)
+	}
+}
+
+// handleHandoffOpened is the target's report on a handoff-open:
+// {"handoffId", "ok", "error"}.
+func handleHandoffOpened(conn *clientConn, msg inboundMsg, srv *Server) {
+	if conn.browserId == "" {
+		return
+	}
+	if err := srv.CompleteHandoff(conn.browserId, msg.HandoffID, msg.OK, msg.Error); err != nil {
+		_ = conn.sendJSON(map[string]string{"type": "error", "message": err.Error()})
+	}
+}
+
+// sendOverflow tells a sender which overflow policy was applied to a
+// target's full pending queue.
+func sendOverflow(conn *clientConn, targetBrowserId string, res EnqueueResult) {
+	_ = conn.sendJSON(map[string]interface{}{
+		"type":            "pending-overflow",
+		"targetBrowserId": targetBrowserId,
+		"policy":          res.Policy,
+		"limit":           res.Limit,
+		"dropped":         res.Dropped,
+		"bundled":         res.Bundled,
+	})
+}
+
+func handleRequestDuplicates(conn *clientConn, state *StateStore) {
+	if conn.browserId == "" {
+		return
+	}
+	_ = conn.sendJSON(map[string]interface{}{
+		"type":   "duplicates",
+		"groups": state.FindDuplicates(conn.browserId),
+	})
 }
 
-func handleDisconnect(conn *clientConn, state *StateStore, reg *connectionRegistry) {
+func handleDedupe(conn *clientConn, msg inboundMsg, state *StateStore, reg *connectionRegistry) {
 	if conn.browserId == "" {
 		return
 	}
+	res, err := dedupe(state, reg, conn.browserId, msg.Policy, msg.URL)
+	if err != nil {
+		_ = conn.sendJSON(map[string]string{"type": "error", "message": err.Error()})
+		return
+	}
+	_ = conn.sendJSON(map[string]interface{}{
+		"type":   "dedupe-result",
+		"result": res,
+	})
+	logger.Info("[Dedupe] %s requested %s across %d group(s)", conn.browserId, res.Policy, res.Groups)
+}
+
+func handlePinBrowser(conn *clientConn, msg inboundMsg, srv *Server) {
+	if conn.browserId == "" {
+		return
+	}
+	if !srv.state.SetNeverForget(msg.TargetBrowserID, msg.NeverForget) {
+		_ = conn.sendJSON(map[string]string{"type": "error", "message": "Unknown browser"})
+		return
+	}
+	_ = conn.sendJSON(map[string]interface{}{
+		"type":        "browser-pinned",
+		"browserId":   msg.TargetBrowserID,
+		"neverForget": msg.NeverForget,
+	})
+}
+
+func handleForgetBrowser(conn *clientConn, msg inboundMsg, srv *Server) {
+	if conn.browserId == "" {
+		return
+	}
+	if err := srv.ForgetBrowser(msg.TargetBrowserID); err != nil {
+		_ = conn.sendJSON(map[string]string{"type": "error", "message": err.Error()})
+	}
+}
+
+// handleSetAlias sets the alias for msg.targetBrowserId (or the sender if empty).
+func handleSetAlias(conn *clientConn, msg inboundMsg, srv *Server) {
+	if conn.browserId == "" {
+		return
+	}
+	target := msg.TargetBrowserID
+	if target == "" {
+		target = conn.browserId
+	}
+	if _, err := srv.SetAlias(target, msg.Alias); err != nil {
+		_ = conn.sendJSON(map[string]string{"type": "error", "message": err.Error()})
+	}
+}
+
+// handleMergeBrowsers merges sourceBrowserId into targetBrowserId.
+func handleMergeBrowsers(conn *clientConn, msg inboundMsg, srv *Server) {
+	if conn.browserId == "" {
+		return
+	}
+	if err := srv.MergeBrowsers(msg.SourceBrowserID, msg.TargetBrowserID); err != nil {
+		_ = conn.sendJSON(map[string]string{"type": "error", "message": err.Error()})
+	}
+}
+
+// handleSplitBrowsers marks sourceBrowserId and targetBrowserId as distinct.
+func handleSplitBrowsers(conn *clientConn, msg inboundMsg, srv *Server) {
+	if conn.browserId == "" {
+		return
+	}
+	if err := srv.SplitBrowsers(msg.SourceBrowserID, msg.TargetBrowserID); err != nil {
+		_ = conn.sendJSON(map[string]string{"type": "error", "message": err.Error()})
+	}
+}
+
+// handleSetSharing sets the sender's sharing mode and hidden-from list.
+// Other browsers' settings can only be changed over HTTP.
+func handleSetSharing(conn *clientConn, msg inboundMsg, srv *Server) {
+	if conn.browserId == "" {
+		return
+	}
+	if msg.TargetBrowserID != "" && msg.TargetBrowserID != conn.browserId {
+		_ = conn.sendJSON(map[string]string{"type": "error", "message": "set-sharing can only change this browser's own settings"})
+		return
+	}
+	if err := srv.SetSharing(conn.browserId, msg.Sharing, msg.HiddenFrom); err != nil {
+		_ = conn.sendJSON(map[string]string{"type": "error", "message": err.Error()})
+	}
+}
+
+// handleStashTabs copies or moves (mode "move") tabs of the sending browser
+// into the stash stashId, or the stash named stashName (created if needed).
+func handleStashTabs(conn *clientConn, msg inboundMsg, srv *Server) {
+	if conn.browserId == "" {
+		return
+	}
+	stash, added, err := srv.StashBrowserTabs(msg.StashID, msg.StashName, conn.browserId, msg.TabIDs, msg.Mode == "move")
+	if err != nil {
+		_ = conn.sendJSON(map[string]string{"type": "error", "message": err.Error()})
+		return
+	}
+	_ = conn.sendJSON(map[string]interface{}{
+		"type":    "stash-tabs-result",
+		"stashId": stash.ID,
+		"added":   added,
+	})
+}
+
+// handleListStashes replies with all stashes, or those matching query.
+func handleListStashes(conn *clientConn, msg inboundMsg, srv *Server) {
+	if conn.browserId == "" {
+		return
+	}
+	_ = conn.sendJSON(map[string]interface{}{
+		"type":    "stashes",
+		"query":   msg.Query,
+		"stashes": srv.visibleStashes(srv.stashes.Search(msg.Query), conn.browserId),
+	})
+}
+
+// handleOpenStash opens a stash in targetBrowserId (default: the sender).
+func handleOpenStash(conn *clientConn, msg inboundMsg, srv *Server) {
+	if conn.browserId == "" {
+		return
+	}
+	target := msg.TargetBrowserID
+	if target == "" {
+		target = conn.browserId
+	}
+	status, err := srv.OpenStash(msg.StashID, target, msg.Remove)
+	if err != nil {
+		_ = conn.sendJSON(map[string]string{"type": "error", "message": err.Error()})
+		return
+	}
+	_ = conn.sendJSON(map[string]interface{}{
+		"type":            "open-stash-result",
+		"stashId":         msg.StashID,
+		"targetBrowserId": target,
+		"status":          status,
+	})
+}
+
+func handleDeleteStash(conn *clientConn, msg inboundMsg, srv *Server) {
+	if conn.browserId == "" {
+		return
+	}
+	if err := srv.DeleteStash(msg.StashID); err != nil {
+		_ = conn.sendJSON(map[string]string{"type": "error", "message": err.Error()})
+	}
+}
+
+// handleSaveWorkspace captures browserIds (default: every browser) as the
+// workspace workspaceName; overwrite replaces a workspace of that name.
+func handleSaveWorkspace(conn *clientConn, msg inboundMsg, srv *Server) {
+	if conn.browserId == "" {
+		return
+	}
+	ws, err := srv.SaveWorkspace(msg.WorkspaceName, msg.BrowserIDs, msg.Overwrite)
+	if err != nil {
+		_ = conn.sendJSON(map[string]string{"type": "error", "message": err.Error()})
+		return
+	}
+	_ = conn.sendJSON(map[string]interface{}{
+		"type":        "save-workspace-result",
+		"workspaceId": ws.ID,
+	})
+}
+
+func handleListWorkspaces(conn *clientConn, srv *Server) {
+	if conn.browserId == "" {
+		return
+	}
+	_ = conn.sendJSON(map[string]interface{}{
+		"type":       "workspaces",
+		"workspaces": srv.visibleWorkspaces(conn.browserId),
+	})
+}
+
+// handleRestoreWorkspace restores a workspace to browserIds (default: all
+// of its browsers).
+func handleRestoreWorkspace(conn *clientConn, msg inboundMsg, srv *Server) {
+	if conn.browserId == "" {
+		return
+	}
+	results, err := srv.RestoreWorkspace(msg.WorkspaceID, msg.BrowserIDs)
+	if err != nil {
+		_ = conn.sendJSON(map[string]string{"type": "error", "message": err.Error()})
+		return
+	}
+	_ = conn.sendJSON(map[string]interface{}{
+		"type":        "restore-workspace-result",
+		"workspaceId": msg.WorkspaceID,
+		"browsers":    results,
+	})
+}
+
+func handleDeleteWorkspace(conn *clientConn, msg inboundMsg, srv *Server) {
+	if conn.browserId == "" {
+		return
+	}
+	if err := srv.DeleteWorkspace(msg.WorkspaceID); err != nil {
+		_ = conn.sendJSON(map[string]string{"type": "error", "message": err.Error()})
+	}
+}
+
+// handleDisconnect marks the browser offline, unless a newer connection
+// from it has taken over.
+func handleDisconnect(conn *clientConn, state *StateStore, usage *UsageTracker, aliases *AliasStore, reg *connectionRegistry) {
+	if conn.browserId == "" || !reg.delete(conn.browserId, conn) {
+		return
+	}
 	data, ok := state.Get(conn.browserId)
 	if !ok {
 		return
 	}
 
-	state.SetOffline(conn.browserId)
-	reg.delete(conn.browserId)
+	removedIncognito := state.SetOffline(conn.browserId)
+	usage.Stop(conn.browserId)
+
+	// Incognito tabs vanish from peers once their browser goes away
+	if removedIncognito > 0 {
+		if offline, ok := state.Get(conn.browserId); ok {
+			broadcastBrowserTabs(reg, aliases, conn.browserId, offline)
+		}
+	}
 
 	reg.broadcast(conn.browserId, map[string]interface{}{
 		"type":        "presence",
 		"browserId":   conn.browserId,
 		"browserName": data.BrowserName,
+		"alias":       aliases.Get(conn.browserId),
 		"online":      false,
 		"lastSeen":    time.Now().Format(time.RFC3339),
 	})
 	logger.Info("[-] %s (%s) disconnected", data.BrowserName, conn.browserId)
 }
 
+// broadcastBrowserTabs pushes a browser's stored tab list to every other
+// connection, e.g. after the companion itself changed it.
+func broadcastBrowserTabs(reg *connectionRegistry, aliases *AliasStore, browserId string, data BrowserData) {
+	reg.broadcast(browserId, map[string]interface{}{
+		"type":        "browser-tabs-updated",
+		"browserId":   browserId,
+		"browserName": data.BrowserName,
+		"alias":       aliases.Get(browserId),
+		"tabs":        data.Tabs,
+		"lastSeen":    data.LastSeen,
+		"online":      data.Online,
+	})
+}
+
 // isValidURL mirrors server.js isValidUrl()
 func isValidURL(rawURL string) bool {
 	u, err := url.Parse(rawURL)
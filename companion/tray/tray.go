@@ -2,6 +2,7 @@ package tray
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"fyne.io/systray"
@@ -14,12 +15,14 @@ import (
 type ServerInterface interface {
 	ConnectedCount() int
 	BrowserNames() []string
+	RecoveryNotes() []string
 	Restart(cfg config.Config) error
 	Stop()
 }
 
 var (
 	mStatus    *systray.MenuItem
+	mRecovery  *systray.MenuItem
 	mAutoStart *systray.MenuItem
 	srv        ServerInterface
 )
@@ -44,6 +47,10 @@ func onReady() {
 	mStatus = systray.AddMenuItem("● Starting...", "Server status")
 	mStatus.Disable()
 
+	// Shown only if a data file had to be recovered at startup
+	mRecovery = systray.AddMenuItem("⚠ Data recovered at startup", "A damaged data file was restored — click to open the data folder")
+	mRecovery.Hide()
+
 	systray.AddSeparator()
 
 	mDataFolder := systray.AddMenuItem("Open Data Folder", "Open data folder in Explorer")
@@ -66,6 +73,7 @@ func onReady() {
 
 	// Start status updater goroutine
 	go statusUpdater()
+	go notifyRecoveries()
 
 	// Event loop
 	go func() {
@@ -75,6 +83,10 @@ func onReady() {
 				cfg := config.Get()
 				openFolder(cfg.DataFolder)
 
+			case <-mRecovery.ClickedCh:
+				cfg := config.Get()
+				openFolder(cfg.DataFolder)
+
 			case <-mLogs.ClickedCh:
 				openInNotepad(logger.LogPath())
 
@@ -110,6 +122,17 @@ func onExit() {
 	srv.Stop()
 }
 
+// notifyRecoveries tells the user once if data files were recovered at startup.
+func notifyRecoveries() {
+	notes := srv.RecoveryNotes()
+	if len(notes) == 0 {
+		return
+	}
+	mRecovery.SetTooltip(strings.Join(notes, "\n"))
+	mRecovery.Show()
+	showNotification("SyncTabs — Data recovered", strings.Join(notes, " "))
+}
+
 // statusUpdater polls every 2 seconds and updates the status menu item.
 func statusUpdater() {
 	// Initial update after a short delay for server to start
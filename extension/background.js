@@ -92,6 +92,43 @@ async function getOrCreateBrowserId() {
   });
 }
 
+// ─── Install Identity ─────────────────────────────────────────────────────────
+// Kept in sync storage, which outlives an uninstall while the profile
+// syncs, so the companion can tell a reinstall (new browserId, same
+// fingerprint and label) from a second profile of the same browser.
+const IDENTITY_KEY = 'synctabs_identity';
+
+async function getInstallIdentity() {
+  let identity = {};
+  try {
+    identity = (await chrome.storage.sync.get(IDENTITY_KEY))[IDENTITY_KEY] || {};
+  } catch { /* sync storage unavailable */ }
+  if (!identity.fingerprint) {
+    identity.fingerprint = Array.from(crypto.getRandomValues(new Uint8Array(16)))
+      .map(b => b.toString(16).padStart(2, '0')).join('');
+    try { await chrome.storage.sync.set({ [IDENTITY_KEY]: identity }); }
+    catch { /* a fresh fingerprint each time only means no reinstall match */ }
+  }
+  return { fingerprint: identity.fingerprint, profileLabel: identity.profileLabel || '' };
+}
+
+async function setProfileLabel(label) {
+  const identity = await getInstallIdentity();
+  identity.profileLabel = String(label || '').trim().slice(0, 64);
+  await chrome.storage.sync.set({ [IDENTITY_KEY]: identity });
+  return identity;
+}
+
+function registerMessage(identity) {
+  return {
+    type: 'register',
+    browserId,
+    browserName,
+    installFingerprint: identity.fingerprint,
+    profileLabel: identity.profileLabel,
+  };
+}
+
 // ─── URL Validation ──────────────────────────────────────────────────────────
 function isValidUrl(url) {
   try {
@@ -100,10 +137,42 @@ function isValidUrl(url) {
   } catch { return false; }
 }
 
+// ─── Window Names ─────────────────────────────────────────────────────────────
+// Names the user gives windows in the popup, for the companion's mirror rules.
+// Window IDs last only as long as the browser session, and so do the names.
+async function getWindowNames() {
+  const result = await chrome.storage.session.get('synctabs_window_names');
+  return result.synctabs_window_names || {};
+}
+
+async function setWindowName(windowId, name) {
+  const names = await getWindowNames();
+  name = String(name || '').trim().slice(0, 100);
+  if (name) names[windowId] = name;
+  else delete names[windowId];
+  await chrome.storage.session.set({ synctabs_window_names: names });
+}
+
+// Opens url in the window named name, creating and naming it if needed.
+async function openInNamedWindow(name, url, pinned) {
+  const names = await getWindowNames();
+  for (const [wid, n] of Object.entries(names)) {
+    if (n !== name) continue;
+    try {
+      await chrome.tabs.create({ windowId: Number(wid), url, active: false, pinned });
+      return;
+    } catch { await setWindowName(wid, ''); } // window is gone
+  }
+  const win = await chrome.windows.create({ url, focused: false });
+  await setWindowName(win.id, name);
+  if (pinned && win.tabs && win.tabs[0]) await chrome.tabs.update(win.tabs[0].id, { pinned: true });
+}
+
 // ─── Tab Collection ───────────────────────────────────────────────────────────
 async function collectTabs() {
   try {
     const tabs = await chrome.tabs.query({});
+    const windowNames = await getWindowNames();
     // Build a window incognito map for labeling
     const windowIncognito = {};
     const windowIds = [...new Set(tabs.map(t => t.windowId))];
@@ -123,6 +192,7 @@ async function collectTabs() {
       active: t.active,
       lastAccessed: t.lastAccessed || Date.now(),
       incognito: windowIncognito[t.windowId] || false,
+      windowName: windowNames[t.windowId] || '',
     }));
   } catch (err) {
     console.error('[SyncTabs] Failed to collect tabs:', err);
@@ -147,6 +217,18 @@ async function getRemoteBrowsers() {
   return result.synctabs_remote_browsers || {};
 }
 
+// Tabs the companion holds until this browser accepts them (incoming rules).
+// The companion sends the full list each time, so it replaces what we had.
+async function saveAwaitingConfirm(tabs) {
+  await chrome.storage.local.set({ synctabs_awaiting_confirm: tabs });
+  chrome.action.setBadgeText({ text: tabs.length > 0 ? String(tabs.length) : '' }).catch(() => {});
+}
+
+async function getAwaitingConfirm() {
+  const result = await chrome.storage.local.get('synctabs_awaiting_confirm');
+  return result.synctabs_awaiting_confirm || [];
+}
+
 async function getLocalTabs() {
   const result = await chrome.storage.local.get(['synctabs_my_tabs', 'synctabs_my_last_seen']);
   return { tabs: result.synctabs_my_tabs || [], lastSeen: result.synctabs_my_last_seen || null };
@@ -233,8 +315,9 @@ async function connectWebSocket() {
     serverDetected = true;
     // Capture local reference — module-level `ws` may be replaced during awaits
     const socket = ws;
+    const identity = await getInstallIdentity();
     if (socket.readyState !== WebSocket.OPEN) return;
-    socket.send(JSON.stringify({ type: 'register', browserId, browserName }));
+    socket.send(JSON.stringify(registerMessage(identity)));
     const tabs = await collectTabs();
     await saveTabsLocally(tabs);
     if (socket.readyState !== WebSocket.OPEN) return;
@@ -254,7 +337,7 @@ async function connectWebSocket() {
         for (const [id, data] of Object.entries(msg.browsers || {})) {
           if (id === browserId) continue;         // skip self
           if (!id || id === 'null') continue;     // skip broken entries
-          cleaned[id] = data;
+          cleaned[id] = { ...data, alias: msg.aliases?.[id] || null };
         }
         await saveRemoteBrowsers(cleaned);
         notifyPopup({ type: 'state-updated', browsers: cleaned });
@@ -269,11 +352,32 @@ async function connectWebSocket() {
           tabs: msg.tabs,
           lastSeen: msg.lastSeen,
           online: msg.online,
+          alias: msg.alias || null,
         };
         await saveRemoteBrowsers(remote);
         notifyPopup({ type: 'state-updated', browsers: remote });
         break;
       }
+      case 'alias-updated': {
+        if (msg.browserId === browserId) break;
+        const remote3 = filterSelfFromRemote(await getRemoteBrowsers());
+        if (remote3[msg.browserId]) {
+          remote3[msg.browserId].alias = msg.alias || null;
+          await saveRemoteBrowsers(remote3);
+          notifyPopup({ type: 'state-updated', browsers: remote3 });
+        }
+        break;
+      }
+      case 'browser-removed': {
+        // Forgotten, expired or replaced by a reinstall
+        const remote4 = filterSelfFromRemote(await getRemoteBrowsers());
+        if (remote4[msg.browserId]) {
+          delete remote4[msg.browserId];
+          await saveRemoteBrowsers(remote4);
+          notifyPopup({ type: 'state-updated', browsers: remote4 });
+        }
+        break;
+      }
       case 'presence': {
         if (msg.browserId === browserId) break;
         if (!msg.browserId || msg.browserId === 'null') break;
@@ -288,13 +392,7 @@ async function connectWebSocket() {
       }
       case 'pending-tabs': {
         const tabs = msg.tabs || [];
-        let opened = 0;
-        for (const pt of tabs) {
-          if (pt.url && isValidUrl(pt.url)) {
-            chrome.tabs.create({ url: pt.url, active: false });
-            opened++;
-          }
-        }
+        const opened = await openTabs(tabs);
         if (opened > 0) {
           const sender = tabs[0]?.senderBrowserName || 'Another browser';
           notifyPopup({ type: 'tabs-received', count: opened, senderName: sender });
@@ -302,7 +400,71 @@ async function connectWebSocket() {
         break;
       }
       case 'send-tab-ack': {
-        notifyPopup({ type: 'send-tab-ack', status: msg.status, targetBrowserId: msg.targetBrowserId });
+        notifyPopup({ type: 'send-tab-ack', status: msg.status, targetBrowserId: msg.targetBrowserId, confirmed: !!msg.confirmed });
+        break;
+      }
+      case 'send-tab-rejected': {
+        notifyPopup({ type: 'send-tab-rejected', reason: msg.reason, message: msg.message, targetBrowserId: msg.targetBrowserId });
+        break;
+      }
+      case 'handoff-result': {
+        // Progress of a tab we are moving; on success the companion also
+        // sends close-tabs for it
+        notifyPopup({ type: 'handoff-result', status: msg.status, reason: msg.reason, message: msg.message, sourceClosed: !!msg.sourceClosed });
+        break;
+      }
+      case 'confirm-tabs': {
+        const awaiting = (msg.tabs || []).filter(t => t.id && t.url && isValidUrl(t.url));
+        await saveAwaitingConfirm(awaiting);
+        notifyPopup({ type: 'confirm-tabs', tabs: awaiting });
+        break;
+      }
+      case 'close-tabs': {
+        // Companion-initiated close (dedupe, ...). Tabs already gone are skipped.
+        const closed = await closeTabs(msg.tabIds);
+        if (closed > 0) notifyPopup({ type: 'tabs-closed', count: closed, reason: msg.reason || '' });
+        break;
+      }
+      case 'handoff-open': {
+        // Another browser is moving a tab here; report back so it can close its copy
+        const tab = msg.tab || {};
+        let ok = false;
+        let error = '';
+        if (!tab.url || !isValidUrl(tab.url)) {
+          error = 'Invalid URL';
+        } else {
+          try {
+            const created = await chrome.tabs.create({ url: tab.url, active: false, pinned: !!tab.pinned });
+            rememberHandoffTab(msg.handoffId, created.id);
+            ok = true;
+          } catch (err) { error = err.message; }
+        }
+        if (ws && ws.readyState === WebSocket.OPEN) {
+          ws.send(JSON.stringify({ type: 'handoff-opened', handoffId: msg.handoffId, ok, error }));
+        }
+        if (ok) notifyPopup({ type: 'tabs-received', count: 1, senderName: tab.senderBrowserName || 'Another browser' });
+        break;
+      }
+      case 'handoff-cancel': {
+        // Rolled back: the tab stays in its browser, so drop our copy
+        const tabId = handoffTabs.get(msg.handoffId);
+        handoffTabs.delete(msg.handoffId);
+        if (tabId !== undefined) await closeTabs([tabId]);
+        break;
+      }
+      case 'mirror-open': {
+        // A mirror rule opened this URL in another browser
+        if (msg.url && isValidUrl(msg.url)) {
+          if (msg.windowName) {
+            try { await openInNamedWindow(msg.windowName, msg.url, !!msg.pinned); } catch (err) { console.warn('[SyncTabs] Could not open tab:', err.message); }
+          } else {
+            await openTabs([{ url: msg.url, pinned: !!msg.pinned }]);
+          }
+        }
+        break;
+      }
+      case 'mirror-close': {
+        await closeTabs(msg.tabIds);
         break;
       }
     }
@@ -319,6 +481,72 @@ async function connectWebSocket() {
   ws.onerror = () => { console.warn('[SyncTabs] WS error'); };
 }
 
+// ─── Companion Tab Commands ───────────────────────────────────────────────────
+// Opens tabs sent by the companion. Tabs numbered with a window (workspace
+// restores) open together in a new window per number, as do the tabs of a
+// collapsed "N tabs waiting" entry; the rest open in the current window.
+// Pinned tabs are pinned. Returns how many opened.
+async function openTabs(tabs) {
+  const loose = [];
+  const windows = new Map();
+  const groups = [];
+  for (const pt of tabs || []) {
+    if (Array.isArray(pt.bundle)) {
+      const valid = pt.bundle.filter(b => b.url && isValidUrl(b.url));
+      if (valid.length > 0) groups.push(valid);
+      continue;
+    }
+    if (!pt.url || !isValidUrl(pt.url)) continue;
+    if (pt.window > 0) {
+      if (!windows.has(pt.window)) windows.set(pt.window, []);
+      windows.get(pt.window).push(pt);
+    } else {
+      loose.push(pt);
+    }
+  }
+
+  let opened = 0;
+  for (const pt of loose) {
+    try {
+      await chrome.tabs.create({ url: pt.url, active: false, pinned: !!pt.pinned });
+      opened++;
+    } catch (err) { console.warn('[SyncTabs] Could not open tab:', err.message); }
+  }
+  for (const group of [...windows.values(), ...groups]) {
+    try {
+      const win = await chrome.windows.create({ url: group.map(pt => pt.url), focused: false });
+      opened += group.length;
+      const created = win.tabs || [];
+      for (let i = 0; i < group.length && i < created.length; i++) {
+        if (group[i].pinned) await chrome.tabs.update(created[i].id, { pinned: true });
+      }
+    } catch (err) { console.warn('[SyncTabs] Could not open window:', err.message); }
+  }
+  return opened;
+}
+
+// Tabs opened for a handoff, by handoff ID, until the companion could
+// still cancel it
+const handoffTabs = new Map();
+const HANDOFF_FORGET_MS = 60000;
+
+function rememberHandoffTab(handoffId, tabId) {
+  if (!handoffId) return;
+  handoffTabs.set(handoffId, tabId);
+  setTimeout(() => handoffTabs.delete(handoffId), HANDOFF_FORGET_MS);
+}
+
+// Closes tabs by ID one at a time, so one missing tab doesn't abort the rest.
+async function closeTabs(tabIds) {
+  let closed = 0;
+  for (const id of tabIds || []) {
+    if (!Number.isInteger(id)) continue;
+    try { await chrome.tabs.remove(id); closed++; }
+    catch { /* already closed */ }
+  }
+  return closed;
+}
+
 function scheduleReconnect() {
   if (reconnectTimer) clearTimeout(reconnectTimer);
   reconnectTimer = setTimeout(async () => {
@@ -352,7 +580,25 @@ chrome.tabs.onAttached.addListener(debouncedTabSync);
 chrome.tabs.onDetached.addListener(debouncedTabSync);
 chrome.tabs.onReplaced.addListener(debouncedTabSync);
 chrome.windows.onCreated.addListener(debouncedTabSync);
-chrome.windows.onRemoved.addListener(async () => { await saveSnapshot(); debouncedTabSync(); });
+chrome.windows.onRemoved.addListener(async (windowId) => {
+  await saveSnapshot();
+  if ((await getWindowNames())[windowId]) await setWindowName(windowId, '');
+  debouncedTabSync();
+});
+chrome.tabs.onActivated.addListener(debouncedTabSync);
+
+// Report window focus so the companion credits usage time only to the
+// browser in front. Switching between windows briefly reports no window,
+// so the last state within the debounce wins.
+let focusTimeout = null;
+chrome.windows.onFocusChanged.addListener((windowId) => {
+  if (focusTimeout) clearTimeout(focusTimeout);
+  focusTimeout = setTimeout(() => {
+    if (ws && ws.readyState === WebSocket.OPEN) {
+      ws.send(JSON.stringify({ type: 'focus', focused: windowId !== chrome.windows.WINDOW_ID_NONE }));
+    }
+  }, 250);
+});
 
 // ─── Popup / Options Communication ────────────────────────────────────────────
 function notifyPopup(message) {
@@ -370,6 +616,8 @@ chrome.runtime.onMessage.addListener((msg, _sender, sendResponse) => {
         const localTabs = await getLocalTabs();
         const snapshot = await getSnapshot();
         const hasPerm = await hasHostPermission();
+        const awaitingConfirm = await getAwaitingConfirm();
+        const identity = await getInstallIdentity();
         sendResponse({
           connected: isConnected,
           serverDetected,
@@ -382,6 +630,8 @@ chrome.runtime.onMessage.addListener((msg, _sender, sendResponse) => {
           remoteBrowsers: filtered,
           snapshot,
           settings,
+          awaitingConfirm,
+          profileLabel: identity.profileLabel,
         });
       })();
       return true;
@@ -476,6 +726,28 @@ chrome.runtime.onMessage.addListener((msg, _sender, sendResponse) => {
       })();
       return true;
     }
+    case 'set-profile-label': {
+      (async () => {
+        await waitForInit();
+        try {
+          const identity = await setProfileLabel(msg.profileLabel);
+          // Register again so the companion records the label now
+          if (ws && ws.readyState === WebSocket.OPEN) ws.send(JSON.stringify(registerMessage(identity)));
+          sendResponse({ ok: true, profileLabel: identity.profileLabel });
+        } catch (err) {
+          sendResponse({ ok: false, error: err.message });
+        }
+      })();
+      return true;
+    }
+    case 'set-window-name': {
+      (async () => {
+        await setWindowName(msg.windowId, msg.name);
+        debouncedTabSync();
+        sendResponse({ ok: true });
+      })();
+      return true;
+    }
     case 'get-settings': {
       sendResponse({ settings });
       return false;
@@ -504,6 +776,39 @@ chrome.runtime.onMessage.addListener((msg, _sender, sendResponse) => {
       return true;
     }
 
+    case 'handoff-tab': {
+      // Move a tab: the companion closes ours once the target opened it
+      (async () => {
+        await waitForInit();
+        if (ws && ws.readyState === WebSocket.OPEN) {
+          ws.send(JSON.stringify({
+            type: 'handoff-tab',
+            targetBrowserId: msg.targetBrowserId,
+            tab: msg.tab,
+          }));
+          sendResponse({ ok: true });
+        } else {
+          sendResponse({ ok: false, error: 'Not connected to server' });
+        }
+      })();
+      return true;
+    }
+
+    case 'confirm-tab': {
+      (async () => {
+        await waitForInit();
+        if (!ws || ws.readyState !== WebSocket.OPEN) {
+          sendResponse({ ok: false, error: 'Not connected to server' });
+          return;
+        }
+        ws.send(JSON.stringify({ type: 'confirm-tab', itemId: msg.itemId, accept: !!msg.accept }));
+        const awaiting = (await getAwaitingConfirm()).filter(t => t.id !== msg.itemId);
+        await saveAwaitingConfirm(awaiting);
+        sendResponse({ ok: true, awaitingConfirm: awaiting });
+      })();
+      return true;
+    }
+
     // ─── Companion App Config API ────────────────────────────────────────────
     case 'get-companion-config': {
       (async () => {
@@ -10,6 +10,7 @@ const browserIdEl = document.getElementById('browser-id');
 const toggleServer = document.getElementById('toggle-server');
 const inputServerUrl = document.getElementById('input-server-url');
 const toggleAutoDetect = document.getElementById('toggle-auto-detect');
+const inputProfileLabel = document.getElementById('input-profile-label');
 const selectTheme = document.getElementById('select-theme');
 const btnSave = document.getElementById('btn-save');
 const btnTest = document.getElementById('btn-test');
@@ -45,6 +46,7 @@ async function init() {
       toggleServer.checked = settings.serverEnabled !== false;
       inputServerUrl.value = settings.serverUrl || 'ws://127.0.0.1:9234';
       toggleAutoDetect.checked = settings.serverAutoDetect !== false;
+      inputProfileLabel.value = state.profileLabel || '';
       if (selectTheme) {
         const theme = ['dark', 'light', 'system'].includes(settings.theme) ? settings.theme : 'dark';
         selectTheme.value = theme;
@@ -76,6 +78,7 @@ btnSave.addEventListener('click', async () => {
 
   try {
     await chrome.runtime.sendMessage({ type: 'update-settings', settings: newSettings });
+    await chrome.runtime.sendMessage({ type: 'set-profile-label', profileLabel: inputProfileLabel.value });
     savedMsg.classList.add('show');
     setTimeout(() => savedMsg.classList.remove('show'), 2000);
     // Refresh status after save
@@ -10,6 +10,13 @@ const BROWSER_ICONS = {
   'Chromium Browser': '⚪',
 };
 
+// Why a tab move was rolled back (companion handoff-result reasons)
+const HANDOFF_ROLLBACK = {
+  'timeout': 'The other browser did not confirm in time; the tab stays here',
+  'target-failed': 'The other browser could not open the tab; it stays here',
+  'target-disconnected': 'The other browser went offline; the tab stays here',
+};
+
 // Internal URL prefixes where send/incognito actions don't make sense
 const INTERNAL_PROTOCOLS = ['chrome:', 'edge:', 'about:', 'chrome-extension:', 'brave:'];
 
@@ -36,6 +43,9 @@ const btnEnableSync = document.getElementById('btn-enable-sync');
 const btnDismissBanner = document.getElementById('btn-dismiss-banner');
 const linkCompanion = document.getElementById('link-companion');
 const toastContainer = document.getElementById('toast-container');
+const confirmSection = document.getElementById('section-confirm');
+const confirmCount = document.getElementById('confirm-count');
+const confirmTabsEl = document.getElementById('confirm-tabs');
 
 // ─── State ────────────────────────────────────────────────────────────────────
 let state = {
@@ -49,6 +59,7 @@ let state = {
   remoteBrowsers: {},
   snapshot: { tabs: [], time: null },
   settings: {},
+  awaitingConfirm: [],
 };
 
 // Session-scoped set of hidden remote tabs (cosmetic removal)
@@ -85,6 +96,7 @@ function showToast(message, type = 'success') {
 function render() {
   renderConnectionStatus();
   renderServerBanner();
+  renderConfirmTabs();
   renderSelfBrowser();
   renderRemoteBrowsers();
 }
@@ -133,22 +145,70 @@ function renderSelfBrowser() {
     windows[wid].push(tab);
   }
 
+  // Windows get a label when there are several or one has a name; named
+  // windows can be picked out by the companion's mirror rules
   const windowIds = Object.keys(windows);
+  const labelled = windowIds.length > 1 || state.myTabs.some(t => t.windowName);
   for (let i = 0; i < windowIds.length; i++) {
     const tabs = windows[windowIds[i]];
-    if (windowIds.length > 1) {
+    if (labelled) {
       const isPrivate = tabs.some(t => t.incognito);
+      const name = tabs[0].windowName || '';
       const label = document.createElement('div');
       label.className = `window-label${isPrivate ? ' window-label-private' : ''}`;
       label.textContent = isPrivate
         ? `Private Window (${tabs.length} tabs)`
-        : `Window ${i + 1} (${tabs.length} tabs)`;
+        : `${name || `Window ${i + 1}`} (${tabs.length} tabs)`;
+      if (!isPrivate) {
+        label.title = 'Double-click to name this window';
+        label.addEventListener('dblclick', () => editWindowName(label, Number(windowIds[i]), name));
+      }
       selfTabsEl.appendChild(label);
     }
     for (const tab of tabs) selfTabsEl.appendChild(createTabElement(tab, true));
   }
 }
 
+// Replaces a window label with an input to rename the window
+function editWindowName(label, windowId, name) {
+  const input = document.createElement('input');
+  input.className = 'window-name-input';
+  input.value = name;
+  input.maxLength = 100;
+  input.placeholder = 'Window name';
+  let done = false;
+  const save = async (keep) => {
+    if (done) return;
+    done = true;
+    if (keep && input.value.trim() !== name) {
+      await chrome.runtime.sendMessage({ type: 'set-window-name', windowId, name: input.value });
+      showToast(input.value.trim() ? `Window named "${input.value.trim()}"` : 'Window name cleared');
+      // The background saves the renamed tabs after its debounce
+      setTimeout(async () => {
+        const fresh = await chrome.runtime.sendMessage({ type: 'get-state' });
+        if (fresh) { state = fresh; render(); }
+      }, (state.settings.syncDebounceMs || 1000) + 200);
+    }
+    renderSelfBrowser();
+  };
+  input.addEventListener('keydown', (e) => {
+    if (e.key === 'Enter') save(true);
+    else if (e.key === 'Escape') { e.stopPropagation(); save(false); }
+  });
+  input.addEventListener('blur', () => save(true));
+  label.replaceWith(input);
+  input.focus();
+}
+
+// Names the current window even when it is the only one
+async function nameCurrentWindow() {
+  const win = await chrome.windows.getCurrent();
+  const tab = state.myTabs.find(t => t.windowId === win.id);
+  const label = document.createElement('div');
+  selfTabsEl.prepend(label);
+  editWindowName(label, win.id, (tab && tab.windowName) || '');
+}
+
 function renderRemoteBrowsers() {
   remoteBrowsersEl.innerHTML = '';
   const browsers = state.remoteBrowsers || {};
@@ -180,22 +240,22 @@ function renderRemoteBrowsers() {
 
   for (const id of validEntries) {
     const data = browsers[id];
-    const name = data.browserName;
+    const name = displayName(data);
     const isOnline = data.online;
     const dotClass = isOnline ? 'dot-online' : 'dot-stale';
     const lastSeenStr = data.lastSeen ? formatLastSeen(data.lastSeen) : 'unknown';
     // Filter out hidden tabs
     const visibleTabs = (data.tabs || []).filter(t => !hiddenRemoteTabs.has(tabKey(t)));
     const tabCount = visibleTabs.length;
-    const icon = BROWSER_ICONS[name] || '🌐';
+    const icon = displayIcon(data);
 
     const section = document.createElement('section');
     section.className = 'section';
     section.innerHTML = `
       <div class="section-header">
         <div class="section-title">
-          <span class="browser-icon">${icon}</span>
-          <span>${name}</span>
+          <span class="browser-icon"></span>
+          <span class="browser-name"></span>
           <span class="tab-count">${tabCount}</span>
           <span class="dot ${dotClass}" style="margin-left:6px"></span>
           <span class="last-seen">${isOnline ? 'online' : lastSeenStr}</span>
@@ -207,6 +267,13 @@ function renderRemoteBrowsers() {
       <div class="tab-list"></div>
     `;
 
+    // Aliases are user text: set them as text, never as markup
+    section.querySelector('.browser-icon').textContent = icon;
+    const nameEl = section.querySelector('.browser-name');
+    nameEl.textContent = name;
+    if (data.alias?.color) nameEl.style.color = data.alias.color;
+    if (name !== data.browserName) nameEl.title = data.browserName;
+
     const tabList = section.querySelector('.tab-list');
     if (tabCount === 0) {
       tabList.innerHTML = '<div class="empty-state"><p>No tabs saved</p></div>';
@@ -222,6 +289,39 @@ function renderRemoteBrowsers() {
   }
 }
 
+// Tabs sent to this browser that its incoming rules hold for confirmation
+function renderConfirmTabs() {
+  const awaiting = state.awaitingConfirm || [];
+  confirmSection.style.display = awaiting.length > 0 ? '' : 'none';
+  confirmCount.textContent = awaiting.length;
+  confirmTabsEl.innerHTML = '';
+  for (const tab of awaiting) {
+    const item = createTabElement(tab, false);
+    item.removeAttribute('href');
+    item.title = `From ${tab.senderBrowserName || 'another browser'}`;
+    item.querySelector('.tab-actions').replaceChildren(
+      createActionBtn('accept', 'Accept and open', SVG_CHECK, () => handleConfirmTab(tab, true)),
+      createActionBtn('close', 'Decline', SVG_CLOSE, () => handleConfirmTab(tab, false)),
+    );
+    confirmTabsEl.appendChild(item);
+  }
+}
+
+async function handleConfirmTab(tab, accept) {
+  try {
+    const result = await chrome.runtime.sendMessage({ type: 'confirm-tab', itemId: tab.id, accept });
+    if (!result?.ok) {
+      showToast(result?.error || 'Failed to answer', 'error');
+      return;
+    }
+    state.awaitingConfirm = result.awaitingConfirm;
+    renderConfirmTabs();
+    showToast(accept ? 'Tab accepted' : 'Tab declined');
+  } catch {
+    showToast('Failed to answer', 'error');
+  }
+}
+
 // ─── Tab Element ──────────────────────────────────────────────────────────────
 function createTabElement(tab, isLocal) {
   const item = document.createElement(isLocal ? 'div' : 'a');
@@ -246,9 +346,17 @@ function createTabElement(tab, isLocal) {
   }
 
   // Favicon
-  const faviconUrl = tab.favIconUrl || getFaviconFromUrl(tab.url);
+  const cachedFavicon = isFaviconHash(tab.favIconUrl);
+  const faviconUrl = cachedFavicon ? null : (tab.favIconUrl || getFaviconFromUrl(tab.url));
   let favicon;
-  if (faviconUrl && !faviconUrl.startsWith('chrome://') && !faviconUrl.startsWith('edge://') && !faviconUrl.startsWith('chrome-extension://') && !faviconUrl.startsWith('extension://') && !faviconUrl.startsWith('brave://')) {
+  if (cachedFavicon) {
+    favicon = document.createElement('img');
+    favicon.className = 'tab-favicon';
+    favicon.onerror = function () { this.replaceWith(createFaviconPlaceholder(tab.title)); };
+    loadCompanionFavicon(tab.favIconUrl)
+      .then(src => { favicon.src = src; })
+      .catch(() => favicon.onerror());
+  } else if (faviconUrl && !faviconUrl.startsWith('chrome://') && !faviconUrl.startsWith('edge://') && !faviconUrl.startsWith('chrome-extension://') && !faviconUrl.startsWith('extension://') && !faviconUrl.startsWith('brave://')) {
     favicon = document.createElement('img');
     favicon.className = 'tab-favicon';
     favicon.src = faviconUrl;
@@ -305,6 +413,7 @@ function createTabElement(tab, isLocal) {
   // Send to another browser (only for local tabs when server is connected and has remote browsers)
   if (isLocal && state.connected && hasRemote && tab.url && !isInternal) {
     actions.appendChild(createActionBtn('send', 'Send to another browser', SVG_SEND, (e) => handleSendTab(e, tab)));
+    actions.appendChild(createActionBtn('move', 'Move to another browser', SVG_MOVE, (e) => handleSendTab(e, tab, true)));
   }
 
   // Close / Remove
@@ -356,6 +465,16 @@ const SVG_SEND = `<svg width="13" height="13" viewBox="0 0 24 24" fill="none" st
   <path d="M14 6l6 6-6 6"/>
 </svg>`;
 
+const SVG_MOVE = `<svg width="13" height="13" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round">
+  <path d="M10 4H5a1 1 0 0 0-1 1v14a1 1 0 0 0 1 1h5"/>
+  <path d="M10 12h11"/>
+  <path d="M17 8l4 4-4 4"/>
+</svg>`;
+
+const SVG_CHECK = `<svg width="13" height="13" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round">
+  <polyline points="20 6 9 17 4 12"/>
+</svg>`;
+
 const SVG_CLOSE = `<svg width="13" height="13" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round">
   <line x1="18" y1="6" x2="6" y2="18"/>
   <line x1="6" y1="6" x2="18" y2="18"/>
@@ -420,18 +539,21 @@ async function handleOpenIncognito(tab) {
   }
 }
 
-function handleSendTab(e, tab) {
+// handleSendTab offers the other browsers to send the tab to. With move,
+// the companion hands the tab off: it opens in an online browser and this
+// copy closes once that browser confirms.
+function handleSendTab(e, tab, move = false) {
   // Close any existing dropdown
   dismissSendDropdown();
 
   const browsers = state.remoteBrowsers || {};
   const entries = Object.entries(browsers).filter(([id, d]) => {
     if (!id || id === 'null' || id === state.browserId) return false;
-    return d && d.browserName;
+    return d && d.browserName && (!move || d.online);
   });
 
   if (entries.length === 0) {
-    showToast('No other browsers available', 'warning');
+    showToast(move ? 'No other browsers online' : 'No other browsers available', 'warning');
     return;
   }
 
@@ -443,25 +565,26 @@ function handleSendTab(e, tab) {
     const item = document.createElement('div');
     item.className = 'send-dropdown-item';
     const dotClass = data.online ? 'dot-online' : 'dot-stale';
-    const icon = BROWSER_ICONS[data.browserName] || '🌐';
-    item.innerHTML = `<span>${icon}</span><span>${data.browserName}</span><span class="dot ${dotClass}"></span>`;
+    item.innerHTML = `<span></span><span></span><span class="dot ${dotClass}"></span>`;
+    item.children[0].textContent = displayIcon(data);
+    item.children[1].textContent = displayName(data);
     item.addEventListener('click', async (ev) => {
       ev.preventDefault();
       ev.stopPropagation();
       dismissSendDropdown();
       try {
         const result = await chrome.runtime.sendMessage({
-          type: 'send-tab',
+          type: move ? 'handoff-tab' : 'send-tab',
           targetBrowserId: id,
-          tab: { url: tab.url, title: tab.title, favIconUrl: tab.favIconUrl },
+          tab: { id: tab.id, url: tab.url, title: tab.title, favIconUrl: tab.favIconUrl },
         });
         if (result && result.ok) {
-          showToast('Tab sent!');
+          showToast(move ? 'Moving tab…' : 'Tab sent!');
         } else {
-          showToast(result?.error || 'Failed to send tab', 'error');
+          showToast(result?.error || `Failed to ${move ? 'move' : 'send'} tab`, 'error');
         }
       } catch {
-        showToast('Failed to send tab', 'error');
+        showToast(`Failed to ${move ? 'move' : 'send'} tab`, 'error');
       }
     });
     dropdown.appendChild(item);
@@ -483,6 +606,15 @@ function dismissSendDropdown() {
 }
 
 // ─── Utilities ────────────────────────────────────────────────────────────────
+// displayName and displayIcon prefer the alias set in the companion.
+function displayName(data) {
+  return data.alias?.alias || data.browserName;
+}
+
+function displayIcon(data) {
+  return data.alias?.icon || BROWSER_ICONS[data.browserName] || '🌐';
+}
+
 function isInternalUrl(url) {
   if (!url) return true;
   try {
@@ -495,6 +627,29 @@ function tabKey(tab) {
   return `${tab.url || ''}::${tab.title || ''}`;
 }
 
+// Favicons cached by the companion are referenced by their bare hash. They
+// are fetched (the companion only answers extension origins, which an
+// <img> request does not send) and shown from blob URLs.
+const companionFavicons = new Map();
+
+function isFaviconHash(favIconUrl) {
+  return !!favIconUrl && /^[0-9a-f]{32}$/.test(favIconUrl);
+}
+
+function loadCompanionFavicon(hash) {
+  if (!companionFavicons.has(hash)) {
+    const serverUrl = state.settings?.serverUrl;
+    const httpUrl = (serverUrl || '').replace('ws://', 'http://').replace('wss://', 'https://');
+    companionFavicons.set(hash, (async () => {
+      if (!serverUrl) throw new Error('No companion');
+      const resp = await fetch(`${httpUrl}/favicon/${hash}`);
+      if (!resp.ok) throw new Error(`HTTP ${resp.status}`);
+      return URL.createObjectURL(await resp.blob());
+    })());
+  }
+  return companionFavicons.get(hash);
+}
+
 function getFaviconFromUrl(url) {
   if (!url) return null;
   try {
@@ -529,6 +684,12 @@ document.querySelector('#section-self .section-header').addEventListener('click'
   document.getElementById('section-self').classList.toggle('collapsed');
 });
 
+document.getElementById('btn-name-window').addEventListener('click', (e) => {
+  e.stopPropagation();
+  document.getElementById('section-self').classList.remove('collapsed');
+  nameCurrentWindow().catch(() => showToast('Could not name this window', 'error'));
+});
+
 btnSettings.addEventListener('click', () => chrome.runtime.openOptionsPage());
 
 btnSync.addEventListener('click', async () => {
@@ -626,7 +787,7 @@ document.getElementById('footer-credit')?.addEventListener('click', (e) => {
 
 // Dismiss send dropdown on click outside or Escape
 document.addEventListener('click', (e) => {
-  if (activeSendDropdown && !e.target.closest('.send-dropdown') && !e.target.closest('[data-action="send"]')) {
+  if (activeSendDropdown && !e.target.closest('.send-dropdown') && !e.target.closest('[data-action="send"], [data-action="move"]')) {
     dismissSendDropdown();
   }
 });
@@ -654,11 +815,34 @@ chrome.runtime.onMessage.addListener((msg) => {
   if (msg.type === 'tabs-received') {
     showToast(`${msg.count} tab(s) received from ${msg.senderName}`);
   }
+  if (msg.type === 'tabs-closed') {
+    showToast(`${msg.count} tab(s) closed by companion`);
+  }
   if (msg.type === 'send-tab-ack') {
-    if (msg.status === 'queued') {
+    if (msg.confirmed) {
+      showToast('Your tab was accepted');
+    } else if (msg.status === 'queued') {
       showToast('Tab queued (browser offline)');
+    } else if (msg.status === 'awaiting-confirmation') {
+      showToast('Tab waiting for the other browser to accept it');
     }
   }
+  if (msg.type === 'send-tab-rejected') {
+    showToast(msg.message || 'Tab rejected', 'warning');
+  }
+  if (msg.type === 'handoff-result') {
+    if (msg.status === 'completed') {
+      showToast(msg.sourceClosed ? 'Tab moved' : 'Tab opened in the other browser; this one was left open');
+    } else if (msg.status === 'rolled-back') {
+      showToast(HANDOFF_ROLLBACK[msg.reason] || 'Move cancelled; the tab stays here', 'warning');
+    } else if (msg.status === 'failed') {
+      showToast(msg.message || 'Could not move the tab', 'error');
+    }
+  }
+  if (msg.type === 'confirm-tabs') {
+    state.awaitingConfirm = msg.tabs;
+    renderConfirmTabs();
+  }
 });
 
 // Spin animation